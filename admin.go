@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deckvig/telegram-bot/download"
+	"github.com/deckvig/telegram-bot/queue"
+	"golang.org/x/time/rate"
+)
+
+// 管理员通知最多连续发送 adminBurst 条，之后每 adminInterval 一条，超出的只计数
+const (
+	adminBurst    = 5
+	adminInterval = time.Minute
+)
+
+var (
+	adminLimiter    = rate.NewLimiter(rate.Every(adminInterval), adminBurst)
+	adminMu         sync.Mutex
+	adminSuppressed int // 因限流没有发送的失败通知数，下一条通知中告诉管理员
+)
+
+// isAdminChat 判断 chatID 是否为 ADMIN_CHAT_ID，没有配置时没有管理员
+func isAdminChat(chatID int64) bool {
+	return cfg.AdminChatID != 0 && chatID == cfg.AdminChatID
+}
+
+// notifyAdminFailure 把最终失败的任务和完整错误（包括 gallery-dl 的 stderr）发送到 ADMIN_CHAT_ID
+func notifyAdminFailure(bot *Bot, job *queue.Job, attempts int, err error) {
+	if cfg.AdminChatID == 0 {
+		return
+	}
+
+	adminMu.Lock()
+	if !adminLimiter.Allow() {
+		adminSuppressed++
+		adminMu.Unlock()
+		logger.Debug("admin notification rate limited", "job_id", job.ID)
+		return
+	}
+	suppressed := adminSuppressed
+	adminSuppressed = 0
+	adminMu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "下载失败\nchat: %d\n任务: %d\nURL: %s\n尝试次数: %d\n错误: %v", job.ChatID, job.ID, job.URL, attempts, err)
+	var cmdErr *download.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Stderr != "" {
+		fmt.Fprintf(&b, "\n\ngallery-dl stderr:\n%s", cmdErr.Stderr)
+	}
+	if suppressed > 0 {
+		fmt.Fprintf(&b, "\n\n（限流期间另有 %d 条失败通知未发送，详见日志）", suppressed)
+	}
+	if _, err := bot.SendMessage(cfg.AdminChatID, b.String()); err != nil {
+		logger.Warn("failed to notify admin", "admin_chat_id", cfg.AdminChatID, "job_id", job.ID, "error", err)
+	}
+}
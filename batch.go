@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deckvig/telegram-bot/queue"
+)
+
+// batchKey 标识同一条消息产生的所有任务
+type batchKey struct {
+	bot       string
+	chatID    int64
+	messageID int64
+}
+
+// batch 汇总一条消息中所有 URL 的下载结果，任务可能并发执行并乱序完成
+type batch struct {
+	started   time.Time
+	total     int  // 已入队的任务数
+	sealed    bool // 入队结束后 total 才是最终值
+	succeeded int
+	failed    []string // 失败的 URL 及原因
+}
+
+var batches = struct {
+	mu sync.Mutex
+	m  map[batchKey]*batch
+}{m: make(map[batchKey]*batch)}
+
+// startBatch 在 msg 的 URL 入队前调用，入队结束后需要调用 sealBatch
+func startBatch(bot *Bot, msg *Message) {
+	batches.mu.Lock()
+	defer batches.mu.Unlock()
+	batches.m[batchKey{bot.ID, msg.Chat.ID, msg.MessageID}] = &batch{started: time.Now()}
+}
+
+// addToBatch 记录 msg 又有一个任务入队
+func addToBatch(bot *Bot, msg *Message) {
+	batches.mu.Lock()
+	defer batches.mu.Unlock()
+	if b := batches.m[batchKey{bot.ID, msg.Chat.ID, msg.MessageID}]; b != nil {
+		b.total++
+	}
+}
+
+// sealBatch 表示 msg 的所有任务都已入队；如果它们已经全部完成，立即发送汇总
+func sealBatch(bot *Bot, msg *Message) {
+	key := batchKey{bot.ID, msg.Chat.ID, msg.MessageID}
+	batches.mu.Lock()
+	b := batches.m[key]
+	if b == nil {
+		batches.mu.Unlock()
+		return
+	}
+	b.sealed = true
+	done := b.succeeded+len(b.failed) >= b.total
+	if done {
+		delete(batches.m, key)
+	}
+	batches.mu.Unlock()
+
+	if done {
+		sendBatchSummary(bot, key, b)
+	}
+}
+
+// finishBatchJob 记录 job 的结果，同一条消息的最后一个任务完成时发送汇总
+func finishBatchJob(job *queue.Job, err error) {
+	key := batchKey{job.Bot, job.ChatID, job.MessageID}
+	var failure string
+	if err != nil {
+		failure = langFor(job.ChatID).Sprintf("%s\n原因: %v", job.URL, err)
+	}
+	batches.mu.Lock()
+	b := batches.m[key]
+	if b == nil {
+		// 单独入队的任务（例如 /retry）或重启前留下的任务
+		batches.mu.Unlock()
+		return
+	}
+	if err != nil {
+		b.failed = append(b.failed, failure)
+	} else {
+		b.succeeded++
+	}
+	done := b.sealed && b.succeeded+len(b.failed) >= b.total
+	if done {
+		delete(batches.m, key)
+	}
+	batches.mu.Unlock()
+
+	if done {
+		sendBatchSummary(botFor(job.Bot), key, b)
+	}
+}
+
+// sendBatchSummary 回复汇总，只有一个 URL 时每个任务的通知已经足够，不再发送
+func sendBatchSummary(bot *Bot, key batchKey, b *batch) {
+	if b.total < 2 {
+		return
+	}
+	l := langFor(key.chatID)
+	text := l.Sprintf("全部完成：%d 成功, %d 失败, 耗时 %s", b.succeeded, len(b.failed), time.Since(b.started).Round(time.Second))
+	if len(b.failed) > 0 {
+		text += l.T("\n\n失败的链接：\n") + strings.Join(b.failed, "\n")
+	}
+	notifyResult(bot, key.chatID, key.messageID, text)
+}
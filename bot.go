@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/deckvig/telegram-bot/download"
+)
+
+// Bot 封装单个 bot token 的配置、HTTP 客户端和日志，多个 bot 共享同一个下载队列和 worker
+type Bot struct {
+	ID               string // token 的哈希前缀，用于日志、offset 文件名和标记任务来源，不暴露 token 本身
+	cfg              *Config
+	token            string
+	http             *http.Client
+	logger           *slog.Logger
+	downloader       download.Downloader
+	lastUpdateIDFile string // 用于存储最后一个处理的 update_id
+}
+
+// NewBot creates a Bot for token. lastUpdateIDFile 为空时使用 last_update_id_<ID>.txt
+func NewBot(cfg *Config, token, lastUpdateIDFile string, downloader download.Downloader) *Bot {
+	sum := sha256.Sum256([]byte(token))
+	id := hex.EncodeToString(sum[:])[:12]
+	if lastUpdateIDFile == "" {
+		lastUpdateIDFile = fmt.Sprintf("last_update_id_%s.txt", id)
+	}
+	return &Bot{
+		ID:               id,
+		cfg:              cfg,
+		token:            token,
+		http:             telegramClient,
+		logger:           logger.With("bot", id),
+		downloader:       downloader,
+		lastUpdateIDFile: lastUpdateIDFile,
+	}
+}
+
+// newBots 为每个 token 创建 Bot；只有一个 token 时沿用原来的 last_update_id.txt
+func newBots(cfg *Config, downloader download.Downloader) []*Bot {
+	tokens := cfg.Tokens()
+	if len(tokens) == 1 {
+		return []*Bot{NewBot(cfg, tokens[0], "last_update_id.txt", downloader)}
+	}
+	bots := make([]*Bot, 0, len(tokens))
+	for _, token := range tokens {
+		bots = append(bots, NewBot(cfg, token, "", downloader))
+	}
+	return bots
+}
+
+// botFor 返回创建任务的 bot，找不到时（例如 token 已从配置中移除）使用第一个 bot
+func botFor(id string) *Bot {
+	for _, b := range bots {
+		if b.ID == id {
+			return b
+		}
+	}
+	return bots[0]
+}
+
+// Download 使用 DOWNLOAD_MODE 选择的方式下载单个 URL
+func (b *Bot) Download(ctx context.Context, url string) (download.Result, error) {
+	return b.downloader.Download(ctx, url)
+}
+
+// getUpdates 连续失败时的退避区间
+const (
+	pollBackoffMin = time.Second
+	pollBackoffMax = time.Minute
+	// pollIdleMax 是长轮询没有生效（很快返回空结果）时两次轮询之间最长的等待
+	pollIdleMax = 10 * time.Second
+)
+
+// pollErrorKind 区分 getUpdates 失败的原因，便于从日志判断是网络、代理还是 Telegram 的问题
+func pollErrorKind(err error) string {
+	var transportErr *TransportError
+	var responseErr *ResponseError
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &transportErr):
+		return "transport"
+	case errors.As(err, &responseErr):
+		// 通常是代理或网关返回的错误页
+		return "response"
+	case errors.As(err, &apiErr):
+		return "api"
+	default:
+		return "other"
+	}
+}
+
+// Run 通过 getUpdates 长轮询获取并处理消息，直到 ctx 被取消
+func (b *Bot) Run(ctx context.Context) error {
+	// 如果之前注册过 webhook，getUpdates 会被 Telegram 拒绝
+	if err := b.deleteWebhook(); err != nil {
+		b.logger.Warn("failed to delete webhook", "error", err)
+	}
+
+	lastUpdateID, err := b.getLastUpdateID()
+	if err != nil {
+		return fmt.Errorf("failed to read last update ID: %w", err)
+	}
+
+	backoff := pollBackoffMin
+	failures := 0 // 连续失败的 getUpdates 次数
+	var idle time.Duration
+	for ctx.Err() == nil {
+		b.logger.Debug("start get update message")
+		start := time.Now()
+		updates, err := b.GetUpdates(ctx, lastUpdateID)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				// 连接卡住，直接重新发起长轮询
+				b.logger.Warn("getUpdates timed out, polling again", "timeout", b.pollRequestTimeout().String())
+				continue
+			}
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && apiErr.IsConflict() {
+				// 两个轮询实例无法共存，继续重试只会互相抢占
+				return fmt.Errorf("getUpdates conflict: another instance is polling with the same token or a webhook is set: %w", err)
+			}
+			if apiErr != nil && apiErr.IsUnauthorized() {
+				return fmt.Errorf("getUpdates unauthorized: the bot token is invalid or was revoked: %w", err)
+			}
+
+			// 多个 bot 或多个实例在网络恢复时不会同时重新请求
+			delay := jitter(backoff)
+			if apiErr != nil && apiErr.IsTooManyRequests() && apiErr.RetryAfter > 0 {
+				delay = apiErr.RetryAfter
+			}
+			failures++
+			b.logger.Error("failed to get updates", "kind", pollErrorKind(err), "error", err, "failures", failures, "retry_in", delay.Round(time.Millisecond).String(), "backoff", backoff.String())
+			sleepContext(ctx, delay)
+			backoff = min(backoff*2, pollBackoffMax)
+			continue
+		}
+		if failures > 0 {
+			b.logger.Info("getUpdates recovered", "failures", failures)
+		}
+		backoff, failures = pollBackoffMin, 0
+
+		b.logger.Debug("got updates", "count", len(updates))
+		for _, update := range updates {
+			b.safeHandleUpdate(update)
+
+			// 每处理完一个 update 就保存 offset，进程中途退出时不会重复处理已完成的 update
+			if update.UpdateID > lastUpdateID {
+				lastUpdateID = update.UpdateID
+				if err := b.saveLastUpdateID(lastUpdateID); err != nil {
+					b.logger.Error("failed to save last update ID", "error", err)
+				}
+			}
+		}
+
+		if len(updates) > 0 {
+			b.waitForQueue(ctx)
+		}
+
+		// 有新消息或长轮询已经等待过时立即继续；空结果很快返回时逐步延长等待，避免忙轮询。
+		// timeout 按整秒发送，不足 1s 时 Telegram 立即返回，只能算短轮询
+		if longPoll := b.cfg.PollTimeout.Truncate(time.Second); len(updates) > 0 || (longPoll > 0 && time.Since(start) >= longPoll/2) {
+			idle = 0
+			continue
+		}
+		idle = min(max(idle*2, pollBackoffMin), pollIdleMax)
+		b.logger.Debug("no updates, waiting before next poll", "duration", idle.String())
+		sleepContext(ctx, idle)
+	}
+
+	b.logger.Info("polling stopped")
+	return nil
+}
+
+// waitForQueue 在待下载任务达到 MAX_BATCH 时暂停轮询，等 worker 消化一部分后再取新的 update。
+// 已入队的 URL 留在持久化队列中，不会丢弃
+func (b *Bot) waitForQueue(ctx context.Context) {
+	paused := false
+	for ctx.Err() == nil {
+		pending, err := jobQueue.Pending()
+		if err != nil {
+			b.logger.Warn("failed to count pending jobs", "error", err)
+			return
+		}
+		if pending < b.cfg.MaxBatch {
+			if paused {
+				b.logger.Info("download queue drained, resuming polling", "pending", pending)
+			}
+			return
+		}
+		if !paused {
+			b.logger.Info("download queue is full, pausing polling", "pending", pending, "max_batch", b.cfg.MaxBatch)
+			paused = true
+		}
+		sleepContext(ctx, pollBackoffMin)
+	}
+}
+
+// safeHandleUpdate 处理单个 update，panic 时记录日志并跳过该 update，避免阻塞后续的 update
+func (b *Bot) safeHandleUpdate(update Update) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logger.Error("panic while handling update, skipping it", "update_id", update.UpdateID, "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	handleUpdate(b, update)
+}
+
+// sleepContext 等待 d 或直到 ctx 被取消
+func sleepContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRunBacksOffAfterShortPolls(t *testing.T) {
+	tests := []struct {
+		name        string
+		pollTimeout time.Duration
+		delay       time.Duration // fake 服务返回空结果前等待的时间
+	}{
+		{"zero timeout", 0, 0},
+		{"sub-second timeout", 500 * time.Millisecond, 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeTelegram(t)
+			f.handle("getUpdates", func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.delay)
+				writeJSON(w, http.StatusOK, map[string]any{"ok": true, "result": []any{}})
+			})
+			c := testConfig()
+			c.PollTimeout = tt.pollTimeout
+			b := newTestBot(t, f, c, nil)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+			defer cancel()
+			if err := b.Run(ctx); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			// 空结果后依次等待 1s、2s……，1.5s 内只应请求两次
+			if n := len(f.callsTo("getUpdates")); n == 0 || n > 3 {
+				t.Errorf("got %d getUpdates calls in 1.5s, want 1 to 3", n)
+			}
+		})
+	}
+}
+
+func TestRunPollsAgainAfterEmptyLongPoll(t *testing.T) {
+	f := newFakeTelegram(t)
+	// 模拟 Telegram 的长轮询：等满 timeout 秒再返回空结果
+	f.handle("getUpdates", func(w http.ResponseWriter, r *http.Request) {
+		seconds, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+		time.Sleep(time.Duration(seconds) * time.Second)
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "result": []any{}})
+	})
+	c := testConfig()
+	c.PollTimeout = time.Second
+	b := newTestBot(t, f, c, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3500*time.Millisecond)
+	defer cancel()
+	if err := b.Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	// 长轮询已经等待过，空结果后不再额外等待：0s、1s、2s、3s 各请求一次
+	calls := f.callsTo("getUpdates")
+	if len(calls) < 4 {
+		t.Errorf("got %d getUpdates calls in 3.5s, want at least 4", len(calls))
+	}
+	for _, call := range calls {
+		if got := call.Query.Get("timeout"); got != "1" {
+			t.Errorf("timeout = %q, want 1", got)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxCaptionLength 是 Telegram 允许的最大 caption 长度（UTF-16 码元）
+const maxCaptionLength = 1024
+
+// fileCaptions 从 gallery-dl 写入的元数据（文件路径 -> JSON 路径）生成每个文件的 caption。
+// 读取或解析失败的文件没有 caption
+func fileCaptions(l lang, metadata map[string]string) map[string]string {
+	captions := make(map[string]string, len(metadata))
+	for file, jsonPath := range metadata {
+		data, err := os.ReadFile(jsonPath)
+		if err != nil {
+			logger.Warn("failed to read metadata", "path", jsonPath, "error", err)
+			continue
+		}
+		if caption := captionFromMetadata(l, data); caption != "" {
+			captions[file] = caption
+		}
+	}
+	return captions
+}
+
+// captionFromMetadata 用元数据中的标题和作者组成 caption。作者依次取 uploader、author、user，
+// 可以是字符串，也可以是带 nickname 或 name 的对象
+func captionFromMetadata(l lang, data []byte) string {
+	var meta map[string]any
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return ""
+	}
+	title := strings.TrimSpace(metadataString(meta["title"]))
+	var author string
+	for _, key := range []string{"uploader", "author", "user"} {
+		if author = strings.TrimSpace(metadataString(meta[key])); author != "" {
+			break
+		}
+	}
+
+	caption := title
+	if author != "" {
+		if caption != "" {
+			caption += "\n"
+		}
+		caption += l.T("作者: ") + author
+	}
+	return truncateCaption(caption)
+}
+
+// metadataString 返回字符串字段，或对象字段中的 nickname/name
+func metadataString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]any:
+		if s := metadataString(v["nickname"]); s != "" {
+			return s
+		}
+		return metadataString(v["name"])
+	}
+	return ""
+}
+
+// truncateCaption 把 s 截断到 maxCaptionLength 以内，截断时以省略号结尾
+func truncateCaption(s string) string {
+	if len(utf16.Encode([]rune(s))) <= maxCaptionLength {
+		return s
+	}
+	n := 0
+	for i, r := range s {
+		// 留出一个码元给省略号
+		if n+utf16.RuneLen(r) > maxCaptionLength-1 {
+			return s[:i] + "…"
+		}
+		n += utf16.RuneLen(r)
+	}
+	return s
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+func TestTruncateCaption(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"short", "标题", "标题"},
+		{"exactly the limit", strings.Repeat("a", maxCaptionLength), strings.Repeat("a", maxCaptionLength)},
+		{"one over", strings.Repeat("a", maxCaptionLength+1), strings.Repeat("a", maxCaptionLength-1) + "…"},
+		// 中文在 UTF-8 中占 3 个字节，但只占 1 个 UTF-16 码元
+		{"cjk", strings.Repeat("中", maxCaptionLength+1), strings.Repeat("中", maxCaptionLength-1) + "…"},
+		// emoji 占 2 个码元，正好用到上限时不截断
+		{"emoji at the limit", strings.Repeat("a", maxCaptionLength-2) + "😀", strings.Repeat("a", maxCaptionLength-2) + "😀"},
+		// 放不下的 emoji 整个去掉，不会留下半个代理对
+		{"emoji over the limit", strings.Repeat("a", maxCaptionLength-2) + "😀😀", strings.Repeat("a", maxCaptionLength-2) + "…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateCaption(tt.in)
+			if got != tt.want {
+				t.Errorf("truncateCaption() = %d code units ending in %q, want %d ending in %q",
+					len(utf16.Encode([]rune(got))), got[max(0, len(got)-8):], len(utf16.Encode([]rune(tt.want))), tt.want[max(0, len(tt.want)-8):])
+			}
+			if n := len(utf16.Encode([]rune(got))); n > maxCaptionLength || !utf8.ValidString(got) {
+				t.Errorf("truncateCaption() = %d code units, valid UTF-8 %v", n, utf8.ValidString(got))
+			}
+		})
+	}
+}
+
+func TestCaptionFromMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		lang lang
+		data string
+		want string
+	}{
+		{"title and uploader", "zh", `{"title": " 春日穿搭 ", "uploader": "小红"}`, "春日穿搭\n作者: 小红"},
+		{"author object", "zh", `{"title": "vlog", "author": {"nickname": "阿明", "name": "aming"}}`, "vlog\n作者: 阿明"},
+		{"user name", "zh", `{"user": {"name": "someone"}}`, "作者: someone"},
+		{"uploader before author", "zh", `{"uploader": "", "author": "b", "user": "c"}`, "作者: b"},
+		{"title only", "zh", `{"title": "标题", "id": 12}`, "标题"},
+		{"english", "en", `{"title": "vlog", "uploader": "amy"}`, "vlog\nAuthor: amy"},
+		{"nothing usable", "zh", `{"uploader": 12}`, ""},
+		{"invalid json", "zh", `{"title":`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := captionFromMetadata(tt.lang, []byte(tt.data)); got != tt.want {
+				t.Errorf("captionFromMetadata() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// 过长的标题截断后作者不再出现，总长度仍在上限以内
+	long := `{"title": "` + strings.Repeat("长", maxCaptionLength) + `", "uploader": "小红"}`
+	got := captionFromMetadata("zh", []byte(long))
+	if n := len(utf16.Encode([]rune(got))); n != maxCaptionLength || !strings.HasSuffix(got, "…") {
+		t.Errorf("captionFromMetadata() with a long title = %d code units, want %d ending in …", n, maxCaptionLength)
+	}
+}
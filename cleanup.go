@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/deckvig/telegram-bot/queue"
+)
+
+// cleanup 删除一次下载的输出目录，并记录删除了哪些文件
+func cleanup(dir string) {
+	var removed int
+	var bytes int64
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				bytes += info.Size()
+			}
+			removed++
+			logger.Debug("removing downloaded file", "path", path)
+		}
+		return nil
+	})
+
+	if err := os.RemoveAll(dir); err != nil {
+		logger.Error("failed to clean up download directory", "dir", dir, "error", err)
+		return
+	}
+	logger.Info("cleaned up download directory", "dir", dir, "files", removed, "bytes", bytes)
+}
+
+// scheduleCleanup 按 RETENTION_MINUTES 安排删除 dir：0 表示立即删除，负数表示保留。
+// 没有开启上传（UPLOAD_FILES 或 S3_BUCKET）时文件只存在于服务器上，不会立即删除
+func scheduleCleanup(dir string) {
+	if dir == "" || cfg.RetentionMinutes < 0 {
+		return
+	}
+	if cfg.RetentionMinutes == 0 {
+		if cfg.UploadFiles || fileStorage != nil {
+			cleanup(dir)
+		}
+		return
+	}
+
+	retention := time.Duration(cfg.RetentionMinutes) * time.Minute
+	logger.Debug("scheduled download cleanup", "dir", dir, "after", retention.String())
+	time.AfterFunc(retention, func() { cleanup(dir) })
+}
+
+// resumeInterrupted 删除上次运行中断的下载写了一半的目录，并告诉用户下载会重新开始
+func resumeInterrupted(jobs []queue.Job) {
+	for _, job := range jobs {
+		if job.Dir != "" {
+			logger.Info("removing incomplete download of interrupted job", "job_id", job.ID, "dir", job.Dir)
+			cleanup(job.Dir)
+		}
+		// 订阅的定期检查不打扰用户
+		if job.Subscription != 0 {
+			continue
+		}
+		botFor(job.Bot).sendReply(job.ChatID, job.MessageID, langFor(job.ChatID).Sprintf("机器人已重启，中断的下载将重新开始: \nURL: %s", job.URL))
+	}
+}
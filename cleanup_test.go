@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deckvig/telegram-bot/queue"
+)
+
+// withBots 把全局的 bots 替换为 b，测试结束时恢复
+func withBots(t *testing.T, b ...*Bot) {
+	saved := bots
+	bots = b
+	t.Cleanup(func() { bots = saved })
+}
+
+func TestResumeInterrupted(t *testing.T) {
+	c := withTestConfig(t)
+	withJobQueue(t)
+	f := newFakeTelegram(t)
+	b := newTestBot(t, f, c, nil)
+	withBots(t, b)
+
+	// 上次运行写了一半的目录
+	base := t.TempDir()
+	jobDir := filepath.Join(base, "5", "job")
+	subDir := filepath.Join(base, "6", "subscription")
+	for _, dir := range []string{jobDir, subDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "1.jpg.part"), []byte("half"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resumeInterrupted([]queue.Job{
+		{ID: 1, URL: "https://xhslink.com/a/1", ChatID: 5, MessageID: 10, Bot: b.ID, Dir: jobDir},
+		{ID: 2, URL: "https://www.xiaohongshu.com/user/profile/abc", ChatID: 6, Bot: b.ID, Subscription: 3, Dir: subDir},
+		{ID: 3, URL: "https://xhslink.com/a/3", ChatID: 5, MessageID: 11, Bot: b.ID},
+	})
+
+	for _, dir := range []string{jobDir, subDir} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("incomplete download %s was not removed: %v", dir, err)
+		}
+	}
+	// 订阅的定期检查不通知用户
+	calls := f.callsTo("sendMessage")
+	if len(calls) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(calls))
+	}
+	for i, want := range []struct {
+		replyTo float64
+		url     string
+	}{{10, "https://xhslink.com/a/1"}, {11, "https://xhslink.com/a/3"}} {
+		p := calls[i].Payload
+		text, _ := p["text"].(string)
+		if p["chat_id"] != float64(5) || p["reply_to_message_id"] != want.replyTo || !strings.Contains(text, "机器人已重启") || !strings.Contains(text, want.url) {
+			t.Errorf("message %d = %v, want a restart notice for %s", i, p, want.url)
+		}
+	}
+}
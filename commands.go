@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/deckvig/telegram-bot/download"
+	"github.com/deckvig/telegram-bot/queue"
+)
+
+// runInBackground 在单独的 goroutine 中执行耗时的命令，不阻塞处理后续的 update；
+// panic 时记录日志，不会让进程退出
+func runInBackground(command string, fn func()) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("panic while running command", "command", command, "panic", r, "stack", string(debug.Stack()))
+			}
+		}()
+		fn()
+	}()
+}
+
+// Command describes a slash command the bot understands
+type Command struct {
+	Name        string // 不带 / 前缀的命令名
+	Description string
+	Handler     func(bot *Bot, msg *Message, args string)
+}
+
+// commands 是所有已注册的命令，新增命令只需在 init 中追加
+var commands []Command
+
+func init() {
+	commands = []Command{
+		{Name: "start", Description: "开始使用", Handler: handleHelpCommand},
+		{Name: "help", Description: "查看使用说明", Handler: handleHelpCommand},
+		{Name: "force", Description: "忽略已下载记录，强制重新下载：/force <链接>", Handler: handleForceCommand},
+		{Name: "status", Description: "查看队列和下载状态", Handler: handleStatusCommand},
+		{Name: "queue", Description: "查看自己排队中和下载中的链接", Handler: handleQueueCommand},
+		{Name: "cancel", Description: "取消最近开始的下载，/cancel all 取消全部", Handler: handleCancelCommand},
+		{Name: "retry", Description: "重新下载最近一次失败的链接", Handler: handleRetryCommand},
+		{Name: "history", Description: "查看最近的下载记录：/history [条数]", Handler: handleHistoryCommand},
+		{Name: "ping", Description: "测试机器人到 Telegram 的延迟", Handler: handlePingCommand},
+		{Name: "settings", Description: "修改当前 chat 的设置（重试通知、下载前确认、默认画质、语言）", Handler: handleSettingsCommand},
+		{Name: "stats", Description: "查看运行时长和全局统计（仅管理员）", Handler: handleStatsCommand},
+		{Name: "diag", Description: "检查 gallery-dl、代理、后端和磁盘空间（仅管理员）", Handler: handleDiagCommand},
+		{Name: "supported", Description: "查看支持下载的平台", Handler: handleSupportedCommand},
+		{Name: "subscribe", Description: "订阅作者主页，定期下载新作品：/subscribe <链接>，不带链接时列出订阅", Handler: handleSubscribeCommand},
+		{Name: "unsubscribe", Description: "取消订阅：/unsubscribe <链接>", Handler: handleUnsubscribeCommand},
+	}
+}
+
+// BotCommand is a command shown in the Telegram command menu
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+// botCommands 返回注册到 Telegram 命令菜单的命令，与 dispatchCommand 使用同一份 commands
+func botCommands() []BotCommand {
+	cmds := make([]BotCommand, len(commands))
+	for i, cmd := range commands {
+		cmds[i] = BotCommand{Command: cmd.Name, Description: cmd.Description}
+	}
+	return cmds
+}
+
+// setMyCommands replaces the command menu Telegram shows for the bot
+func (b *Bot) setMyCommands(cmds []BotCommand) error {
+	_, err := b.callAPI("setMyCommands", map[string]interface{}{"commands": cmds})
+	return err
+}
+
+// dispatchCommand 识别并执行 slash 命令，返回 true 表示消息已作为命令处理
+func dispatchCommand(bot *Bot, msg *Message) bool {
+	text := strings.TrimSpace(msg.Text)
+	if !strings.HasPrefix(text, "/") {
+		return false
+	}
+
+	name, args := text[1:], ""
+	if i := strings.IndexFunc(name, unicode.IsSpace); i >= 0 {
+		name, args = name[:i], name[i+1:]
+	}
+	// 群组中命令可能带有 @botname 后缀
+	name, _, _ = strings.Cut(name, "@")
+	name = strings.ToLower(name)
+
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			logger.Info("handling command", "command", name, "chat_id", msg.Chat.ID)
+			cmd.Handler(bot, msg, strings.TrimSpace(args))
+			return true
+		}
+	}
+
+	bot.sendReply(msg.Chat.ID, msg.MessageID, langFor(msg.Chat.ID).Sprintf("未知命令 /%s，发送 /help 查看使用说明。", name))
+	return true
+}
+
+// handleHelpCommand 回复使用说明
+func handleHelpCommand(bot *Bot, msg *Message, args string) {
+	l := langFor(msg.Chat.ID)
+	var b strings.Builder
+	b.WriteString(l.T("直接发送包含链接的消息（例如小红书分享文本），机器人会提取其中所有以 http:// 或 https:// 开头的 URL 并下载。\n\n"))
+	b.WriteString(l.T("链接较多时可以发送每行一个链接的 .txt 文件，选项前缀写在文件的说明中。\n\n"))
+	b.WriteString(l.Sprintf("在消息开头加上选项前缀可以调整下载方式，例如 audio: <链接>。支持的选项：%s\n\n", optionHelp(l)))
+	b.WriteString(l.T("可用命令：\n"))
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "/%s - %s\n", cmd.Name, l.T(cmd.Description))
+	}
+	bot.sendReply(msg.Chat.ID, msg.MessageID, b.String())
+}
+
+// optionDescriptions 是 /help 中每个下载选项的说明
+var optionDescriptions = map[string]string{
+	download.OptionAudio: "只下载音频",
+	download.OptionHD:    "下载最高画质",
+}
+
+// optionHelp 用 l 列出支持的选项前缀及其说明
+func optionHelp(l lang) string {
+	var parts []string
+	for _, name := range download.OptionNames() {
+		parts = append(parts, l.Sprintf("%s:（%s）", name, l.T(optionDescriptions[name])))
+	}
+	return strings.Join(parts, l.T("、"))
+}
+
+// handleForceCommand 下载命令后面的 URL，即使之前已经下载过
+func handleForceCommand(bot *Bot, msg *Message, args string) {
+	enqueueURLs(bot, msg, args, true)
+}
+
+// handleStatusCommand 回复队列深度、正在进行的下载以及启动以来的成功/失败次数
+func handleStatusCommand(bot *Bot, msg *Message, args string) {
+	l := langFor(msg.Chat.ID)
+	stats := downloader.Stats()
+
+	pending, err := jobQueue.Pending()
+	if err != nil {
+		logger.Error("failed to count pending jobs", "error", err)
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("获取队列状态失败: %v", err))
+		return
+	}
+
+	text := l.Sprintf("机器人状态：\n排队中: %d\n下载中: %d\n成功: %d\n失败: %d",
+		pending+stats.Waiting, stats.Running, stats.Succeeded, stats.Failed)
+	if breaker, ok := bot.downloader.(*download.Breaker); ok {
+		text += l.T("\n后端: ") + breakerStatus(l, breaker)
+	}
+	bot.sendReply(msg.Chat.ID, msg.MessageID, text)
+}
+
+// breakerStatus 用 l 描述后端熔断器的当前状态
+func breakerStatus(l lang, breaker *download.Breaker) string {
+	state, failures, retryAt := breaker.State()
+	switch state {
+	case download.BreakerOpen:
+		return l.Sprintf("不可用（连续失败 %d 次），%s 后重试", failures, time.Until(retryAt).Round(time.Second))
+	case download.BreakerHalfOpen:
+		return l.T("正在测试是否恢复")
+	}
+	if failures > 0 {
+		return l.Sprintf("正常（最近连续失败 %d 次）", failures)
+	}
+	return l.T("正常")
+}
+
+// queueListLimit 是 /queue 回复的最大长度，留出余量给末尾的省略提示，保证只发送一条消息
+const queueListLimit = maxMessageLength - 100
+
+// handleQueueCommand 列出当前 chat 排队中和下载中的任务及其在队列中的位置
+func handleQueueCommand(bot *Bot, msg *Message, args string) {
+	l := langFor(msg.Chat.ID)
+	jobs, err := jobQueue.Unfinished(msg.Chat.ID)
+	if err != nil {
+		logger.Error("failed to load unfinished jobs", "chat_id", msg.Chat.ID, "error", err)
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("获取队列失败: %v", err))
+		return
+	}
+	if len(jobs) == 0 {
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.T("队列中没有你的下载任务。"))
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(l.Sprintf("你有 %d 个未完成的任务：\n", len(jobs)))
+	for i, job := range jobs {
+		state := l.T("下载中")
+		if job.Status == queue.StatusPending {
+			state = l.Sprintf("排队第 %d 位", job.Position)
+		}
+		entry := fmt.Sprintf("\n%d. [%s] %s\n", i+1, state, job.URL)
+		if b.Len()+len(entry) > queueListLimit {
+			b.WriteString(l.Sprintf("\n……还有 %d 个任务未显示", len(jobs)-i))
+			break
+		}
+		b.WriteString(entry)
+	}
+	bot.sendReply(msg.Chat.ID, msg.MessageID, b.String())
+}
+
+// handlePingCommand 测量一次 getMe 的往返时间。请求经过与轮询相同的 HTTP 客户端，
+// 因此结果包含代理等真实配置的影响
+func handlePingCommand(bot *Bot, msg *Message, args string) {
+	l := langFor(msg.Chat.ID)
+	start := time.Now()
+	if _, err := bot.getMe(); err != nil {
+		logger.Warn("ping failed", "chat_id", msg.Chat.ID, "error", err)
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("连接 Telegram 失败: %v", err))
+		return
+	}
+	latency := time.Since(start)
+	logger.Info("ping", "chat_id", msg.Chat.ID, "latency", latency.String())
+	bot.sendReply(msg.Chat.ID, msg.MessageID, fmt.Sprintf("pong: %d ms", latency.Milliseconds()))
+}
+
+// handleCancelCommand 取消当前 chat 正在进行的下载
+func handleCancelCommand(bot *Bot, msg *Message, args string) {
+	l := langFor(msg.Chat.ID)
+	all := false
+	switch strings.ToLower(args) {
+	case "":
+	case "all":
+		all = true
+	default:
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.T("用法：/cancel [all]"))
+		return
+	}
+
+	n := downloader.Cancel(msg.Chat.ID, all)
+	if n == 0 {
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.T("当前没有进行中的下载。"))
+		return
+	}
+	bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("已取消 %d 个下载任务。", n))
+}
+
+// handleRetryCommand 把当前 chat 最近一次失败的 URL 重新加入下载队列
+func handleRetryCommand(bot *Bot, msg *Message, args string) {
+	l := langFor(msg.Chat.ID)
+	failed, err := jobQueue.LastFailed(msg.Chat.ID)
+	if err != nil {
+		logger.Error("failed to look up last failed job", "chat_id", msg.Chat.ID, "error", err)
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("查找失败的下载失败: %v", err))
+		return
+	}
+	if failed == nil {
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.T("没有需要重试的失败下载。"))
+		return
+	}
+
+	job := queue.Job{URL: failed.URL, ChatID: msg.Chat.ID, MessageID: msg.MessageID, Force: failed.Force, Bot: bot.ID, Options: failed.Options}
+	if _, err := jobQueue.EnqueueJob(job); err != nil {
+		logger.Error("failed to enqueue url", "url", failed.URL, "chat_id", msg.Chat.ID, "error", err)
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("加入队列失败: \nURL: %s\n错误: %v", failed.URL, err))
+		return
+	}
+	logger.Info("retrying failed download", "url", failed.URL, "chat_id", msg.Chat.ID, "failed_job_id", failed.ID)
+	bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("已重新加入下载队列: \nURL: %s", failed.URL))
+}
+
+// 默认和最多展示的历史记录条数
+const (
+	defaultHistoryLimit = 10
+	maxHistoryLimit     = 50
+)
+
+// historyStatusText 历史记录状态对应的展示文字
+var historyStatusText = map[queue.Status]string{
+	queue.StatusInProgress: "进行中",
+	queue.StatusDone:       "成功",
+	queue.StatusFailed:     "失败",
+}
+
+// handleHistoryCommand 回复当前 chat 最近的下载记录
+func handleHistoryCommand(bot *Bot, msg *Message, args string) {
+	l := langFor(msg.Chat.ID)
+	limit := defaultHistoryLimit
+	if args != "" {
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 1 {
+			bot.sendReply(msg.Chat.ID, msg.MessageID, l.T("用法：/history [条数]"))
+			return
+		}
+		limit = min(n, maxHistoryLimit)
+	}
+
+	entries, err := jobQueue.History(msg.Chat.ID, limit)
+	if err != nil {
+		logger.Error("failed to load download history", "chat_id", msg.Chat.ID, "error", err)
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("获取下载记录失败: %v", err))
+		return
+	}
+	if len(entries) == 0 {
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.T("还没有下载记录。"))
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(l.Sprintf("最近 %d 条下载记录：\n", len(entries)))
+	for i, e := range entries {
+		fmt.Fprintf(&b, "\n%d. [%s] %s\n%s", i+1, l.T(historyStatusText[e.Status]), e.StartedAt.Format("2006-01-02 15:04"), e.URL)
+		if !e.FinishedAt.IsZero() {
+			b.WriteString(l.Sprintf("\n用时 %s", e.FinishedAt.Sub(e.StartedAt)))
+		}
+		if e.Files > 0 {
+			b.WriteString(l.Sprintf("，%d 个文件，%.1f MB", e.Files, float64(e.Bytes)/(1<<20)))
+		}
+		if e.Error != "" {
+			b.WriteString(l.Sprintf("\n错误: %s", e.Error))
+		}
+		b.WriteString("\n")
+	}
+	bot.sendReply(msg.Chat.ID, msg.MessageID, b.String())
+}
+
+// platformNames 是各平台展示给用户的名称
+var platformNames = map[download.Platform]string{
+	download.PlatformXiaohongshu: "小红书",
+	download.PlatformDouyin:      "抖音",
+	download.PlatformBilibili:    "B站",
+}
+
+// extractorsTimeout 是等待 gallery-dl --list-extractors 的最长时间
+const extractorsTimeout = 30 * time.Second
+
+// handleSupportedCommand 回复支持的平台；gallery-dl 模式下同时检查本机 gallery-dl 是否有对应的 extractor。
+// 第一次获取 extractor 列表需要启动 gallery-dl，在后台执行，不阻塞轮询
+func handleSupportedCommand(bot *Bot, msg *Message, args string) {
+	if cfg.DownloadMode != download.ModeGalleryDL {
+		replySupported(bot, msg, nil, nil)
+		return
+	}
+	runInBackground("supported", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), extractorsTimeout)
+		defer cancel()
+		list, err := download.Extractors(ctx)
+		if err != nil {
+			logger.Warn("failed to list gallery-dl extractors", "error", err)
+		}
+		replySupported(bot, msg, list, err)
+	})
+}
+
+// replySupported 回复支持的平台，extractors 是本机 gallery-dl 支持的站点，extractorErr 是获取失败的原因
+func replySupported(bot *Bot, msg *Message, extractors []string, extractorErr error) {
+	l := langFor(msg.Chat.ID)
+	categories := make(map[string]bool, len(extractors))
+	for _, category := range extractors {
+		categories[category] = true
+	}
+
+	var b strings.Builder
+	b.WriteString(l.T("支持的平台：\n"))
+	for _, platform := range download.Platforms {
+		b.WriteString(l.Sprintf("\n%s（%s）", l.T(platformNames[platform]), strings.Join(platform.Hosts(), l.T("、"))))
+		if len(categories) > 0 && !categories[string(platform)] {
+			b.WriteString(l.T("\n  本机 gallery-dl 没有对应的 extractor，可能无法下载"))
+		}
+	}
+	switch {
+	case errors.Is(extractorErr, download.ErrNotInstalled):
+		b.WriteString(l.T("\n\ngallery-dl 未安装，暂时无法下载。"))
+	case extractorErr != nil:
+		b.WriteString(l.Sprintf("\n\n无法获取 gallery-dl 支持的站点: %v", extractorErr))
+	case len(categories) > 0:
+		b.WriteString(l.Sprintf("\n\n本机 gallery-dl 共支持 %d 个站点，但机器人只处理以上平台的链接。", len(categories)))
+	}
+	bot.sendReply(msg.Chat.ID, msg.MessageID, b.String())
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deckvig/telegram-bot/download"
+)
+
+// fakeGalleryDL 把一个执行 script 的 gallery-dl 放到 PATH 的最前面
+func fakeGalleryDL(t *testing.T, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "gallery-dl"), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestSupportedCommandDoesNotBlock(t *testing.T) {
+	fakeGalleryDL(t, `sleep 1; echo "Category: xiaohongshu - Subcategory: user"; echo "Category: bilibili - Subcategory: video"`)
+	saved := cfg
+	cfg = testConfig()
+	cfg.DownloadMode = download.ModeGalleryDL
+	defer func() { cfg = saved }()
+	withJobQueue(t)
+	f := newFakeTelegram(t)
+	b := newTestBot(t, f, cfg, nil)
+
+	start := time.Now()
+	handleSupportedCommand(b, testMessage(5, 1, "/supported"), "")
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("handleSupportedCommand blocked for %s while gallery-dl was running", elapsed)
+	}
+
+	f.waitFor(t, 5*time.Second, func() bool { return len(f.sentTexts()) > 0 })
+	reply := f.sentTexts()[0]
+	if !strings.Contains(reply, "本机 gallery-dl 共支持 2 个站点") {
+		t.Errorf("reply does not list the installed extractors:\n%s", reply)
+	}
+	if strings.Count(reply, "没有对应的 extractor") != 1 {
+		t.Errorf("reply should flag only douyin as missing an extractor:\n%s", reply)
+	}
+}
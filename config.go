@@ -0,0 +1,611 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/deckvig/telegram-bot/download"
+	"github.com/deckvig/telegram-bot/urls"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds all bot settings. Values are read from an optional YAML file
+// and then overridden by environment variables.
+type Config struct {
+	BotToken            string        `yaml:"bot_token"`                  // TELEGRAM_BOT_TOKEN
+	BotTokens           []string      `yaml:"bot_tokens"`                 // TELEGRAM_BOT_TOKENS，逗号分隔，同时运行多个 bot
+	TelegramAPIBase     string        `yaml:"telegram_api_base"`          // TELEGRAM_API_BASE，自建 telegram-bot-api 服务时修改
+	PollTimeout         time.Duration `yaml:"poll_timeout"`               // POLL_TIMEOUT，getUpdates 长轮询的超时，1s-40s
+	AllowedUpdates      []string      `yaml:"allowed_updates"`            // ALLOWED_UPDATES，逗号分隔，只接收这些类型的 update
+	MaxMessageAge       time.Duration `yaml:"max_message_age"`            // MAX_MESSAGE_AGE，忽略发送时间早于此的消息，0 表示不限制
+	MaxBatch            int           `yaml:"max_batch"`                  // MAX_BATCH，每次 getUpdates 最多取的 update 数，待下载任务达到此数时暂停轮询
+	DownloadMode        string        `yaml:"download_mode"`              // DOWNLOAD_MODE: backend 或 gallery-dl
+	BackendURL          string        `yaml:"backend_url"`                // BACKEND_URL，backend 模式必填
+	IdempotencyHeader   string        `yaml:"backend_idempotency_header"` // BACKEND_IDEMPOTENCY_HEADER，携带幂等 key 的请求头，为空时不发送
+	BreakerThreshold    int           `yaml:"backend_breaker_threshold"`  // BACKEND_BREAKER_THRESHOLD，后端连续失败多少次后熔断，0 表示不熔断
+	BreakerCooldown     time.Duration `yaml:"backend_breaker_cooldown"`   // BACKEND_BREAKER_COOLDOWN，熔断后多久再测试后端是否恢复
+	Proxy               string        `yaml:"proxy"`                      // PROXY_URL（兼容 HTTP_PROXY），下载时使用的 http/https/socks5 代理
+	RequireProxy        bool          `yaml:"require_proxy"`              // REQUIRE_PROXY，没有配置代理或代理无法连接时拒绝启动和下载，不会直接连接
+	WebhookURL          string        `yaml:"webhook_url"`                // WEBHOOK_URL，设置后使用 webhook 模式代替长轮询
+	WebhookSecret       string        `yaml:"webhook_secret"`             // WEBHOOK_SECRET，注册 webhook 时设置的 secret_token，请求头不匹配的推送返回 401
+	TrustProxy          bool          `yaml:"trust_proxy"`                // TRUST_PROXY，webhook 位于反向代理之后时从 X-Forwarded-For/X-Real-IP 取客户端地址
+	Port                string        `yaml:"port"`                       // PORT
+	MetricsPort         string        `yaml:"metrics_port"`               // METRICS_PORT，为空时不暴露 /metrics，与 PORT 相同时复用 webhook 服务
+	QueueDB             string        `yaml:"queue_db"`                   // QUEUE_DB，持久化下载队列的 SQLite 文件
+	LockFile            string        `yaml:"lock_file"`                  // LOCK_FILE，防止同时运行多个实例的锁文件，为空时不加锁（LOCK_FILE= 也可以关闭）
+	DownloadDir         string        `yaml:"download_dir"`               // DOWNLOAD_DIR
+	ArchiveDir          string        `yaml:"archive_dir"`                // ARCHIVE_DIR，gallery-dl 的 --download-archive 目录，每个 chat 一个文件，为空时不使用
+	CookiesFile         string        `yaml:"cookies_file"`               // COOKIES_FILE，传给 gallery-dl --cookies 的 cookies.txt，用于需要登录的内容
+	Concurrency         int           `yaml:"concurrency"`                // DOWNLOAD_CONCURRENCY
+	PerChatConcurrency  int           `yaml:"per_chat_concurrency"`       // PER_CHAT_CONCURRENCY，单个 chat 同时下载的任务数上限，0 表示不限制
+	MaxRetries          int           `yaml:"max_retries"`                // MAX_RETRIES，每个 URL 最多尝试的次数
+	RetryBaseDelay      time.Duration `yaml:"retry_base_delay"`           // RETRY_BASE_DELAY
+	RetryMaxDelay       time.Duration `yaml:"retry_max_delay"`            // RETRY_MAX_DELAY
+	QuietRetries        bool          `yaml:"quiet_retries"`              // QUIET_RETRIES，只把最终结果发给用户，中间的失败尝试只写日志
+	DownloadTimeout     time.Duration `yaml:"download_timeout"`           // DOWNLOAD_TIMEOUT，单次下载尝试的最长时间，超时后终止 gallery-dl 或后端请求，0 表示不限制
+	ShutdownGrace       time.Duration `yaml:"shutdown_grace"`             // SHUTDOWN_GRACE，停止时等待正在进行的下载完成的时间，超时后终止
+	SubscribeInterval   time.Duration `yaml:"subscription_interval"`      // SUBSCRIPTION_INTERVAL，检查 /subscribe 订阅新作品的间隔
+	RateLimitPerMinute  int           `yaml:"rate_limit_per_minute"`      // RATE_LIMIT_PER_MINUTE，0 表示不限制
+	AllowedChats        []int64       `yaml:"allowed_chats"`              // ALLOWED_CHATS，逗号分隔；为空时允许所有 chat
+	AdminChatID         int64         `yaml:"admin_chat_id"`              // ADMIN_CHAT_ID，最终失败的任务连同完整错误发送到这个 chat，/stats 和 /diag 只对它开放；0 表示不发送
+	LogLevel            string        `yaml:"log_level"`                  // LOG_LEVEL
+	DryRun              bool          `yaml:"dry_run"`                    // DRY_RUN，任务照常入队，worker 只回显 URL，不调用任何下载方式
+	RequireConfirmation bool          `yaml:"require_confirmation"`       // REQUIRE_CONFIRMATION，先回复"下载/忽略"按钮，确认后才下载
+	Language            string        `yaml:"language"`                   // LANGUAGE，回复使用的语言：zh 或 en
+	URLTrimChars        string        `yaml:"url_trim_chars"`             // URL_TRIM_CHARS，从提取到的 URL 末尾去掉的字符，为空时使用默认的中英文标点
+	URLPattern          string        `yaml:"url_pattern"`                // URL_PATTERN，从消息中匹配 URL 的正则，为空时使用默认规则
+	URLJoinWrapped      bool          `yaml:"url_join_wrapped"`           // URL_JOIN_WRAPPED，拼接被客户端折行拆成两行的 URL
+	AllowedHosts        []string      `yaml:"allowed_hosts"`              // ALLOWED_HOSTS，逗号分隔，只下载这些域名（含子域名）的链接，为空时不限制
+	DeniedHosts         []string      `yaml:"denied_hosts"`               // DENIED_HOSTS，逗号分隔，不下载这些域名（含子域名）的链接，优先于 ALLOWED_HOSTS
+	ProgressInterval    time.Duration `yaml:"progress_interval"`          // PROGRESS_INTERVAL，两次编辑进度消息的最小间隔
+	UploadFiles         bool          `yaml:"upload_files"`               // UPLOAD_FILES，gallery-dl 模式下把下载的文件发回 chat
+	UploadCaptions      bool          `yaml:"upload_captions"`            // UPLOAD_CAPTIONS，发回的文件带上作品的标题和作者
+	FFmpegConvert       bool          `yaml:"ffmpeg_convert"`             // FFMPEG_CONVERT，上传前用 ffmpeg 把视频转换为 H.264/AAC MP4
+	S3Bucket            string        `yaml:"s3_bucket"`                  // S3_BUCKET，设置后 gallery-dl 模式下把下载的文件上传到这个 bucket
+	S3Region            string        `yaml:"s3_region"`                  // S3_REGION，为空时使用 AWS_REGION
+	S3Endpoint          string        `yaml:"s3_endpoint"`                // S3_ENDPOINT，S3 兼容服务（MinIO、R2 等）的地址，为空时使用 AWS
+	S3PathStyle         bool          `yaml:"s3_path_style"`              // S3_PATH_STYLE，使用 endpoint/bucket/key 形式的地址
+	S3Prefix            string        `yaml:"s3_prefix"`                  // S3_PREFIX，上传的 key 前缀
+	S3PresignExpiry     time.Duration `yaml:"s3_presign_expiry"`          // S3_PRESIGN_EXPIRY，回复预签名下载链接的有效期，0 时只回复 s3:// 地址
+	NotifyTelegram      bool          `yaml:"notify_telegram"`            // NOTIFY_TELEGRAM，是否在 Telegram 中回复下载结果
+	NotifyWebhooks      []string      `yaml:"notify_webhooks"`            // NOTIFY_WEBHOOKS，逗号分隔，下载结果同时以 JSON POST 到这些地址
+	MaxUploadBytes      int64         `yaml:"max_upload_bytes"`           // MAX_UPLOAD_BYTES，Bot API 默认限制 50MB，自建服务可调大
+	RetentionMinutes    int           `yaml:"retention_minutes"`          // RETENTION_MINUTES，上传后保留下载文件的分钟数，0 立即删除，负数不删除
+	MaxDownloadBytes    int64         `yaml:"max_download_bytes"`         // MAX_DOWNLOAD_BYTES，gallery-dl 模式单次下载的总大小上限，0 表示不限制
+	MinFreeBytes        int64         `yaml:"min_free_bytes"`             // MIN_FREE_BYTES，gallery-dl 模式开始下载前下载目录至少需要的可用空间，0 表示不检查
+	FilenameTemplate    string        `yaml:"filename_template"`          // FILENAME_TEMPLATE，gallery-dl 的 -f 文件名格式
+	GalleryDLArgs       []string      `yaml:"gallerydl_args"`             // GALLERYDL_ARGS，追加给 gallery-dl 的参数，环境变量按 shell 规则处理引号
+	// PlatformArgs 按平台（xiaohongshu/douyin/bilibili）追加给 gallery-dl 的参数，仅支持配置文件
+	PlatformArgs map[download.Platform][]string `yaml:"platform_args"`
+	// ChatProxies 按 chat ID 指定 gallery-dl 使用的代理，没有列出的 chat 使用 Proxy，值为空表示该 chat 不使用代理。
+	// CHAT_PROXIES 格式为 chatID=代理URL，逗号分隔
+	ChatProxies map[int64]string `yaml:"chat_proxies"`
+}
+
+// defaultConfig 返回未设置任何配置时使用的默认值
+func defaultConfig() *Config {
+	return &Config{
+		DownloadMode:      download.ModeBackend,
+		IdempotencyHeader: "Idempotency-Key",
+		BreakerThreshold:  5,
+		BreakerCooldown:   time.Minute,
+		ShutdownGrace:     30 * time.Second,
+		PollTimeout:       30 * time.Second,
+		MaxBatch:          maxBatch,
+		AllowedUpdates:    []string{"message", "callback_query"},
+		Port:              "8080",
+		QueueDB:           "queue.db",
+		LockFile:          "bot.lock",
+		DownloadDir:       "downloads",
+		Concurrency:       3,
+		MaxRetries:        3,
+		RetryBaseDelay:    5 * time.Second,
+		RetryMaxDelay:     2 * time.Minute,
+		LogLevel:          "info",
+		Language:          "zh",
+		ProgressInterval:  3 * time.Second,
+		SubscribeInterval: time.Hour,
+		UploadFiles:       true,
+		UploadCaptions:    true,
+		NotifyTelegram:    true,
+		MaxUploadBytes:    50 << 20,
+	}
+}
+
+// Load reads the YAML file at path (skipped when path is empty), applies
+// environment overrides and validates the result
+func Load(path string) (*Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		cfg.expandEnv()
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv 用已设置的环境变量覆盖配置文件中的值
+func (c *Config) applyEnv() error {
+	envString(&c.BotToken, "TELEGRAM_BOT_TOKEN")
+	envString(&c.TelegramAPIBase, "TELEGRAM_API_BASE")
+	envString(&c.DownloadMode, "DOWNLOAD_MODE")
+	envString(&c.BackendURL, "BACKEND_URL")
+	envString(&c.IdempotencyHeader, "BACKEND_IDEMPOTENCY_HEADER")
+	envString(&c.Proxy, "HTTP_PROXY")
+	envString(&c.Proxy, "PROXY_URL")
+	envString(&c.WebhookURL, "WEBHOOK_URL")
+	envString(&c.WebhookSecret, "WEBHOOK_SECRET")
+	envString(&c.Port, "PORT")
+	envString(&c.MetricsPort, "METRICS_PORT")
+	envString(&c.QueueDB, "QUEUE_DB")
+	// 与其它字符串不同，LOCK_FILE 设置为空时表示不加锁，与配置文件中的 lock_file: "" 相同
+	if value, ok := os.LookupEnv("LOCK_FILE"); ok {
+		c.LockFile = value
+	}
+	envString(&c.DownloadDir, "DOWNLOAD_DIR")
+	envString(&c.ArchiveDir, "ARCHIVE_DIR")
+	envString(&c.CookiesFile, "COOKIES_FILE")
+	envString(&c.LogLevel, "LOG_LEVEL")
+	envString(&c.Language, "LANGUAGE")
+	envString(&c.URLTrimChars, "URL_TRIM_CHARS")
+	envString(&c.URLPattern, "URL_PATTERN")
+	envStringList(&c.AllowedHosts, "ALLOWED_HOSTS")
+	envStringList(&c.DeniedHosts, "DENIED_HOSTS")
+	envString(&c.FilenameTemplate, "FILENAME_TEMPLATE")
+	envString(&c.S3Bucket, "S3_BUCKET")
+	envString(&c.S3Region, "S3_REGION")
+	envString(&c.S3Endpoint, "S3_ENDPOINT")
+	envString(&c.S3Prefix, "S3_PREFIX")
+	envStringList(&c.BotTokens, "TELEGRAM_BOT_TOKENS")
+	envStringList(&c.AllowedUpdates, "ALLOWED_UPDATES")
+	envStringList(&c.NotifyWebhooks, "NOTIFY_WEBHOOKS")
+
+	return errors.Join(
+		envInt(&c.Concurrency, "DOWNLOAD_CONCURRENCY"),
+		envInt(&c.PerChatConcurrency, "PER_CHAT_CONCURRENCY"),
+		envInt(&c.MaxRetries, "MAX_RETRIES"),
+		envInt(&c.RateLimitPerMinute, "RATE_LIMIT_PER_MINUTE"),
+		envInt(&c.MaxBatch, "MAX_BATCH"),
+		envInt(&c.BreakerThreshold, "BACKEND_BREAKER_THRESHOLD"),
+		envDuration(&c.BreakerCooldown, "BACKEND_BREAKER_COOLDOWN"),
+		envDuration(&c.RetryBaseDelay, "RETRY_BASE_DELAY"),
+		envDuration(&c.RetryMaxDelay, "RETRY_MAX_DELAY"),
+		envDuration(&c.DownloadTimeout, "DOWNLOAD_TIMEOUT"),
+		envDuration(&c.ShutdownGrace, "SHUTDOWN_GRACE"),
+		envDuration(&c.PollTimeout, "POLL_TIMEOUT"),
+		envDuration(&c.MaxMessageAge, "MAX_MESSAGE_AGE"),
+		envDuration(&c.SubscribeInterval, "SUBSCRIPTION_INTERVAL"),
+		envDuration(&c.ProgressInterval, "PROGRESS_INTERVAL"),
+		envInt64List(&c.AllowedChats, "ALLOWED_CHATS"),
+		envChatProxies(&c.ChatProxies, "CHAT_PROXIES"),
+		envBool(&c.DryRun, "DRY_RUN"),
+		envBool(&c.RequireProxy, "REQUIRE_PROXY"),
+		envBool(&c.URLJoinWrapped, "URL_JOIN_WRAPPED"),
+		envBool(&c.QuietRetries, "QUIET_RETRIES"),
+		envBool(&c.TrustProxy, "TRUST_PROXY"),
+		envBool(&c.RequireConfirmation, "REQUIRE_CONFIRMATION"),
+		envBool(&c.UploadFiles, "UPLOAD_FILES"),
+		envBool(&c.UploadCaptions, "UPLOAD_CAPTIONS"),
+		envBool(&c.FFmpegConvert, "FFMPEG_CONVERT"),
+		envBool(&c.S3PathStyle, "S3_PATH_STYLE"),
+		envDuration(&c.S3PresignExpiry, "S3_PRESIGN_EXPIRY"),
+		envBool(&c.NotifyTelegram, "NOTIFY_TELEGRAM"),
+		envInt64(&c.MaxUploadBytes, "MAX_UPLOAD_BYTES"),
+		envInt64(&c.MaxDownloadBytes, "MAX_DOWNLOAD_BYTES"),
+		envInt64(&c.MinFreeBytes, "MIN_FREE_BYTES"),
+		envInt64(&c.AdminChatID, "ADMIN_CHAT_ID"),
+		envInt(&c.RetentionMinutes, "RETENTION_MINUTES"),
+		envArgs(&c.GalleryDLArgs, "GALLERYDL_ARGS"),
+	)
+}
+
+// webhookSecretRegex 是 Telegram 对 secret_token 的要求
+var webhookSecretRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// expandEnv 展开配置文件中路径、URL 和密钥字段里的 $VAR 与 ${VAR}，
+// 未定义的变量展开为空字符串，$$ 表示字面的 $。环境变量覆盖的值不再展开
+func (c *Config) expandEnv() {
+	for _, field := range []*string{
+		&c.BotToken, &c.TelegramAPIBase, &c.BackendURL, &c.Proxy, &c.WebhookURL,
+		&c.WebhookSecret, &c.QueueDB, &c.LockFile, &c.DownloadDir, &c.ArchiveDir, &c.CookiesFile, &c.S3Bucket, &c.S3Endpoint,
+	} {
+		*field = expandEnv(*field)
+	}
+	for _, list := range [][]string{c.BotTokens, c.NotifyWebhooks} {
+		for i := range list {
+			list[i] = expandEnv(list[i])
+		}
+	}
+	for chatID, proxy := range c.ChatProxies {
+		c.ChatProxies[chatID] = expandEnv(proxy)
+	}
+}
+
+// expandEnv 与 os.ExpandEnv 相同，但把 $$ 保留为 $
+func expandEnv(s string) string {
+	parts := strings.Split(s, "$$")
+	for i, part := range parts {
+		parts[i] = os.ExpandEnv(part)
+	}
+	return strings.Join(parts, "$")
+}
+
+// minPollTimeout 和 maxPollTimeout 是 POLL_TIMEOUT 允许的范围。timeout 按整秒发送，
+// 不足 1s 时 Telegram 立即返回，变成短轮询
+const (
+	minPollTimeout = time.Second
+	maxPollTimeout = 40 * time.Second
+)
+
+// maxPresignExpiry 是 S3_PRESIGN_EXPIRY 允许的最大值
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// maxBatch 是 getUpdates 的 limit 参数允许的最大值，也是 MAX_BATCH 的默认值
+const maxBatch = 100
+
+// validate 检查必填项和取值范围，出错时给出明确的提示
+func (c *Config) validate() error {
+	var errs []error
+	if len(c.Tokens()) == 0 {
+		errs = append(errs, errors.New("bot token is missing: set TELEGRAM_BOT_TOKEN, TELEGRAM_BOT_TOKENS or bot_token in the config file"))
+	}
+	switch c.DownloadMode {
+	case download.ModeBackend:
+		if c.BackendURL == "" {
+			errs = append(errs, errors.New("backend URL is missing: set BACKEND_URL or backend_url in the config file"))
+		}
+	case download.ModeGalleryDL:
+	default:
+		errs = append(errs, fmt.Errorf("unknown download mode %q: expected %q or %q", c.DownloadMode, download.ModeBackend, download.ModeGalleryDL))
+	}
+	if c.WebhookSecret != "" && !webhookSecretRegex.MatchString(c.WebhookSecret) {
+		errs = append(errs, errors.New("webhook_secret must be 1-256 characters of A-Z, a-z, 0-9, _ and -"))
+	}
+	if err := download.ValidateFilenameTemplate(c.FilenameTemplate); err != nil {
+		errs = append(errs, err)
+	}
+	if _, ok := languageNames[c.Language]; !ok {
+		errs = append(errs, fmt.Errorf("unknown language %q: expected one of %s", c.Language, strings.Join(languageChoices, ", ")))
+	}
+	if c.Concurrency < 1 {
+		errs = append(errs, fmt.Errorf("concurrency must be at least 1, got %d", c.Concurrency))
+	}
+	if c.PerChatConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("per_chat_concurrency must not be negative, got %d", c.PerChatConcurrency))
+	}
+	if c.MaxRetries < 1 {
+		errs = append(errs, fmt.Errorf("max_retries must be at least 1, got %d", c.MaxRetries))
+	}
+	if c.RetryBaseDelay <= 0 || c.RetryMaxDelay < c.RetryBaseDelay {
+		errs = append(errs, fmt.Errorf("retry delays must satisfy 0 < retry_base_delay <= retry_max_delay, got %s and %s", c.RetryBaseDelay, c.RetryMaxDelay))
+	}
+	// HTTP 客户端等待响应头最多 45s，长轮询必须在此之前返回
+	if c.PollTimeout < minPollTimeout || c.PollTimeout > maxPollTimeout {
+		errs = append(errs, fmt.Errorf("poll_timeout must be between %s and %s, got %s", minPollTimeout, maxPollTimeout, c.PollTimeout))
+	}
+	if c.BreakerThreshold < 0 || c.BreakerThreshold > 0 && c.BreakerCooldown <= 0 {
+		errs = append(errs, fmt.Errorf("backend_breaker_threshold must be at least 0 and backend_breaker_cooldown positive, got %d and %s", c.BreakerThreshold, c.BreakerCooldown))
+	}
+	if c.MaxBatch < 1 || c.MaxBatch > maxBatch {
+		errs = append(errs, fmt.Errorf("max_batch must be between 1 and %d, got %d", maxBatch, c.MaxBatch))
+	}
+	if c.URLPattern != "" {
+		if _, err := regexp.Compile(c.URLPattern); err != nil {
+			errs = append(errs, fmt.Errorf("invalid url_pattern %q: %w", c.URLPattern, err))
+		}
+	}
+	if c.Proxy != "" {
+		if _, err := download.ParseProxy(c.Proxy); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.RequireProxy && c.Proxy == "" {
+		errs = append(errs, errors.New("require_proxy is set but no proxy is configured: set PROXY_URL or proxy in the config file"))
+	}
+	if len(c.ChatProxies) > 0 && c.DownloadMode != download.ModeGalleryDL {
+		errs = append(errs, fmt.Errorf("chat_proxies requires download_mode %q, the backend chooses its own egress", download.ModeGalleryDL))
+	}
+	for chatID, proxy := range c.ChatProxies {
+		if proxy == "" {
+			if c.RequireProxy {
+				errs = append(errs, fmt.Errorf("chat_proxies entry for chat %d is empty but require_proxy is set", chatID))
+			}
+			continue
+		}
+		if _, err := download.ParseProxy(proxy); err != nil {
+			errs = append(errs, fmt.Errorf("chat_proxies entry for chat %d: %w", chatID, err))
+		}
+	}
+	for _, raw := range c.NotifyWebhooks {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("notify_webhooks entry %q is not an http(s) URL", raw))
+		}
+	}
+	if c.MaxMessageAge < 0 {
+		errs = append(errs, fmt.Errorf("max_message_age must not be negative, got %s", c.MaxMessageAge))
+	}
+	if c.SubscribeInterval <= 0 {
+		errs = append(errs, fmt.Errorf("subscription_interval must be positive, got %s", c.SubscribeInterval))
+	}
+	if c.DownloadTimeout < 0 {
+		errs = append(errs, fmt.Errorf("download_timeout must not be negative, got %s", c.DownloadTimeout))
+	}
+	if c.ShutdownGrace < 0 {
+		errs = append(errs, fmt.Errorf("shutdown_grace must not be negative, got %s", c.ShutdownGrace))
+	}
+	if c.CookiesFile != "" {
+		if c.DownloadMode != download.ModeGalleryDL {
+			errs = append(errs, fmt.Errorf("cookies_file requires download_mode %q, the backend handles its own logins", download.ModeGalleryDL))
+		} else if err := checkReadable(c.CookiesFile); err != nil {
+			errs = append(errs, fmt.Errorf("cookies_file is not readable: %w", err))
+		}
+	}
+	if c.S3Bucket != "" && c.DownloadMode != download.ModeGalleryDL {
+		errs = append(errs, fmt.Errorf("s3_bucket requires download_mode %q, the backend keeps its own files", download.ModeGalleryDL))
+	}
+	// S3 的预签名链接最长有效 7 天
+	if c.S3PresignExpiry < 0 || c.S3PresignExpiry > maxPresignExpiry {
+		errs = append(errs, fmt.Errorf("s3_presign_expiry must be between 0s and %s, got %s", maxPresignExpiry, c.S3PresignExpiry))
+	}
+	return errors.Join(errs...)
+}
+
+// checkReadable 检查 path 是可以打开读取的普通文件
+func checkReadable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	return nil
+}
+
+// Tokens returns every configured bot token without duplicates, BotToken first
+func (c *Config) Tokens() []string {
+	var tokens []string
+	seen := make(map[string]bool)
+	for _, token := range append([]string{c.BotToken}, c.BotTokens...) {
+		token = strings.TrimSpace(token)
+		if token != "" && !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// allowedUpdates 返回 ALLOWED_UPDATES 中去掉空白后的 update 类型，为空时返回 nil
+func (c *Config) allowedUpdates() []string {
+	return trimList(c.AllowedUpdates)
+}
+
+// hostFilter 返回由 ALLOWED_HOSTS 和 DENIED_HOSTS 构造的 URL 过滤器
+func (c *Config) hostFilter() urls.HostFilter {
+	return urls.HostFilter{Allowed: trimList(c.AllowedHosts), Denied: trimList(c.DeniedHosts)}
+}
+
+// trimList 去掉每一项的首尾空白并丢弃空项，全部为空时返回 nil
+func trimList(list []string) []string {
+	var trimmed []string
+	for _, item := range list {
+		if item = strings.TrimSpace(item); item != "" {
+			trimmed = append(trimmed, item)
+		}
+	}
+	return trimmed
+}
+
+// proxyFor 返回 chatID 的下载使用的代理，ok 为 false 表示 chat_proxies 中没有该 chat，使用全局的 Proxy
+func (c *Config) proxyFor(chatID int64) (proxy string, ok bool) {
+	proxy, ok = c.ChatProxies[chatID]
+	return proxy, ok
+}
+
+// IsChatAllowed reports whether the bot should serve chatID
+func (c *Config) IsChatAllowed(chatID int64) bool {
+	if len(c.AllowedChats) == 0 {
+		return true
+	}
+	for _, id := range c.AllowedChats {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+func envString(dst *string, key string) {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		*dst = value
+	}
+}
+
+func envStringList(dst *[]string, key string) {
+	if value := os.Getenv(key); value != "" {
+		*dst = strings.Split(value, ",")
+	}
+}
+
+func envArgs(dst *[]string, key string) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	args, err := splitArgs(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	*dst = args
+	return nil
+}
+
+// splitArgs 按 shell 的规则拆分参数：空白分隔，支持单引号、双引号和反斜杠转义，不做变量展开
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+func envInt(dst *int, key string) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	*dst = n
+	return nil
+}
+
+func envBool(dst *bool, key string) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	*dst = b
+	return nil
+}
+
+func envInt64(dst *int64, key string) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	*dst = n
+	return nil
+}
+
+func envDuration(dst *time.Duration, key string) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	*dst = d
+	return nil
+}
+
+func envInt64List(dst *[]int64, key string) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	var list []int64
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s entry %q: %w", key, field, err)
+		}
+		list = append(list, n)
+	}
+	*dst = list
+	return nil
+}
+
+// envChatProxies 解析 chatID=代理URL 形式、逗号分隔的列表，代理 URL 为空表示该 chat 不使用代理
+func envChatProxies(dst *map[int64]string, key string) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	proxies := make(map[int64]string)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, proxy, ok := strings.Cut(field, "=")
+		if !ok {
+			return fmt.Errorf("invalid %s entry %q: expected chatID=proxy", key, field)
+		}
+		chatID, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s entry %q: %w", key, field, err)
+		}
+		proxies[chatID] = strings.TrimSpace(proxy)
+	}
+	*dst = proxies
+	return nil
+}
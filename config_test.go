@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deckvig/telegram-bot/download"
+)
+
+func TestEnvChatProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[int64]string
+		wantErr bool
+	}{
+		{"unset", "", nil, false},
+		{"single", "123=socks5://127.0.0.1:1080", map[int64]string{123: "socks5://127.0.0.1:1080"}, false},
+		{"group and direct", " -100456 = http://proxy:3128 , 789= ", map[int64]string{-100456: "http://proxy:3128", 789: ""}, false},
+		{"empty entries", "123=http://a:1,,", map[int64]string{123: "http://a:1"}, false},
+		{"missing separator", "123", nil, true},
+		{"bad chat id", "abc=http://a:1", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CHAT_PROXIES", tt.value)
+			var got map[int64]string
+			err := envChatProxies(&got, "CHAT_PROXIES")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("envChatProxies(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("envChatProxies(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLockFileEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   bool
+		value string
+		want  string
+	}{
+		{"unset", false, "", "bot.lock"},
+		{"path", true, "/run/bot/bot.lock", "/run/bot/bot.lock"},
+		// 工作目录只读或多个实例共用时需要关闭
+		{"empty disables the lock", true, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LOCK_FILE", tt.value)
+			if !tt.set {
+				os.Unsetenv("LOCK_FILE")
+			}
+			c := defaultConfig()
+			if err := c.applyEnv(); err != nil {
+				t.Fatal(err)
+			}
+			if c.LockFile != tt.want {
+				t.Errorf("LockFile = %q, want %q", c.LockFile, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigProxyFor(t *testing.T) {
+	c := &Config{Proxy: "http://global:3128", ChatProxies: map[int64]string{1: "socks5://chat:1080", 2: ""}}
+	tests := []struct {
+		chatID int64
+		want   string
+		wantOK bool
+	}{
+		{1, "socks5://chat:1080", true},
+		{2, "", true},
+		{3, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := c.proxyFor(tt.chatID)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("proxyFor(%d) = %q, %v, want %q, %v", tt.chatID, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+// testConfig 返回一个可以通过 validate 的最小配置
+func testConfig() *Config {
+	c := defaultConfig()
+	c.BotToken = "123:test"
+	c.BackendURL = "http://backend.invalid/download"
+	return c
+}
+
+func TestValidateRequireProxy(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr bool
+	}{
+		{"proxy not required", func(c *Config) {}, false},
+		{"required without proxy", func(c *Config) { c.RequireProxy = true }, true},
+		{"required with proxy", func(c *Config) { c.RequireProxy, c.Proxy = true, "http://127.0.0.1:3128" }, false},
+		{"required with a direct chat", func(c *Config) {
+			c.DownloadMode = download.ModeGalleryDL
+			c.RequireProxy, c.Proxy = true, "http://127.0.0.1:3128"
+			c.ChatProxies = map[int64]string{42: ""}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			tt.modify(c)
+			if err := c.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckRequiredProxies(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reachable := "http://" + l.Addr().String()
+	defer l.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := "http://" + closed.Addr().String()
+	closed.Close()
+
+	saved := cfg
+	defer func() { cfg = saved }()
+	tests := []struct {
+		name        string
+		proxy       string
+		chatProxies map[int64]string
+		wantErr     bool
+	}{
+		{"reachable", reachable, nil, false},
+		{"unreachable", unreachable, nil, true},
+		{"unreachable chat proxy", reachable, map[int64]string{1: unreachable}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg = testConfig()
+			cfg.RequireProxy, cfg.Proxy, cfg.ChatProxies = true, tt.proxy, tt.chatProxies
+			if err := checkRequiredProxies(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("checkRequiredProxies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePollTimeout(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		wantErr bool
+	}{
+		{0, true},
+		{500 * time.Millisecond, true},
+		{time.Second, false},
+		{30 * time.Second, false},
+		{40 * time.Second, false},
+		{41 * time.Second, true},
+	}
+	for _, tt := range tests {
+		c := testConfig()
+		c.PollTimeout = tt.timeout
+		if err := c.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("validate() with poll_timeout %s error = %v, wantErr %v", tt.timeout, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateLanguage(t *testing.T) {
+	tests := []struct {
+		language string
+		wantErr  bool
+	}{
+		{"zh", false},
+		{"en", false},
+		{"", true},
+		{"EN", true},
+		{"fr", true},
+	}
+	for _, tt := range tests {
+		c := testConfig()
+		c.Language = tt.language
+		if err := c.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("validate() with language %q error = %v, wantErr %v", tt.language, err, tt.wantErr)
+		}
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("BOT_TEST_HOME", "/home/bot")
+	t.Setenv("BOT_TEST_EMPTY", "")
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"$BOT_TEST_HOME/downloads", "/home/bot/downloads"},
+		{"${BOT_TEST_HOME}/downloads", "/home/bot/downloads"},
+		{"${BOT_TEST_UNDEFINED}/downloads", "/downloads"},
+		{"$BOT_TEST_UNDEFINED", ""},
+		{"a${BOT_TEST_EMPTY}b", "ab"},
+		{"pa$$word", "pa$word"},
+		{"$$BOT_TEST_HOME", "$BOT_TEST_HOME"},
+		{"$$$BOT_TEST_HOME", "$/home/bot"},
+		{"$$$$", "$$"},
+		{"http://user:p$$ss@${BOT_TEST_HOME}", "http://user:p$ss@/home/bot"},
+	}
+	for _, tt := range tests {
+		if got := expandEnv(tt.in); got != tt.want {
+			t.Errorf("expandEnv(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLoadExpandsEnv(t *testing.T) {
+	t.Setenv("BOT_TEST_HOME", "/home/bot")
+	t.Setenv("BOT_TEST_TOKEN", "123:secret")
+	t.Setenv("BOT_TEST_PROXY", "socks5://127.0.0.1:1080")
+	// 环境变量覆盖的字段不应影响测试
+	for _, key := range []string{"TELEGRAM_BOT_TOKEN", "TELEGRAM_BOT_TOKENS", "BACKEND_URL", "PROXY_URL", "HTTP_PROXY", "DOWNLOAD_DIR", "QUEUE_DB", "DOWNLOAD_MODE", "CHAT_PROXIES"} {
+		t.Setenv(key, "")
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `bot_token: ${BOT_TEST_TOKEN}
+download_mode: gallery-dl
+proxy: $BOT_TEST_PROXY
+download_dir: ${BOT_TEST_HOME}/downloads
+queue_db: ${BOT_TEST_UNDEFINED}queue.db
+notify_webhooks: ["https://hooks.example.com/$$1/${BOT_TEST_HOME}"]
+chat_proxies:
+  42: ${BOT_TEST_PROXY}
+`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	checks := []struct {
+		field, got, want string
+	}{
+		{"bot_token", c.BotToken, "123:secret"},
+		{"proxy", c.Proxy, "socks5://127.0.0.1:1080"},
+		{"download_dir", c.DownloadDir, "/home/bot/downloads"},
+		{"queue_db", c.QueueDB, "queue.db"},
+		{"notify_webhooks", strings.Join(c.NotifyWebhooks, ","), "https://hooks.example.com/$1//home/bot"},
+		{"chat_proxies", c.ChatProxies[42], "socks5://127.0.0.1:1080"},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("%s = %q, want %q", check.field, check.got, check.want)
+		}
+	}
+}
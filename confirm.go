@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// confirmationTTL 超过该时间未点击的确认请求会被丢弃
+const confirmationTTL = time.Hour
+
+// CallbackQuery represents a press of an inline keyboard button
+type CallbackQuery struct {
+	ID      string   `json:"id"`
+	From    User     `json:"from"`
+	Message *Message `json:"message,omitempty"` // 按钮所在的消息
+	Data    string   `json:"data"`
+}
+
+// InlineKeyboardButton is a button attached to a message
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+// InlineKeyboardMarkup is the reply_markup of a message with inline buttons
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// pendingConfirmation 是等待用户确认的一组 URL
+type pendingConfirmation struct {
+	msg     *Message // 包含链接的原始消息
+	urls    []string
+	force   bool
+	options []string // 消息前缀中的下载选项
+	created time.Time
+}
+
+// confirmations 保存等待确认的请求。callback_data 最多 64 字节，放不下 URL，按钮中只携带 ID
+var confirmations = struct {
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]*pendingConfirmation
+}{pending: make(map[int64]*pendingConfirmation)}
+
+// addConfirmation 保存 p 并返回它的 ID，同时清理过期的请求
+func addConfirmation(p *pendingConfirmation) int64 {
+	confirmations.mu.Lock()
+	defer confirmations.mu.Unlock()
+
+	for id, old := range confirmations.pending {
+		if time.Since(old.created) > confirmationTTL {
+			delete(confirmations.pending, id)
+		}
+	}
+	confirmations.nextID++
+	confirmations.pending[confirmations.nextID] = p
+	return confirmations.nextID
+}
+
+// takeConfirmation 取出并删除 id 对应的请求，不存在或已过期时返回 nil。
+// 群组中只有发送链接的用户可以确认，其他用户点击时 allowed 为 false，请求保持不变
+func takeConfirmation(id, userID int64) (p *pendingConfirmation, allowed bool) {
+	confirmations.mu.Lock()
+	defer confirmations.mu.Unlock()
+
+	p = confirmations.pending[id]
+	if p == nil || time.Since(p.created) > confirmationTTL {
+		delete(confirmations.pending, id)
+		return nil, false
+	}
+	if p.msg.From != nil && p.msg.From.ID != userID {
+		return p, false
+	}
+	delete(confirmations.pending, id)
+	return p, true
+}
+
+// askConfirmation 回复带有"下载"/"忽略"按钮的消息，点击后由 handleCallbackQuery 处理
+func askConfirmation(bot *Bot, msg *Message, urls []string, force bool, options []string) {
+	id := addConfirmation(&pendingConfirmation{msg: msg, urls: urls, force: force, options: options, created: time.Now()})
+	l := langFor(msg.Chat.ID)
+	markup := InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{{
+		{Text: l.T("下载"), CallbackData: fmt.Sprintf("dl:%d", id)},
+		{Text: l.T("忽略"), CallbackData: fmt.Sprintf("ignore:%d", id)},
+	}}}
+
+	text := l.Sprintf("发现 %d 个 URL，是否下载？\n%s", len(urls), strings.Join(urls, "\n"))
+	if _, err := bot.sendMessageWithRetry(map[string]interface{}{
+		"chat_id":                     msg.Chat.ID,
+		"text":                        text,
+		"reply_to_message_id":         msg.MessageID,
+		"allow_sending_without_reply": true,
+		"reply_markup":                markup,
+	}); err != nil {
+		bot.logger.Warn("failed to ask for download confirmation", "chat_id", msg.Chat.ID, "error", err)
+	}
+}
+
+// handleCallbackQuery 处理确认按钮：下载则把对应的 URL 加入队列，忽略则丢弃。/settings 菜单的按钮交给 handleSettingsCallback
+func handleCallbackQuery(bot *Bot, query *CallbackQuery) {
+	action, rawID, _ := strings.Cut(query.Data, ":")
+	if action == "set" && query.Message != nil && cfg.IsChatAllowed(query.Message.Chat.ID) {
+		handleSettingsCallback(bot, query, rawID)
+		return
+	}
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil || (action != "dl" && action != "ignore") || query.Message == nil {
+		bot.answerCallbackQuery(query.ID, "")
+		return
+	}
+	chatID := query.Message.Chat.ID
+	if !cfg.IsChatAllowed(chatID) {
+		bot.answerCallbackQuery(query.ID, "")
+		return
+	}
+
+	l := langFor(chatID)
+	p, allowed := takeConfirmation(id, query.From.ID)
+	if p == nil {
+		bot.answerCallbackQuery(query.ID, l.T("该请求已过期，请重新发送链接。"))
+		bot.editMessageText(chatID, query.Message.MessageID, l.T("该请求已过期。"))
+		return
+	}
+	if !allowed {
+		bot.answerCallbackQuery(query.ID, l.T("只有发送链接的用户可以操作。"))
+		return
+	}
+
+	logger.Info("download confirmation answered", "chat_id", chatID, "action", action, "count", len(p.urls))
+	if action == "ignore" {
+		bot.answerCallbackQuery(query.ID, l.T("已忽略"))
+		bot.editMessageText(chatID, query.Message.MessageID, l.Sprintf("已忽略 %d 个 URL。", len(p.urls)))
+		return
+	}
+
+	bot.answerCallbackQuery(query.ID, l.T("开始下载"))
+	bot.editMessageText(chatID, query.Message.MessageID, l.Sprintf("已确认下载 %d 个 URL。", len(p.urls)))
+	queueURLs(bot, p.msg, p.urls, p.force, p.options)
+}
+
+// answerCallbackQuery 结束按钮上的加载状态，text 非空时向用户显示提示
+func (b *Bot) answerCallbackQuery(queryID, text string) error {
+	payload := map[string]interface{}{"callback_query_id": queryID}
+	if text != "" {
+		payload["text"] = text
+	}
+	_, err := b.callAPI("answerCallbackQuery", payload)
+	if err != nil {
+		b.logger.Warn("failed to answer callback query", "error", err)
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deckvig/telegram-bot/download"
+)
+
+// diagTimeout 是 /diag 每项检查的超时，所有检查并发执行，命令最多等待这么久
+const diagTimeout = 5 * time.Second
+
+// handleDiagCommand 并发执行 gallery-dl、代理、后端和磁盘空间检查，汇总后回复。
+// 结果包含服务器的路径和代理地址，只回复管理员；检查最多需要 diagTimeout，在后台执行
+func handleDiagCommand(bot *Bot, msg *Message, args string) {
+	if !isAdminChat(msg.Chat.ID) {
+		bot.sendReply(msg.Chat.ID, msg.MessageID, langFor(msg.Chat.ID).T("该命令仅限管理员使用。"))
+		return
+	}
+	runInBackground("diag", func() {
+		lines := runDiagnostics()
+		logger.Info("diagnostics", "chat_id", msg.Chat.ID, "results", lines)
+		bot.sendReply(msg.Chat.ID, msg.MessageID, "诊断结果：\n\n"+strings.Join(lines, "\n"))
+	})
+}
+
+// runDiagnostics 并发执行所有检查，按固定顺序返回每项的结果
+func runDiagnostics() []string {
+	checks := []func(ctx context.Context) string{diagGalleryDL, diagProxy, diagBackend, diagDisk}
+	lines := make([]string, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), diagTimeout)
+			defer cancel()
+			lines[i] = check(ctx)
+		}()
+	}
+	wg.Wait()
+	return lines
+}
+
+// diagGalleryDL 检查 gallery-dl 是否在 PATH 中以及它的版本
+func diagGalleryDL(ctx context.Context) string {
+	path, version, err := download.GalleryDLVersion(ctx)
+	switch {
+	case errors.Is(err, download.ErrNotInstalled):
+		if cfg.DownloadMode == download.ModeGalleryDL {
+			return "gallery-dl: 未安装，无法下载"
+		}
+		return "gallery-dl: 未安装（backend 模式不需要）"
+	case err != nil:
+		return fmt.Sprintf("gallery-dl: %s，无法获取版本: %v", path, err)
+	}
+	return fmt.Sprintf("gallery-dl: %s，版本 %s", path, version)
+}
+
+// diagProxy 检查配置的代理能否建立 TCP 连接
+func diagProxy(ctx context.Context) string {
+	if cfg.Proxy == "" {
+		return "代理: 未配置"
+	}
+	start := time.Now()
+	if err := download.CheckProxy(ctx, cfg.Proxy); err != nil {
+		return fmt.Sprintf("代理: 无法连接: %v", err)
+	}
+	return fmt.Sprintf("代理: 可以连接（%d ms）", time.Since(start).Milliseconds())
+}
+
+// diagBackend 在 backend 模式下检查 BACKEND_URL 能否访问
+func diagBackend(ctx context.Context) string {
+	if cfg.DownloadMode != download.ModeBackend {
+		return "后端: gallery-dl 模式不使用"
+	}
+	start := time.Now()
+	status, err := download.CheckBackend(ctx, cfg.BackendURL, cfg.Proxy)
+	if err != nil {
+		return fmt.Sprintf("后端: 无法访问: %v", err)
+	}
+	return fmt.Sprintf("后端: 可以访问，HTTP %s（%d ms）", status, time.Since(start).Milliseconds())
+}
+
+// diagDisk 报告下载目录的可用空间
+func diagDisk(ctx context.Context) string {
+	free, ok, err := download.FreeBytes(cfg.DownloadDir)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("磁盘: 无法获取 %s 的可用空间: %v", cfg.DownloadDir, err)
+	case !ok:
+		return "磁盘: 当前系统不支持检查可用空间"
+	}
+	line := fmt.Sprintf("磁盘: %s 可用 %.1f GB", cfg.DownloadDir, float64(free)/(1<<30))
+	if cfg.DownloadMode == download.ModeGalleryDL && cfg.MinFreeBytes > 0 && free < cfg.MinFreeBytes {
+		line += fmt.Sprintf("，低于 MIN_FREE_BYTES %.1f GB，新的下载会被拒绝", float64(cfg.MinFreeBytes)/(1<<30))
+	}
+	return line
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deckvig/telegram-bot/download"
+)
+
+func TestDiagCommand(t *testing.T) {
+	fakeGalleryDL(t, `sleep 1; echo 1.26.0`)
+	saved := cfg
+	defer func() { cfg = saved }()
+	tests := []struct {
+		name      string
+		adminChat int64
+		chatID    int64
+		want      string
+	}{
+		{"no admin configured", 0, 5, "仅限管理员"},
+		{"other chat", 1, 5, "仅限管理员"},
+		{"admin chat", 5, 5, "诊断结果"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg = testConfig()
+			cfg.DownloadMode, cfg.DownloadDir, cfg.AdminChatID = download.ModeGalleryDL, t.TempDir(), tt.adminChat
+			withJobQueue(t)
+			f := newFakeTelegram(t)
+			b := newTestBot(t, f, cfg, nil)
+
+			start := time.Now()
+			handleDiagCommand(b, testMessage(tt.chatID, 1, "/diag"), "")
+			// gallery-dl --version 需要 1s，检查在后台进行，不阻塞轮询
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("handleDiagCommand blocked for %s", elapsed)
+			}
+			f.waitFor(t, 5*time.Second, func() bool { return len(f.sentTexts()) > 0 })
+			if reply := f.sentTexts()[0]; !strings.Contains(reply, tt.want) {
+				t.Errorf("reply = %q, want it to contain %q", reply, tt.want)
+			}
+			if tt.want == "诊断结果" && !strings.Contains(f.sentTexts()[0], "1.26.0") {
+				t.Errorf("reply = %q, want the gallery-dl version", f.sentTexts()[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// maxURLListBytes 是作为 URL 列表接受的 .txt 文件的最大大小
+const maxURLListBytes = 1 << 20
+
+// errFileTooLarge 表示下载的文件超过了调用方允许的大小
+var errFileTooLarge = errors.New("file too large")
+
+// Document is a general file attached to a message
+type Document struct {
+	FileID   string `json:"file_id"`
+	FileName string `json:"file_name,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	FileSize int64  `json:"file_size,omitempty"`
+}
+
+// isURLList 判断文件是否是每行一个 URL 的文本文件
+func (d *Document) isURLList() bool {
+	return strings.EqualFold(path.Ext(d.FileName), ".txt") || d.MimeType == "text/plain"
+}
+
+// handleDocument 下载用户发送的 .txt 文件，把其中的 URL 与消息的 caption（可以带选项前缀）
+// 一起加入下载队列，限流和 PER_CHAT_CONCURRENCY 与普通消息相同
+func handleDocument(bot *Bot, msg *Message) {
+	chatID := msg.Chat.ID
+	l := langFor(chatID)
+	doc := msg.Document
+	if !doc.isURLList() {
+		logger.Info("ignoring document that is not a url list", "chat_id", chatID, "file_name", doc.FileName, "mime_type", doc.MimeType)
+		bot.sendReply(chatID, msg.MessageID, l.T("只支持每行一个链接的 .txt 文件。"))
+		return
+	}
+	if doc.FileSize > maxURLListBytes {
+		bot.sendReply(chatID, msg.MessageID, l.Sprintf("文件太大（%.1f MB），链接列表最大 %d KB。", float64(doc.FileSize)/(1<<20), maxURLListBytes>>10))
+		return
+	}
+
+	// file_size 可能缺失，下载时再按实际大小检查一次
+	data, err := bot.downloadTelegramFile(doc.FileID, maxURLListBytes)
+	if errors.Is(err, errFileTooLarge) {
+		logger.Info("url list is too large", "chat_id", chatID, "file_name", doc.FileName, "error", err)
+		bot.sendReply(chatID, msg.MessageID, l.Sprintf("文件太大，链接列表最大 %d KB。", maxURLListBytes>>10))
+		return
+	}
+	if err != nil {
+		logger.Error("failed to download url list", "chat_id", chatID, "file_name", doc.FileName, "error", err)
+		bot.sendReply(chatID, msg.MessageID, l.Sprintf("读取文件失败: %v", err))
+		return
+	}
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	logger.Info("received url list", "chat_id", chatID, "file_name", doc.FileName, "bytes", len(data))
+	enqueueURLs(bot, msg, msg.Caption+"\n"+string(data), false)
+}
+
+// downloadTelegramFile fetches a file the bot received through getFile and the file
+// download endpoint. Bot API only serves files up to 20 MB this way. Files larger than
+// maxBytes are rejected with errFileTooLarge instead of being truncated.
+func (b *Bot) downloadTelegramFile(fileID string, maxBytes int64) ([]byte, error) {
+	resp, err := b.callAPI("getFile", map[string]interface{}{"file_id": fileID})
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		FilePath string `json:"file_path"`
+	}
+	if err := json.Unmarshal(resp.Result, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode getFile result: %w", err)
+	}
+	if file.FilePath == "" {
+		return nil, fmt.Errorf("getFile returned no file_path for %s", fileID)
+	}
+
+	fileURL := fmt.Sprintf("%s/file/bot%s/%s", strings.TrimSuffix(b.cfg.TelegramAPIBase, "/"), b.token, file.FilePath)
+	fileResp, err := b.http.Get(fileURL)
+	if err != nil {
+		// 错误中的 URL 包含 token
+		return nil, fmt.Errorf("failed to download %s: %w", file.FilePath, errors.Unwrap(err))
+	}
+	defer fileResp.Body.Close()
+	if fileResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: HTTP %d", file.FilePath, fileResp.StatusCode)
+	}
+	// 多读一个字节，用来区分刚好 maxBytes 和超出的文件
+	data, err := io.ReadAll(io.LimitReader(fileResp.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", file.FilePath, err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", errFileTooLarge, file.FilePath, maxBytes)
+	}
+	return data, nil
+}
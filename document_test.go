@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleDocument(t *testing.T) {
+	const note = "https://www.xiaohongshu.com/explore/6650a1b2000000001e03c4d5"
+	tests := []struct {
+		name         string
+		doc          Document
+		content      string
+		wantReply    string
+		wantDownload bool
+		wantFetched  bool
+	}{
+		{
+			name:         "url list",
+			doc:          Document{FileName: "links.txt", FileSize: int64(len(note) + 1)},
+			content:      "\ufeff" + note + "\n",
+			wantReply:    "发现 1 个 URL",
+			wantDownload: true,
+			wantFetched:  true,
+		},
+		{
+			name:         "exactly the limit",
+			doc:          Document{FileName: "links.txt"},
+			content:      note + strings.Repeat("\n", maxURLListBytes-len(note)),
+			wantReply:    "发现 1 个 URL",
+			wantDownload: true,
+			wantFetched:  true,
+		},
+		{
+			// 没有 file_size 时按实际读取的大小拒绝，不会截断后继续处理
+			name:        "too large without file_size",
+			doc:         Document{FileName: "links.txt"},
+			content:     note + strings.Repeat("\n", maxURLListBytes-len(note)+1),
+			wantReply:   "文件太大，链接列表最大 1024 KB。",
+			wantFetched: true,
+		},
+		{
+			name:      "too large by file_size",
+			doc:       Document{FileName: "links.txt", FileSize: maxURLListBytes + 1},
+			wantReply: "文件太大（1.0 MB），链接列表最大 1024 KB。",
+		},
+		{
+			name:      "not a text file",
+			doc:       Document{FileName: "photo.jpg", MimeType: "image/jpeg"},
+			wantReply: "只支持每行一个链接的 .txt 文件。",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &fakeDownloader{}
+			f, b := startTestBot(t, d, nil)
+			f.handle("getFile", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, map[string]any{"ok": true, "result": map[string]any{"file_path": "documents/list.txt"}})
+			})
+			f.handle("list.txt", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.content))
+			})
+
+			msg := testMessage(5, 10, "")
+			doc := tt.doc
+			doc.FileID = "file-1"
+			msg.Document = &doc
+			handleUpdate(b, Update{UpdateID: 1, Message: msg})
+			f.waitFor(t, 5*time.Second, func() bool { return containsAll(f.sentTexts(), []string{tt.wantReply}) })
+			if tt.wantDownload {
+				f.waitFor(t, 5*time.Second, func() bool { return len(d.called()) == 1 })
+			} else if got := d.called(); len(got) > 0 {
+				t.Errorf("downloaded %q, want nothing", got)
+			}
+			if fetched := len(f.callsTo("list.txt")) > 0; fetched != tt.wantFetched {
+				t.Errorf("file downloaded = %v, want %v", fetched, tt.wantFetched)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// backendRequest is the JSON body posted to the backend.
+//
+// When an idempotency key is set on the context, the request also carries it in the
+// IdempotencyHeader header (Idempotency-Key by default). The key stays the same for
+// every retry of one job and for repeated requests of the same URL from the same chat
+// within a short window. A backend that sees a key it already knows should return the
+// result of (or attach to) the existing download instead of starting a new one.
+type backendRequest struct {
+	URL      string   `json:"url"`
+	Download bool     `json:"download"`
+	Options  []string `json:"options,omitempty"` // 用户选择的下载选项，例如 audio、hd
+}
+
+// BackendEvent is one line of an NDJSON progress stream sent by the backend
+type BackendEvent struct {
+	Type    string  `json:"type"` // progress、done 或 error
+	Message string  `json:"message"`
+	Percent float64 `json:"percent"`
+}
+
+// Backend event types
+const (
+	EventProgress = "progress"
+	EventDone     = "done"
+	EventError    = "error"
+)
+
+// StatusError is returned when the backend answers with a status other than 200
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("backend returned status code %d, body: %s", e.StatusCode, e.Body)
+}
+
+// Transient reports whether retrying the request may succeed: 5xx responses, plus
+// 408 and 429 which ask the client to try again later. Other 4xx responses mean the
+// backend rejected the request itself.
+func (e *StatusError) Transient() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests
+}
+
+// HTTPBackendDownloader 把 URL 发送给后端服务，由后端完成下载
+type HTTPBackendDownloader struct {
+	URL               string
+	IdempotencyHeader string       // 为空时不发送幂等 key
+	Client            *http.Client // 为空时使用默认客户端
+	Logger            *slog.Logger
+}
+
+// Download 发送单个 URL 到后端进行下载
+func (d *HTTPBackendDownloader) Download(ctx context.Context, downloadURL string) (Result, error) {
+	// 注意：这里使用 downloadURL，而不是整个 message
+	jsonData, err := json.Marshal(backendRequest{URL: downloadURL, Download: true, Options: optionsFrom(ctx)})
+	if err != nil {
+		return Result{}, err
+	}
+	payload := bytes.NewReader(jsonData)
+
+	client := d.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, payload)
+
+	if err != nil {
+		err = redactURLError(err)
+		d.Logger.Debug("error creating request", "url", downloadURL, "error", err)
+		return Result{}, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	// 后端支持时以 NDJSON 逐行返回进度事件
+	req.Header.Add("Accept", "application/x-ndjson, application/json")
+	if key := idempotencyKeyFrom(ctx); key != "" && d.IdempotencyHeader != "" {
+		req.Header.Set(d.IdempotencyHeader, key)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		d.Logger.Debug("error performing request", "url", downloadURL, "error", err)
+		return Result{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK && strings.HasPrefix(res.Header.Get("Content-Type"), "application/x-ndjson") {
+		return d.readEvents(ctx, res.Body, downloadURL)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		d.Logger.Debug("error reading response body", "url", downloadURL, "error", err)
+		return Result{}, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return Result{}, &StatusError{StatusCode: res.StatusCode, Body: string(body)}
+	}
+
+	d.Logger.Info("backend response", "url", downloadURL, "body", string(body))
+	return Result{Body: string(body)}, nil
+}
+
+// readEvents 逐行解析后端的进度事件并转发给 progress。
+// 连接中断或流在 done 之前结束时，以最后收到的事件作为最终结果
+func (d *HTTPBackendDownloader) readEvents(ctx context.Context, body io.Reader, downloadURL string) (Result, error) {
+	progress := progressFrom(ctx)
+	var last *BackendEvent
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var event BackendEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			d.Logger.Warn("ignoring malformed backend event", "url", downloadURL, "line", line, "error", err)
+			continue
+		}
+		last = &event
+
+		switch event.Type {
+		case EventDone:
+			d.Logger.Info("backend response", "url", downloadURL, "body", event.Message)
+			return Result{Body: event.Message}, nil
+		case EventError:
+			return Result{}, fmt.Errorf("backend reported error: %s", event.Message)
+		default:
+			progress(fmt.Sprintf("[%.0f%%] %s", event.Percent, event.Message))
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	if last == nil {
+		if err := scanner.Err(); err != nil {
+			return Result{}, fmt.Errorf("failed to read backend events: %w", err)
+		}
+		return Result{}, errors.New("backend closed the event stream without sending any event")
+	}
+	d.Logger.Warn("backend event stream ended before done, using last event as result",
+		"url", downloadURL, "type", last.Type, "percent", last.Percent, "error", scanner.Err())
+	return Result{Body: last.Message}, nil
+}
@@ -0,0 +1,59 @@
+package download
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestBackendPayloadEscaping(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		options []string
+	}{
+		{"plain", "https://www.xiaohongshu.com/explore/abc", nil},
+		{"quote", `https://example.com/a"b`, nil},
+		{"backslash", `https://example.com/a\b`, nil},
+		{"control characters", "https://example.com/a\tb\nc\x00d", nil},
+		{"unicode and options", "https://example.com/笔记?q= ", []string{OptionAudio}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got backendRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Errorf("failed to read request body: %v", err)
+				}
+				if !json.Valid(body) {
+					t.Errorf("request body is not valid JSON: %q", body)
+				}
+				if err := json.Unmarshal(body, &got); err != nil {
+					t.Errorf("failed to decode request body %q: %v", body, err)
+				}
+				if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+					t.Errorf("Content-Type = %q, want application/json", ct)
+				}
+				w.Write([]byte(`{"status":"ok"}`))
+			}))
+			defer server.Close()
+
+			d := &HTTPBackendDownloader{URL: server.URL, Client: server.Client(), Logger: testLogger()}
+			ctx := context.Background()
+			if len(tt.options) > 0 {
+				ctx = WithOptions(ctx, tt.options)
+			}
+			if _, err := d.Download(ctx, tt.url); err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			if got.URL != tt.url || !got.Download || !slices.Equal(got.Options, tt.options) {
+				t.Errorf("backend received %+v, want url %q, download true, options %q", got, tt.url, tt.options)
+			}
+		})
+	}
+}
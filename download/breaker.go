@@ -0,0 +1,115 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrBackendUnavailable is returned without contacting the backend while the circuit breaker is open
+var ErrBackendUnavailable = errors.New("backend unavailable")
+
+// BreakerState 是熔断器的状态
+type BreakerState string
+
+// Circuit breaker states
+const (
+	BreakerClosed   BreakerState = "closed"    // 正常转发请求
+	BreakerOpen     BreakerState = "open"      // 冷却中，直接返回 ErrBackendUnavailable
+	BreakerHalfOpen BreakerState = "half-open" // 冷却结束，只放行一个请求测试后端是否恢复
+)
+
+// Breaker 包装后端 Downloader：连续 Threshold 次失败后熔断 Cooldown 时间，期间的请求直接失败，
+// 冷却结束后放行一个请求，成功则恢复，失败则重新熔断。被取消的请求和 4xx 响应不计入失败
+type Breaker struct {
+	Downloader Downloader
+	Threshold  int
+	Cooldown   time.Duration
+	Logger     *slog.Logger
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool // 半开状态下已经有一个请求在测试后端
+}
+
+// NewBreaker returns a closed Breaker around d
+func NewBreaker(d Downloader, threshold int, cooldown time.Duration, logger *slog.Logger) *Breaker {
+	return &Breaker{Downloader: d, Threshold: threshold, Cooldown: cooldown, Logger: logger, state: BreakerClosed}
+}
+
+// Download forwards to the wrapped Downloader unless the breaker is open
+func (b *Breaker) Download(ctx context.Context, url string) (Result, error) {
+	if err := b.allow(); err != nil {
+		return Result{}, err
+	}
+	result, err := b.Downloader.Download(ctx, url)
+	b.record(err)
+	return result, err
+}
+
+// State returns the current state, the number of consecutive failures and, while
+// open, when the next request will be let through
+func (b *Breaker) State() (state BreakerState, failures int, retryAt time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		retryAt = b.openedAt.Add(b.Cooldown)
+	}
+	return b.state, b.failures, retryAt
+}
+
+// allow 决定请求能否发送到后端，冷却结束时转为半开
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		remaining := time.Until(b.openedAt.Add(b.Cooldown))
+		if remaining > 0 {
+			return fmt.Errorf("%w: %d consecutive failures, retrying in %s", ErrBackendUnavailable, b.failures, remaining.Round(time.Second))
+		}
+		b.state = BreakerHalfOpen
+		b.Logger.Info("backend circuit half-open, testing backend")
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			return fmt.Errorf("%w: waiting for the backend to recover", ErrBackendUnavailable)
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record 记录一次请求的结果并切换状态
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	halfOpen := b.state == BreakerHalfOpen
+	b.probing = false
+	if errors.Is(err, context.Canceled) {
+		// 任务被取消，无法说明后端是否正常
+		return
+	}
+	// 后端拒绝了请求本身（4xx）说明它仍在正常响应
+	var statusErr *StatusError
+	if err == nil || errors.As(err, &statusErr) && !statusErr.Transient() {
+		if b.state != BreakerClosed {
+			b.Logger.Info("backend recovered, circuit closed")
+		}
+		b.state, b.failures = BreakerClosed, 0
+		return
+	}
+
+	b.failures++
+	if halfOpen || b.failures >= b.Threshold {
+		if !halfOpen {
+			b.Logger.Warn("backend failing, circuit open", "failures", b.failures, "cooldown", b.Cooldown.String())
+		}
+		b.state, b.openedAt = BreakerOpen, time.Now()
+	}
+}
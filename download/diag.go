@@ -0,0 +1,79 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// GalleryDLVersion returns the path of gallery-dl on PATH and the version it reports
+func GalleryDLVersion(ctx context.Context) (path, version string, err error) {
+	path, err = exec.LookPath("gallery-dl")
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrNotInstalled, err)
+	}
+	out, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		return path, "", fmt.Errorf("failed to run gallery-dl --version: %w", err)
+	}
+	return path, strings.TrimSpace(string(out)), nil
+}
+
+// FreeBytes returns the space available to the bot in dir. ok is false on
+// platforms where it cannot be determined.
+func FreeBytes(dir string) (free int64, ok bool, err error) {
+	return freeBytes(dir)
+}
+
+// proxyDefaultPorts 是代理地址没有写端口时各 scheme 使用的端口
+var proxyDefaultPorts = map[string]string{
+	"http":    "80",
+	"https":   "443",
+	"socks5":  "1080",
+	"socks5h": "1080",
+}
+
+// CheckProxy opens a TCP connection to the proxy to check that it is reachable.
+// It does not send a request through it.
+func CheckProxy(ctx context.Context, proxy string) error {
+	u, err := ParseProxy(proxy)
+	if err != nil {
+		return err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), proxyDefaultPorts[u.Scheme])
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// CheckBackend sends a HEAD request to the backend through proxy, as downloads do,
+// and returns the response status. Any HTTP response counts as reachable.
+func CheckBackend(ctx context.Context, backendURL, proxy string) (string, error) {
+	if backendURL == "" {
+		return "", errors.New("backend URL is not configured")
+	}
+	client, err := newBackendClient(proxy)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, backendURL, nil)
+	if err != nil {
+		return "", redactURLError(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Status, nil
+}
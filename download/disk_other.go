@@ -0,0 +1,8 @@
+//go:build !unix
+
+package download
+
+// freeBytes 在不支持 Statfs 的平台上无法获取可用空间，返回 false 跳过检查
+func freeBytes(dir string) (int64, bool, error) {
+	return 0, false, nil
+}
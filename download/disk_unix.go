@@ -0,0 +1,14 @@
+//go:build unix
+
+package download
+
+import "golang.org/x/sys/unix"
+
+// freeBytes 返回 dir 所在文件系统上非 root 用户可用的字节数
+func freeBytes(dir string) (int64, bool, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, false, err
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), true, nil
+}
@@ -0,0 +1,221 @@
+// Package download 定义下载方式的统一接口：直接调用 gallery-dl，或把 URL 交给 HTTP 后端
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"time"
+)
+
+// Mode 选择使用哪种下载方式
+const (
+	ModeBackend   = "backend"
+	ModeGalleryDL = "gallery-dl"
+)
+
+// ErrSizeLimit is returned when the downloaded files exceed the configured size cap
+var ErrSizeLimit = errors.New("download exceeds size limit")
+
+// ErrNotInstalled is returned when the gallery-dl binary cannot be found in PATH
+var ErrNotInstalled = errors.New("gallery-dl is not installed")
+
+// ErrLowDiskSpace is returned when the download directory has less free space than required
+var ErrLowDiskSpace = errors.New("not enough free disk space")
+
+// ErrProxyRequired is returned when a proxy is required but none is configured for the download
+var ErrProxyRequired = errors.New("a proxy is required but none is configured")
+
+// ErrProxyUnreachable is returned when a required proxy does not accept connections
+var ErrProxyUnreachable = errors.New("required proxy is unreachable")
+
+// Result describes the outcome of a successful download
+type Result struct {
+	Dir     string   // 本地输出目录，HTTP 后端模式下为空
+	Files   []string // 下载得到的文件路径，HTTP 后端模式下为空
+	Bytes   int64    // Files 的总大小
+	Body    string   // HTTP 后端的响应内容
+	Skipped int      // gallery-dl 因为已在 archive 中或文件已存在而跳过的文件数
+	// Metadata 把 Files 中的文件映射到 gallery-dl --write-metadata 为它写入的 JSON 文件，
+	// 这些 JSON 文件不包含在 Files 中
+	Metadata map[string]string
+}
+
+// Downloader downloads a single URL
+type Downloader interface {
+	Download(ctx context.Context, url string) (Result, error)
+}
+
+// DownloaderFunc adapts a function to the Downloader interface, for example to
+// replace the real download in a Bot with a stub
+type DownloaderFunc func(ctx context.Context, url string) (Result, error)
+
+// Download calls f(ctx, url)
+func (f DownloaderFunc) Download(ctx context.Context, url string) (Result, error) {
+	return f(ctx, url)
+}
+
+// ProgressFunc receives interesting output lines while a download is running
+type ProgressFunc func(line string)
+
+type progressKey struct{}
+
+// WithProgress returns a context that makes Download report progress lines to fn
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// progressFrom 取出 ctx 中的 ProgressFunc，没有时返回空操作
+func progressFrom(ctx context.Context) ProgressFunc {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok && fn != nil {
+		return fn
+	}
+	return func(string) {}
+}
+
+type resumeDirKey struct{}
+
+// WithResumeDir returns a context that makes gallery-dl download into dir, left by a
+// previous failed attempt, instead of a new directory. gallery-dl skips the files
+// already there and continues their .part files.
+func WithResumeDir(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, resumeDirKey{}, dir)
+}
+
+// resumeDirFrom 取出 ctx 中要继续使用的目录，没有时返回空字符串
+func resumeDirFrom(ctx context.Context) string {
+	dir, _ := ctx.Value(resumeDirKey{}).(string)
+	return dir
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a context whose backend requests carry key, so the
+// backend can recognise retries of the same download
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// idempotencyKeyFrom 取出 ctx 中的幂等 key，没有时返回空字符串
+func idempotencyKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKey{}).(string)
+	return key
+}
+
+type archiveKey struct{}
+
+// WithArchive returns a context that makes gallery-dl record downloaded items in
+// the archive file at path and skip items already recorded there
+func WithArchive(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, archiveKey{}, path)
+}
+
+// archiveFrom 取出 ctx 中的 archive 文件路径，没有时返回空字符串
+func archiveFrom(ctx context.Context) string {
+	path, _ := ctx.Value(archiveKey{}).(string)
+	return path
+}
+
+type chatIDKey struct{}
+
+// WithChatID returns a context that makes gallery-dl download into a directory of
+// its own for chatID, so that files requested by different chats are not mixed
+func WithChatID(ctx context.Context, chatID int64) context.Context {
+	return context.WithValue(ctx, chatIDKey{}, chatID)
+}
+
+// chatIDFrom 取出 ctx 中的 chat ID，没有时返回 0
+func chatIDFrom(ctx context.Context) int64 {
+	id, _ := ctx.Value(chatIDKey{}).(int64)
+	return id
+}
+
+type proxyKey struct{}
+
+// WithProxy returns a context that makes gallery-dl use proxy for this download
+// instead of the downloader's Proxy. An empty proxy runs it without --proxy.
+func WithProxy(ctx context.Context, proxy string) context.Context {
+	return context.WithValue(ctx, proxyKey{}, proxy)
+}
+
+// proxyFrom 取出 ctx 中的代理，ok 为 false 表示没有为这次下载指定代理
+func proxyFrom(ctx context.Context) (proxy string, ok bool) {
+	proxy, ok = ctx.Value(proxyKey{}).(string)
+	return proxy, ok
+}
+
+type outputDirKey struct{}
+
+// WithOutputDirFunc returns a context that makes Download call fn with the local
+// output directory as soon as it is created, before anything is downloaded into it
+func WithOutputDirFunc(ctx context.Context, fn func(dir string)) context.Context {
+	return context.WithValue(ctx, outputDirKey{}, fn)
+}
+
+// outputDirFuncFrom 取出 ctx 中的回调，没有时返回空操作
+func outputDirFuncFrom(ctx context.Context) func(dir string) {
+	if fn, ok := ctx.Value(outputDirKey{}).(func(dir string)); ok && fn != nil {
+		return fn
+	}
+	return func(string) {}
+}
+
+// Options holds the settings needed to construct any Downloader
+type Options struct {
+	BackendURL string // ModeBackend 使用的后端地址
+	// IdempotencyHeader 是 ModeBackend 携带幂等 key 的请求头，为空时不发送
+	IdempotencyHeader string
+	BreakerThreshold  int                   // ModeBackend 连续失败多少次后熔断，0 表示不使用熔断器
+	BreakerCooldown   time.Duration         // ModeBackend 熔断后等待多久再测试后端
+	DownloadDir       string                // ModeGalleryDL 的输出根目录
+	CookiesFile       string                // ModeGalleryDL 传给 --cookies 的 cookies.txt，为空时不使用
+	Proxy             string                // 下载使用的代理：ModeGalleryDL 传给 --proxy，ModeBackend 用于请求后端，为空时不使用代理
+	RequireProxy      bool                  // ModeGalleryDL 每次下载前检查代理已配置且可以连接，否则拒绝下载
+	PlatformArgs      map[Platform][]string // ModeGalleryDL 按平台追加的参数
+	ExtraArgs         []string              // ModeGalleryDL 对所有平台追加的参数
+	MaxBytes          int64                 // ModeGalleryDL 单次下载允许的总大小，0 表示不限制
+	MinFreeBytes      int64                 // ModeGalleryDL 开始下载前下载目录至少需要的可用空间，0 表示不检查
+	// FilenameTemplate 是 ModeGalleryDL 的文件名格式，为空时使用 gallery-dl 的默认格式
+	FilenameTemplate string
+	WriteMetadata    bool            // ModeGalleryDL 传入 --write-metadata，为每个文件写入 JSON 元数据
+	PostProcessors   []PostProcessor // ModeGalleryDL 下载成功后依次处理每个文件，例如 FFmpeg
+	Logger           *slog.Logger
+}
+
+// New returns the Downloader for mode
+func New(mode string, opts Options) (Downloader, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	switch mode {
+	case ModeBackend, "":
+		if opts.BackendURL == "" {
+			return nil, fmt.Errorf("download mode %q requires a backend URL", ModeBackend)
+		}
+		client, err := newBackendClient(opts.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		backend := &HTTPBackendDownloader{URL: opts.BackendURL, IdempotencyHeader: opts.IdempotencyHeader, Client: client, Logger: logger}
+		if opts.BreakerThreshold > 0 {
+			return NewBreaker(backend, opts.BreakerThreshold, opts.BreakerCooldown, logger), nil
+		}
+		return backend, nil
+	case ModeGalleryDL:
+		// 启动时就检查，避免每个 URL 都在重试中得到同样的错误
+		if _, err := exec.LookPath("gallery-dl"); err != nil {
+			return nil, fmt.Errorf("%w (not found in PATH), install it first: %v", ErrNotInstalled, err)
+		}
+		d := &GalleryDLDownloader{BaseDir: opts.DownloadDir, CookiesFile: opts.CookiesFile, Proxy: opts.Proxy, RequireProxy: opts.RequireProxy, PlatformArgs: opts.PlatformArgs, ExtraArgs: opts.ExtraArgs, MaxBytes: opts.MaxBytes, MinFreeBytes: opts.MinFreeBytes, FilenameTemplate: opts.FilenameTemplate, WriteMetadata: opts.WriteMetadata, Logger: logger}
+		if len(opts.PostProcessors) > 0 {
+			return &postProcessing{Downloader: d, processors: opts.PostProcessors, logger: logger}, nil
+		}
+		return d, nil
+	default:
+		return nil, fmt.Errorf("unknown download mode %q, expected %q or %q", mode, ModeBackend, ModeGalleryDL)
+	}
+}
@@ -0,0 +1,56 @@
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// extractorCategoryRegex 匹配 gallery-dl --list-extractors 输出中的 "Category: bilibili - Subcategory: user" 行
+var extractorCategoryRegex = regexp.MustCompile(`^Category:\s*(\S+)`)
+
+// 进程内缓存 gallery-dl 支持的站点，只在第一次成功获取后保存
+var (
+	extractorsMu sync.Mutex
+	extractors   []string
+)
+
+// Extractors returns the site categories supported by the installed gallery-dl,
+// sorted and without duplicates. The list is cached for the lifetime of the process.
+func Extractors(ctx context.Context) ([]string, error) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	if extractors != nil {
+		return extractors, nil
+	}
+
+	out, err := exec.CommandContext(ctx, "gallery-dl", "--list-extractors").Output()
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %v", ErrNotInstalled, err)
+		}
+		return nil, fmt.Errorf("failed to list gallery-dl extractors: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var categories []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if m := extractorCategoryRegex.FindStringSubmatch(scanner.Text()); m != nil && !seen[m[1]] {
+			seen[m[1]] = true
+			categories = append(categories, m[1])
+		}
+	}
+	if len(categories) == 0 {
+		return nil, errors.New("gallery-dl --list-extractors returned no extractors")
+	}
+	sort.Strings(categories)
+	extractors = categories
+	return extractors, nil
+}
@@ -0,0 +1,60 @@
+package download
+
+import (
+	"errors"
+	"regexp"
+)
+
+// gallery-dl 常见的失败原因，CommandError 能识别时可以用 errors.Is 判断
+var (
+	// ErrUnsupportedURL 表示 gallery-dl 没有能处理该 URL 的 extractor
+	ErrUnsupportedURL = errors.New("no gallery-dl extractor supports this URL")
+	// ErrLoginRequired 表示网站要求登录或拒绝了提供的凭证
+	ErrLoginRequired = errors.New("login required")
+	// ErrNotFound 表示内容不存在或已被删除
+	ErrNotFound = errors.New("content not found")
+	// ErrNetwork 表示无法连接到网站或代理
+	ErrNetwork = errors.New("network error")
+)
+
+// gallery-dl 的退出码是各个错误码按位或的结果
+const (
+	exitNotFound    = 8
+	exitAuth        = 16
+	exitNoExtractor = 64
+)
+
+var (
+	unsupportedRegex = regexp.MustCompile(`(?i)unsupported url|no suitable extractor`)
+	loginRegex       = regexp.MustCompile(`(?i)authenticationerror|authorizationerror|login required|requires? (?:a )?login|not logged in|cookies? (?:are |is )?(?:required|expired|invalid)`)
+	notFoundRegex    = regexp.MustCompile(`(?i)notfounderror|404 not found|could not be found|has been deleted`)
+	networkRegex     = regexp.MustCompile(`(?i)connectionerror|failed to establish a new connection|max retries exceeded|timed out|name or service not known|temporary failure in name resolution|connection (?:reset|refused|aborted)|proxyerror`)
+)
+
+// classifyFailure 根据 gallery-dl 的退出码和 stderr 判断失败原因，无法识别时返回 nil。
+// stderr 比退出码更具体，先按 stderr 匹配
+func classifyFailure(exitCode int, stderr string) error {
+	switch {
+	case unsupportedRegex.MatchString(stderr):
+		return ErrUnsupportedURL
+	case loginRegex.MatchString(stderr):
+		return ErrLoginRequired
+	case notFoundRegex.MatchString(stderr):
+		return ErrNotFound
+	case networkRegex.MatchString(stderr):
+		return ErrNetwork
+	}
+	// 被信号终止时 exitCode 为 -1
+	if exitCode <= 0 {
+		return nil
+	}
+	switch {
+	case exitCode&exitNoExtractor != 0:
+		return ErrUnsupportedURL
+	case exitCode&exitAuth != 0:
+		return ErrLoginRequired
+	case exitCode&exitNotFound != 0:
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package download
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode int
+		stderr   string
+		want     error
+	}{
+		{"unsupported url", 64, "[gallery-dl][error] Unsupported URL 'https://example.com/a'", ErrUnsupportedURL},
+		{"no suitable extractor", 1, "[gallery-dl][error] No suitable extractor found for 'https://example.com/a'", ErrUnsupportedURL},
+		{"login", 16, "[xiaohongshu][error] AuthorizationError: Login required", ErrLoginRequired},
+		{"expired cookies", 1, "[douyin][warning] cookies expired", ErrLoginRequired},
+		{"http 404", 4, "[xiaohongshu][error] HttpError: '404 Not Found' for 'https://www.xiaohongshu.com/explore/1'", ErrNotFound},
+		{"not found error", 8, "[bilibili][error] NotFoundError: Requested video could not be found", ErrNotFound},
+		{"connection", 1, "[downloader.http][error] ConnectionError: HTTPSConnectionPool(host='sns-img.xhscdn.com', port=443): Max retries exceeded", ErrNetwork},
+		{"dns", 1, "[gallery-dl][error] Temporary failure in name resolution", ErrNetwork},
+		{"exit code only", 64, "", ErrUnsupportedURL},
+		{"combined exit code", 16 | 8, "", ErrLoginRequired},
+		{"http error exit code", 4, "[gallery-dl][error] HttpError: '500 Internal Server Error'", nil},
+		{"unknown", 1, "[gallery-dl][error] KeyError: 'note'", nil},
+		{"killed", -1, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyFailure(tt.exitCode, tt.stderr); got != tt.want {
+				t.Errorf("classifyFailure(%d, %q) = %v, want %v", tt.exitCode, tt.stderr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandErrorMatchesReason(t *testing.T) {
+	exitErr := &exec.ExitError{}
+	err := error(&CommandError{Err: exitErr, Stderr: "Unsupported URL", Reason: ErrUnsupportedURL})
+	if !errors.Is(err, ErrUnsupportedURL) {
+		t.Errorf("errors.Is(%v, ErrUnsupportedURL) = false", err)
+	}
+	var target *exec.ExitError
+	if !errors.As(err, &target) {
+		t.Errorf("errors.As(%v, *exec.ExitError) = false", err)
+	}
+	if errors.Is(&CommandError{Err: exitErr}, ErrNetwork) {
+		t.Error("CommandError without Reason matches ErrNetwork")
+	}
+}
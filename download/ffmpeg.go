@@ -0,0 +1,119 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrFFmpegNotInstalled is returned by NewFFmpeg when ffmpeg cannot be found in PATH
+var ErrFFmpegNotInstalled = errors.New("ffmpeg is not installed")
+
+// videoExts 是 FFmpeg 会处理的视频扩展名
+var videoExts = map[string]bool{".mp4": true, ".mov": true, ".m4v": true, ".webm": true, ".mkv": true, ".flv": true, ".avi": true}
+
+// FFmpeg 把视频转换为 Telegram 可以直接预览的 H.264/AAC MP4。编码已经兼容时只重新封装，
+// 不重新编码；没有 ffprobe 时总是重新编码
+type FFmpeg struct {
+	Logger *slog.Logger
+}
+
+// NewFFmpeg returns an FFmpeg post-processor, or ErrFFmpegNotInstalled
+func NewFFmpeg(logger *slog.Logger) (*FFmpeg, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, fmt.Errorf("%w (not found in PATH): %v", ErrFFmpegNotInstalled, err)
+	}
+	return &FFmpeg{Logger: logger}, nil
+}
+
+// Process converts path if it is a video that is not already an H.264/AAC MP4
+func (f *FFmpeg) Process(ctx context.Context, path string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if !videoExts[ext] {
+		return path, nil
+	}
+	compatible := f.compatible(ctx, path)
+	if compatible && ext == ".mp4" {
+		return path, nil
+	}
+
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	final := base + ".mp4"
+	out := final
+	if final == path {
+		// 原文件就是 .mp4，先写入临时文件，转换完成后替换原文件
+		out = unusedName(base)
+	} else if _, err := os.Stat(final); err == nil {
+		// 同名的 .mp4 是另一个文件，不能覆盖，改用其它文件名
+		final = unusedName(base)
+		out = final
+	}
+	args := []string{"-y", "-v", "error", "-i", path}
+	if compatible {
+		args = append(args, "-c", "copy")
+	} else {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p", "-c:a", "aac", "-b:a", "128k")
+	}
+	// 把 moov 放在文件开头，Telegram 客户端可以边下载边播放
+	args = append(args, "-movflags", "+faststart", out)
+
+	f.Logger.Info("converting video with ffmpeg", "path", path, "remux", compatible)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	detachSignals(cmd)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(out)
+		return "", fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	if err := os.Remove(path); err != nil {
+		return "", err
+	}
+	if out != final {
+		if err := os.Rename(out, final); err != nil {
+			return "", err
+		}
+		out = final
+	}
+	return out, nil
+}
+
+// unusedName 返回 base 之后加上 .converted.mp4 或 .converted-N.mp4 的第一个不存在的文件名
+func unusedName(base string) string {
+	name := base + ".converted.mp4"
+	for i := 2; ; i++ {
+		if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+			return name
+		}
+		name = fmt.Sprintf("%s.converted-%d.mp4", base, i)
+	}
+}
+
+// compatible 用 ffprobe 检查视频是否是 H.264，音频（如果有）是否是 AAC
+func (f *FFmpeg) compatible(ctx context.Context, path string) bool {
+	output, err := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-show_entries", "stream=codec_type,codec_name", "-of", "csv=p=0", path).Output()
+	if err != nil {
+		f.Logger.Debug("ffprobe failed, transcoding", "path", path, "error", err)
+		return false
+	}
+	video := false
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		name, kind, _ := strings.Cut(strings.TrimSpace(line), ",")
+		switch kind {
+		case "video":
+			if name != "h264" {
+				return false
+			}
+			video = true
+		case "audio":
+			if name != "aac" {
+				return false
+			}
+		}
+	}
+	return video
+}
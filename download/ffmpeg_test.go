@@ -0,0 +1,122 @@
+package download
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// fakeFFmpeg 在 PATH 前面放上假的 ffmpeg 和 ffprobe：ffprobe 输出 streams，
+// ffmpeg 把 "converted " 加上输入文件的内容写到最后一个参数
+func fakeFFmpeg(t *testing.T, streams string) {
+	t.Helper()
+	dir := t.TempDir()
+	scripts := map[string]string{
+		"ffprobe": "printf '" + streams + "\\n'",
+		"ffmpeg": `while [ $# -gt 1 ]; do [ "$1" = "-i" ] && in="$2"; shift; done
+{ printf 'converted '; cat "$in"; } > "$1"`,
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestFFmpegOutputName(t *testing.T) {
+	tests := []struct {
+		name    string
+		streams string
+		source  string
+		// existing 是转换前已经在目录中的其它文件
+		existing []string
+		want     string
+		// wantFiles 是转换后目录中的文件和内容
+		wantFiles map[string]string
+	}{
+		{
+			name:      "webm",
+			streams:   "vp9,video",
+			source:    "1.webm",
+			want:      "1.mp4",
+			wantFiles: map[string]string{"1.mp4": "converted 1.webm"},
+		},
+		{
+			name:      "webm next to an unrelated mp4",
+			streams:   "vp9,video",
+			source:    "1.webm",
+			existing:  []string{"1.mp4"},
+			want:      "1.converted.mp4",
+			wantFiles: map[string]string{"1.mp4": "1.mp4", "1.converted.mp4": "converted 1.webm"},
+		},
+		{
+			name:     "every converted name taken",
+			streams:  "vp9,video",
+			source:   "1.webm",
+			existing: []string{"1.mp4", "1.converted.mp4"},
+			want:     "1.converted-2.mp4",
+			wantFiles: map[string]string{
+				"1.mp4":             "1.mp4",
+				"1.converted.mp4":   "1.converted.mp4",
+				"1.converted-2.mp4": "converted 1.webm",
+			},
+		},
+		{
+			name:      "incompatible mp4 replaced",
+			streams:   "hevc,video",
+			source:    "1.mp4",
+			want:      "1.mp4",
+			wantFiles: map[string]string{"1.mp4": "converted 1.mp4"},
+		},
+		{
+			name:      "compatible mp4 kept",
+			streams:   "h264,video\\naac,audio",
+			source:    "1.mp4",
+			want:      "1.mp4",
+			wantFiles: map[string]string{"1.mp4": "1.mp4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFFmpeg(t, tt.streams)
+			dir := t.TempDir()
+			for _, name := range append(slices.Clone(tt.existing), tt.source) {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			f := &FFmpeg{Logger: testLogger()}
+			got, err := f.Process(context.Background(), filepath.Join(dir, tt.source))
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if got != filepath.Join(dir, tt.want) {
+				t.Errorf("Process() = %s, want %s", got, filepath.Join(dir, tt.want))
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := make(map[string]string)
+			for _, e := range entries {
+				data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				files[e.Name()] = string(data)
+			}
+			if len(files) != len(tt.wantFiles) {
+				t.Errorf("files after Process() = %q, want %q", files, tt.wantFiles)
+			}
+			for name, want := range tt.wantFiles {
+				if files[name] != want {
+					t.Errorf("%s = %q, want %q", name, files[name], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package download
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// dirSeq 保证同一时刻开始的下载也使用不同的目录
+var dirSeq atomic.Int64
+
+// GalleryDLDownloader 在本机调用 gallery-dl 下载，每次下载写入独立的目录
+type GalleryDLDownloader struct {
+	BaseDir      string                // 输出根目录
+	CookiesFile  string                // 为空时不传 --cookies
+	Proxy        string                // 默认代理，为空时不传 --proxy；WithProxy 可以为单次下载指定其它代理
+	RequireProxy bool                  // 为 true 时没有代理或代理无法连接就不运行 gallery-dl
+	PlatformArgs map[Platform][]string // 按平台追加的参数，例如 {"bilibili": ["-o", "videos=true"]}
+	ExtraArgs    []string              // 对所有平台追加的参数，例如 ["--no-mtime", "--write-metadata"]
+	MaxBytes     int64                 // 单次下载允许的总大小，0 表示不限制
+	MinFreeBytes int64                 // 开始下载前 BaseDir 至少需要的可用空间，0 表示不检查
+	// FilenameTemplate 通过 -f 传给 gallery-dl 的文件名格式，例如 {author}_{title}_{num}.{extension}，为空时使用默认格式
+	FilenameTemplate string
+	WriteMetadata    bool // 传入 --write-metadata，标题、作者等信息写入每个文件旁边的 <文件名>.json
+	Logger           *slog.Logger
+}
+
+// Download 运行 gallery-dl 下载单个 URL，返回输出目录和其中的文件
+func (d *GalleryDLDownloader) Download(ctx context.Context, url string) (Result, error) {
+	// 先做不需要创建任何东西的检查，被拒绝时不会留下空目录，也不会记录输出目录
+	if err := d.checkFreeSpace(); err != nil {
+		return Result{}, err
+	}
+	proxy := d.Proxy
+	if p, ok := proxyFrom(ctx); ok {
+		proxy = p
+	}
+	if d.RequireProxy {
+		if err := d.checkRequiredProxy(ctx, proxy); err != nil {
+			return Result{}, err
+		}
+	}
+
+	dir, err := d.outputDir(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	outputDirFuncFrom(ctx)(dir)
+
+	archive := archiveFrom(ctx)
+	if archive != "" {
+		if err := os.MkdirAll(filepath.Dir(archive), 0755); err != nil {
+			return Result{Dir: dir}, fmt.Errorf("failed to create archive directory: %w", err)
+		}
+	}
+
+	args := d.args(dir, url, archive, proxy, optionsFrom(ctx))
+	d.Logger.Debug("gallery-dl arguments", "url", url, "args", redactArgs(args))
+	cmd := exec.CommandContext(ctx, "gallery-dl", args...)
+	detachSignals(cmd)
+	// stderr 仍然输出到进程的 stderr，同时保留末尾部分用于失败时的错误信息
+	stderr := &tailBuffer{max: stderrTailBytes}
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return Result{Dir: dir}, err
+	}
+
+	d.Logger.Info("running gallery-dl", "url", url, "dir", dir)
+	if err := cmd.Start(); err != nil {
+		// 启动后 gallery-dl 被卸载或 PATH 被修改
+		if errors.Is(err, exec.ErrNotFound) {
+			if err := os.RemoveAll(dir); err != nil {
+				d.Logger.Warn("failed to remove download directory", "dir", dir, "error", err)
+			}
+			return Result{}, fmt.Errorf("%w: %v", ErrNotInstalled, err)
+		}
+		return Result{Dir: dir}, fmt.Errorf("failed to run gallery-dl: %w", err)
+	}
+
+	// 必须在 Wait 之前读完 stdout
+	var skipped int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		skipped = d.streamOutput(stdout, url, progressFrom(ctx))
+	}()
+	<-done
+
+	if err := cmd.Wait(); err != nil {
+		// 被取消时 gallery-dl 已被杀死，删除下载了一半的文件；超时则保留，重试时可以继续下载
+		if errors.Is(ctx.Err(), context.Canceled) {
+			if err := os.RemoveAll(dir); err != nil {
+				d.Logger.Warn("failed to remove cancelled download", "dir", dir, "error", err)
+			}
+			return Result{}, ctx.Err()
+		}
+		if ctx.Err() != nil {
+			return Result{Dir: dir}, ctx.Err()
+		}
+		cmdErr := &CommandError{Err: err, Stderr: stderr.String()}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			cmdErr.Reason = classifyFailure(exitErr.ExitCode(), cmdErr.Stderr)
+		}
+		return Result{Dir: dir}, cmdErr
+	}
+
+	// 下载成功后剩下的 .part 是被放弃的旧文件，不属于结果
+	d.removePartFiles(dir)
+	files, metadata, bytes, err := listFiles(dir)
+	if err != nil {
+		return Result{Dir: dir}, fmt.Errorf("failed to list downloaded files: %w", err)
+	}
+	// --filesize-max 只限制单个文件，总大小需要下载后再检查
+	if d.MaxBytes > 0 && bytes > d.MaxBytes {
+		if err := os.RemoveAll(dir); err != nil {
+			d.Logger.Warn("failed to remove oversized download", "dir", dir, "error", err)
+		}
+		d.Logger.Warn("download exceeds size limit, removed", "url", url, "dir", dir, "bytes", bytes, "limit", d.MaxBytes)
+		return Result{}, fmt.Errorf("%w: %d bytes > %d bytes", ErrSizeLimit, bytes, d.MaxBytes)
+	}
+
+	return Result{Dir: dir, Files: files, Bytes: bytes, Skipped: skipped, Metadata: metadata}, nil
+}
+
+// stderrTailBytes 是 CommandError 中保留的 stderr 末尾字节数
+const stderrTailBytes = 4096
+
+// CommandError is returned when gallery-dl exits unsuccessfully. Stderr holds the
+// end of what it wrote to stderr, which usually explains the failure. Reason is one
+// of ErrUnsupportedURL, ErrLoginRequired, ErrNotFound and ErrNetwork when the failure
+// was recognised, and errors.Is matches it.
+type CommandError struct {
+	Err    error
+	Stderr string
+	Reason error
+}
+
+func (e *CommandError) Error() string {
+	if e.Reason != nil {
+		return "failed to run gallery-dl: " + e.Reason.Error() + ": " + e.Err.Error()
+	}
+	return "failed to run gallery-dl: " + e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() []error {
+	if e.Reason != nil {
+		return []error{e.Err, e.Reason}
+	}
+	return []error{e.Err}
+}
+
+// Summary returns the last lines non-empty lines of Stderr, each at most 200 bytes,
+// with credentials and local paths removed so that it can be shown to users
+func (e *CommandError) Summary(lines int) string {
+	var kept []string
+	all := strings.Split(e.Stderr, "\n")
+	for i := len(all) - 1; i >= 0 && len(kept) < lines; i-- {
+		// 截断之前去掉凭证，避免截断后的凭证不再被识别
+		line := Redact(strings.TrimSpace(all[i]))
+		if line == "" {
+			continue
+		}
+		if len(line) > 200 {
+			line = strings.ToValidUTF8(line[:200], "") + "..."
+		}
+		kept = append(kept, line)
+	}
+	slices.Reverse(kept)
+	return strings.Join(kept, "\n")
+}
+
+// tailBuffer 只保留最后写入的 max 个字节
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = t.buf[len(t.buf)-t.max:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return strings.TrimSpace(strings.ToValidUTF8(string(t.buf), ""))
+}
+
+// progressLineRegex 匹配值得转发给用户的输出行：下载的媒体文件路径或 [download] 日志
+var progressLineRegex = regexp.MustCompile(`(?i)^\[download\]|\.(jpe?g|png|webp|gif|heic|mp4|mov|webm|mkv|m4a|mp3)$`)
+
+// skippedPrefix 是 gallery-dl 输出已存在或已在 archive 中而跳过的文件时使用的前缀
+const skippedPrefix = "# "
+
+// streamOutput 逐行读取 gallery-dl 的 stdout，全部写入日志，匹配的行交给 progress，
+// 返回被跳过的文件数
+func (d *GalleryDLDownloader) streamOutput(r io.Reader, url string, progress ProgressFunc) int {
+	skipped := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		d.Logger.Info("gallery-dl output", "url", url, "line", line)
+		if strings.HasPrefix(line, skippedPrefix) {
+			skipped++
+			continue
+		}
+		if progressLineRegex.MatchString(line) {
+			progress(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		d.Logger.Warn("failed to read gallery-dl output", "url", url, "error", err)
+	}
+	return skipped
+}
+
+// args 构造 gallery-dl 的参数列表，archive 不为空时传给 --download-archive，proxy 不为空时传给 --proxy
+func (d *GalleryDLDownloader) args(dir, url, archive, proxy string, options []string) []string {
+	// 保留 .part 文件，重试时 gallery-dl 从中断的位置继续下载，而不是从头开始
+	args := []string{"-o", "downloader.part=true"}
+	if proxy != "" {
+		args = append(args, "--proxy", proxy)
+	}
+	if d.CookiesFile != "" {
+		args = append(args, "--cookies", d.CookiesFile)
+	}
+	if d.MaxBytes > 0 {
+		args = append(args, "--filesize-max", strconv.FormatInt(d.MaxBytes, 10))
+	}
+	if d.FilenameTemplate != "" {
+		args = append(args, "-f", d.FilenameTemplate)
+	}
+	if d.WriteMetadata {
+		args = append(args, "--write-metadata")
+	}
+	if archive != "" {
+		args = append(args, "--download-archive", archive)
+	}
+	args = append(args, d.ExtraArgs...)
+	args = append(args, d.PlatformArgs[DetectPlatform(url)]...)
+	// 用户为这次下载选择的选项放在配置的参数之后，可以覆盖它们
+	for _, option := range options {
+		args = append(args, optionArgs[option]...)
+	}
+	return append(args, "-D", dir, url)
+}
+
+// ValidateFilenameTemplate 检查文件名格式中没有控制字符、shell 元字符或 ..。
+// gallery-dl 不经过 shell 调用，这里主要防止配置错误导致文件写到输出目录之外
+func ValidateFilenameTemplate(template string) error {
+	if strings.Contains(template, "..") {
+		return fmt.Errorf("filename template %q must not contain ..", template)
+	}
+	for _, r := range template {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune("`$;&|<>\\", r) {
+			return fmt.Errorf("filename template %q contains forbidden character %q", template, r)
+		}
+	}
+	return nil
+}
+
+// checkFreeSpace 在 BaseDir 的可用空间低于 MinFreeBytes 时返回 ErrLowDiskSpace
+func (d *GalleryDLDownloader) checkFreeSpace() error {
+	if d.MinFreeBytes <= 0 {
+		return nil
+	}
+	// 第一次下载前 BaseDir 可能还不存在，检查最近的已存在的上级目录
+	dir := d.BaseDir
+	for parent := filepath.Dir(dir); parent != dir; parent = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		dir = parent
+	}
+	free, ok, err := freeBytes(dir)
+	if err != nil {
+		// 无法获取时不阻止下载，只记录日志
+		d.Logger.Warn("failed to check free disk space", "dir", dir, "error", err)
+		return nil
+	}
+	if ok && free < d.MinFreeBytes {
+		d.Logger.Warn("not enough free disk space, refusing download", "dir", d.BaseDir, "free", free, "required", d.MinFreeBytes)
+		return fmt.Errorf("%w: %d bytes free, %d bytes required", ErrLowDiskSpace, free, d.MinFreeBytes)
+	}
+	return nil
+}
+
+// proxyCheckTimeout 是每次下载前检查代理能否连接的超时
+const proxyCheckTimeout = 5 * time.Second
+
+// checkRequiredProxy 在没有代理时返回 ErrProxyRequired，代理无法连接时返回 ErrProxyUnreachable
+func (d *GalleryDLDownloader) checkRequiredProxy(ctx context.Context, proxy string) error {
+	if proxy == "" {
+		return ErrProxyRequired
+	}
+	ctx, cancel := context.WithTimeout(ctx, proxyCheckTimeout)
+	defer cancel()
+	if err := CheckProxy(ctx, proxy); err != nil {
+		d.Logger.Warn("required proxy is unreachable, refusing download", "proxy", redactURL(proxy), "error", err)
+		return fmt.Errorf("%w: %v", ErrProxyUnreachable, err)
+	}
+	return nil
+}
+
+// outputDir 返回本次下载的目录：重试时继续使用上次失败留下的目录，否则新建一个
+func (d *GalleryDLDownloader) outputDir(ctx context.Context) (string, error) {
+	if dir := resumeDirFrom(ctx); dir != "" {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			d.Logger.Info("resuming download in previous directory", "dir", dir)
+			return dir, nil
+		}
+	}
+	return d.newOutputDir(chatIDFrom(ctx))
+}
+
+// removePartFiles 删除 dir 中 gallery-dl 没有下载完的 .part 文件
+func (d *GalleryDLDownloader) removePartFiles(dir string) {
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() && strings.HasSuffix(path, ".part") {
+			d.Logger.Debug("removing stale partial file", "path", path)
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		d.Logger.Warn("failed to remove partial files", "dir", dir, "error", err)
+	}
+}
+
+// newOutputDir 在 BaseDir 下创建形如 <chat ID>/<时间戳>-<序号> 的目录，chatID 为 0 时不使用 chat 目录
+func (d *GalleryDLDownloader) newOutputDir(chatID int64) (string, error) {
+	name := time.Now().Format("20060102-150405") + "-" + strconv.FormatInt(dirSeq.Add(1), 10)
+	dir := filepath.Join(d.BaseDir, name)
+	if chatID != 0 {
+		dir = filepath.Join(d.BaseDir, strconv.FormatInt(chatID, 10), name)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create download directory: %w", err)
+	}
+	return dir, nil
+}
+
+// listFiles 返回 dir 下的所有普通文件及其总大小。另一个文件旁边的 <文件名>.json 是
+// --write-metadata 写入的元数据，不作为下载结果返回，而是放在 metadata 中
+func listFiles(dir string) (files []string, metadata map[string]string, total int64, err error) {
+	var all []string
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		all = append(all, path)
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	exists := make(map[string]bool, len(all))
+	for _, path := range all {
+		exists[path] = true
+	}
+	for _, path := range all {
+		if file, ok := strings.CutSuffix(path, ".json"); ok && exists[file] {
+			if metadata == nil {
+				metadata = make(map[string]string)
+			}
+			metadata[file] = path
+			continue
+		}
+		files = append(files, path)
+	}
+	return files, metadata, total, nil
+}
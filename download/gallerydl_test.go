@@ -0,0 +1,126 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestGalleryDLArgs(t *testing.T) {
+	const url = "https://www.xiaohongshu.com/explore/abc"
+	tests := []struct {
+		name    string
+		d       GalleryDLDownloader
+		proxy   string
+		archive string
+		options []string
+		want    []string
+	}{
+		{
+			name: "no proxy",
+			want: []string{"-o", "downloader.part=true", "-D", "/dl/1", url},
+		},
+		{
+			name:  "proxy",
+			proxy: "socks5://127.0.0.1:1080",
+			want:  []string{"-o", "downloader.part=true", "--proxy", "socks5://127.0.0.1:1080", "-D", "/dl/1", url},
+		},
+		{
+			name:    "cookies, archive and options",
+			d:       GalleryDLDownloader{CookiesFile: "/etc/bot/cookies.txt", ExtraArgs: []string{"--no-mtime"}},
+			archive: "/archives/chat-1.sqlite3",
+			options: []string{OptionAudio},
+			want: append(append([]string{"-o", "downloader.part=true", "--cookies", "/etc/bot/cookies.txt",
+				"--download-archive", "/archives/chat-1.sqlite3", "--no-mtime"}, optionArgs[OptionAudio]...), "-D", "/dl/1", url),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.d.args("/dl/1", url, tt.archive, tt.proxy, tt.options)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("args() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// closedAddr 返回一个没有监听的本地地址
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestGalleryDLRequireProxy(t *testing.T) {
+	unreachable := "http://" + closedAddr(t)
+	tests := []struct {
+		name     string
+		proxy    string
+		ctxProxy *string
+		want     error
+	}{
+		{name: "no proxy", want: ErrProxyRequired},
+		{name: "unreachable proxy", proxy: unreachable, want: ErrProxyUnreachable},
+		{name: "chat without proxy", proxy: unreachable, ctxProxy: new(string), want: ErrProxyRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			d := &GalleryDLDownloader{BaseDir: base, Proxy: tt.proxy, RequireProxy: true, Logger: testLogger()}
+			ctx := context.Background()
+			if tt.ctxProxy != nil {
+				ctx = WithProxy(ctx, *tt.ctxProxy)
+			}
+			result, err := d.Download(ctx, "https://www.xiaohongshu.com/explore/abc")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Download() error = %v, want %v", err, tt.want)
+			}
+			if result.Dir != "" {
+				t.Errorf("Download() created output directory %s", result.Dir)
+			}
+			// gallery-dl 没有运行，也不应该创建任何目录
+			if entries, _ := os.ReadDir(base); len(entries) > 0 {
+				t.Errorf("base directory not empty: %v", entries)
+			}
+		})
+	}
+}
+
+func TestGalleryDLLowDiskSpace(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("free disk space is not checked on this platform")
+	}
+	// BaseDir 还不存在时检查上级目录
+	base := filepath.Join(t.TempDir(), "downloads")
+	d := &GalleryDLDownloader{BaseDir: base, MinFreeBytes: math.MaxInt64, Logger: testLogger()}
+	var recorded []string
+	ctx := WithOutputDirFunc(context.Background(), func(dir string) { recorded = append(recorded, dir) })
+
+	result, err := d.Download(ctx, "https://www.xiaohongshu.com/explore/abc")
+	if !errors.Is(err, ErrLowDiskSpace) {
+		t.Fatalf("Download() error = %v, want ErrLowDiskSpace", err)
+	}
+	if result.Dir != "" || len(recorded) > 0 {
+		t.Errorf("Download() created output directory: result %q, recorded %q", result.Dir, recorded)
+	}
+	if _, err := os.Stat(base); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("base directory exists after refusing the download: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package download
+
+import (
+	"context"
+	"sort"
+)
+
+// 用户可以在消息中用前缀选择的下载选项，例如 "audio: <链接>"
+const (
+	OptionAudio = "audio" // 只下载音频
+	OptionHD    = "hd"    // 下载最高画质
+)
+
+// optionArgs 是每个选项追加给 gallery-dl 的参数。视频由 gallery-dl 的 ytdl 下载器处理，
+// 选项通过它的 format 选择格式，对图片没有影响
+var optionArgs = map[string][]string{
+	OptionAudio: {"-o", "downloader.ytdl.format=bestaudio/best"},
+	OptionHD:    {"-o", "downloader.ytdl.format=bestvideo*+bestaudio/best"},
+}
+
+// IsOption reports whether name is a known download option
+func IsOption(name string) bool {
+	_, ok := optionArgs[name]
+	return ok
+}
+
+// OptionNames returns the known download options, sorted
+func OptionNames() []string {
+	names := make([]string, 0, len(optionArgs))
+	for name := range optionArgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type optionsKey struct{}
+
+// WithOptions returns a context that makes Download apply the given options.
+// gallery-dl receives the matching arguments; the backend receives the names.
+func WithOptions(ctx context.Context, options []string) context.Context {
+	return context.WithValue(ctx, optionsKey{}, options)
+}
+
+// optionsFrom 取出 ctx 中的下载选项
+func optionsFrom(ctx context.Context) []string {
+	options, _ := ctx.Value(optionsKey{}).([]string)
+	return options
+}
@@ -0,0 +1,49 @@
+package download
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Platform identifies the site a URL belongs to
+type Platform string
+
+const (
+	PlatformXiaohongshu Platform = "xiaohongshu"
+	PlatformDouyin      Platform = "douyin"
+	PlatformBilibili    Platform = "bilibili"
+	PlatformUnknown     Platform = "unknown"
+)
+
+// Platforms lists every supported platform in display order
+var Platforms = []Platform{PlatformXiaohongshu, PlatformDouyin, PlatformBilibili}
+
+// Hosts returns the domains recognised as p, including short-link domains
+func (p Platform) Hosts() []string {
+	return platformHosts[p]
+}
+
+// platformHosts 每个平台的域名（包含短链接域名），子域名同样匹配
+var platformHosts = map[Platform][]string{
+	PlatformXiaohongshu: {"xiaohongshu.com", "xhslink.com"},
+	PlatformDouyin:      {"douyin.com", "iesdouyin.com"},
+	PlatformBilibili:    {"bilibili.com", "b23.tv"},
+}
+
+// DetectPlatform classifies rawURL by its host
+func DetectPlatform(rawURL string) Platform {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return PlatformUnknown
+	}
+	host := strings.ToLower(u.Hostname())
+
+	for platform, domains := range platformHosts {
+		for _, domain := range domains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return platform
+			}
+		}
+	}
+	return PlatformUnknown
+}
@@ -0,0 +1,59 @@
+package download
+
+import (
+	"context"
+	"log/slog"
+	"os"
+)
+
+// PostProcessor changes a downloaded file before it is uploaded, for example to
+// convert a video. It returns the path of the resulting file, which may be path
+// itself; a different path replaces the original file in the Result.
+type PostProcessor interface {
+	Process(ctx context.Context, path string) (string, error)
+}
+
+// postProcessing 包装 Downloader，下载成功后把每个文件依次交给 processors。
+// 处理失败的文件保持原样，不影响下载结果
+type postProcessing struct {
+	Downloader
+	processors []PostProcessor
+	logger     *slog.Logger
+}
+
+// Download downloads url and post-processes the files
+func (p *postProcessing) Download(ctx context.Context, url string) (Result, error) {
+	result, err := p.Downloader.Download(ctx, url)
+	if err != nil || len(result.Files) == 0 {
+		return result, err
+	}
+
+	metadata := make(map[string]string, len(result.Metadata))
+	var total int64
+	for i, file := range result.Files {
+		processed := file
+		for _, processor := range p.processors {
+			out, err := processor.Process(ctx, processed)
+			if err != nil {
+				if ctx.Err() != nil {
+					return Result{Dir: result.Dir}, ctx.Err()
+				}
+				p.logger.Warn("post-processing failed, keeping file as downloaded", "path", processed, "error", err)
+				continue
+			}
+			processed = out
+		}
+		result.Files[i] = processed
+		if meta, ok := result.Metadata[file]; ok {
+			metadata[processed] = meta
+		}
+		if info, err := os.Stat(processed); err == nil {
+			total += info.Size()
+		}
+	}
+	if result.Metadata != nil {
+		result.Metadata = metadata
+	}
+	result.Bytes = total
+	return result, nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package download
+
+import "os/exec"
+
+// detachSignals 在没有进程组的平台上不做处理
+func detachSignals(cmd *exec.Cmd) {}
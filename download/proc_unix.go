@@ -0,0 +1,14 @@
+//go:build unix
+
+package download
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachSignals 让 gallery-dl 运行在独立的进程组中，终端的 Ctrl-C 等发给整个进程组的信号
+// 不会直接杀死它，停止时由 SHUTDOWN_GRACE 决定何时终止
+func detachSignals(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
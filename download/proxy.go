@@ -0,0 +1,48 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// proxySchemes 是 PROXY_URL 支持的协议，gallery-dl 和 net/http 都能使用
+var proxySchemes = map[string]bool{"http": true, "https": true, "socks5": true, "socks5h": true}
+
+// ParseProxy parses a proxy URL and checks that its scheme is supported
+func ParseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// *url.Error 的信息中带有完整的 URL，只保留解析错误本身
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("invalid proxy URL %q: %w", redactURL(raw), err)
+	}
+	if !proxySchemes[u.Scheme] {
+		return nil, fmt.Errorf("unsupported proxy scheme %q in %q, expected http, https, socks5 or socks5h", u.Scheme, u.Redacted())
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", u.Redacted())
+	}
+	return u, nil
+}
+
+// newBackendClient 返回后端请求使用的 HTTP 客户端，proxy 不为空时所有请求都经过该代理
+func newBackendClient(proxy string) (*http.Client, error) {
+	client := &http.Client{Timeout: 10 * time.Minute}
+	if proxy == "" {
+		return client, nil
+	}
+	u, err := ParseProxy(proxy)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(u)
+	client.Transport = transport
+	return client, nil
+}
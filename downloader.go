@@ -0,0 +1,687 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/deckvig/telegram-bot/download"
+	"github.com/deckvig/telegram-bot/queue"
+)
+
+// Downloader runs download jobs on a fixed number of workers
+type Downloader struct {
+	jobs chan *queue.Job
+	wg   sync.WaitGroup
+
+	// stop 关闭后不再开始新的任务；ctx 是所有下载的父 context，宽限期结束后以 errShutdown 取消
+	stop     chan struct{}
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+	finished atomic.Int64 // 停止后仍然完成的任务数
+	killed   atomic.Int64 // 宽限期结束时被终止的任务数
+
+	mu     sync.Mutex
+	active map[int64][]*activeJob // 按 chat ID 记录正在下载的任务，按开始顺序排列
+
+	// perChat 是每个 chat 同时分配给 worker 的任务数上限，0 表示不限制。chatSlots 记录每个 chat
+	// 已分配（等待 worker 或正在下载）的任务数，受 mu 保护；达到上限的 chat 结束一个任务时通知 slotFreed
+	perChat   int
+	chatSlots map[int64]int
+	slotFreed chan struct{}
+
+	// 自启动以来的统计
+	running   atomic.Int64
+	succeeded atomic.Int64
+	failed    atomic.Int64
+}
+
+// activeJob 是一个正在下载的任务及其取消函数
+type activeJob struct {
+	jobID  int64
+	cancel context.CancelFunc
+}
+
+// DownloaderStats is a snapshot of the Downloader counters
+type DownloaderStats struct {
+	Waiting   int   // 已从队列取出、等待空闲 worker 的任务数
+	Running   int64 // 正在下载的任务数
+	Succeeded int64
+	Failed    int64
+}
+
+// NewDownloader starts a Downloader with the given number of workers. perChat limits how
+// many of them a single chat can use at the same time, 0 means no limit.
+func NewDownloader(concurrency, perChat int) *Downloader {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	d := &Downloader{
+		jobs:      make(chan *queue.Job, concurrency),
+		stop:      make(chan struct{}),
+		active:    make(map[int64][]*activeJob),
+		perChat:   perChat,
+		chatSlots: make(map[int64]int),
+		slotFreed: make(chan struct{}, 1),
+	}
+	d.ctx, d.cancel = context.WithCancelCause(context.Background())
+	for i := 0; i < concurrency; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+	logger.Info("started download workers", "concurrency", concurrency, "per_chat", perChat)
+	return d
+}
+
+// worker 从 jobs 通道中取任务执行，stop 关闭后退出
+func (d *Downloader) worker() {
+	defer d.wg.Done()
+	for {
+		var job *queue.Job
+		select {
+		case <-d.stop:
+			return
+		case job = <-d.jobs:
+		}
+		// stop 和 jobs 同时就绪时 select 随机选择，停止后取到的任务不再开始
+		select {
+		case <-d.stop:
+			d.releaseChat(job.ChatID)
+			releaseJob(job)
+			return
+		default:
+		}
+		ctx, cancel := context.WithCancel(d.ctx)
+		entry := d.track(job, cancel)
+
+		d.running.Add(1)
+		err := runDownloadWithRetry(ctx, job)
+		d.running.Add(-1)
+
+		d.untrack(job.ChatID, entry)
+		d.releaseChat(job.ChatID)
+		cancel()
+
+		select {
+		case <-d.stop:
+			if errors.Is(context.Cause(ctx), errShutdown) {
+				d.killed.Add(1)
+				continue
+			}
+			d.finished.Add(1)
+		default:
+		}
+		finishBatchJob(job, err)
+
+		if err != nil {
+			d.failed.Add(1)
+		} else {
+			d.succeeded.Add(1)
+		}
+	}
+}
+
+// track 记录一个开始下载的任务，使其可以被 Cancel 取消
+func (d *Downloader) track(job *queue.Job, cancel context.CancelFunc) *activeJob {
+	entry := &activeJob{jobID: job.ID, cancel: cancel}
+	d.mu.Lock()
+	d.active[job.ChatID] = append(d.active[job.ChatID], entry)
+	d.mu.Unlock()
+	return entry
+}
+
+// untrack 移除已结束的任务
+func (d *Downloader) untrack(chatID int64, entry *activeJob) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	jobs := d.active[chatID]
+	for i, j := range jobs {
+		if j == entry {
+			jobs = append(jobs[:i], jobs[i+1:]...)
+			break
+		}
+	}
+	if len(jobs) == 0 {
+		delete(d.active, chatID)
+	} else {
+		d.active[chatID] = jobs
+	}
+}
+
+// acquireChat 为 chatID 占用一个位置，由 dispatchJobs 在分配任务前调用
+func (d *Downloader) acquireChat(chatID int64) {
+	d.mu.Lock()
+	d.chatSlots[chatID]++
+	d.mu.Unlock()
+}
+
+// releaseChat 释放 acquireChat 占用的位置
+func (d *Downloader) releaseChat(chatID int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := d.chatSlots[chatID]
+	if n <= 1 {
+		delete(d.chatSlots, chatID)
+	} else {
+		d.chatSlots[chatID] = n - 1
+	}
+	if d.perChat > 0 && n == d.perChat {
+		select {
+		case d.slotFreed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// busyChats 返回已达到 perChat 上限的 chat，dispatchJobs 暂时跳过它们的任务
+func (d *Downloader) busyChats() []int64 {
+	if d.perChat <= 0 {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var busy []int64
+	for chatID, n := range d.chatSlots {
+		if n >= d.perChat {
+			busy = append(busy, chatID)
+		}
+	}
+	return busy
+}
+
+// Cancel 取消 chatID 最近开始的一个下载，all 为 true 时取消该 chat 的全部下载，返回取消的任务数
+func (d *Downloader) Cancel(chatID int64, all bool) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	jobs := d.active[chatID]
+	if len(jobs) == 0 {
+		return 0
+	}
+	if !all {
+		jobs = jobs[len(jobs)-1:]
+	}
+	for _, j := range jobs {
+		logger.Info("cancelling download job", "chat_id", chatID, "job_id", j.jobID)
+		j.cancel()
+	}
+	return len(jobs)
+}
+
+// Stats returns the current counters
+func (d *Downloader) Stats() DownloaderStats {
+	return DownloaderStats{
+		Waiting:   len(d.jobs),
+		Running:   d.running.Load(),
+		Succeeded: d.succeeded.Load(),
+		Failed:    d.failed.Load(),
+	}
+}
+
+// Submit hands a job to the workers, blocking while all workers are busy and the buffer is full.
+// It returns false without queueing the job once Shutdown has been called.
+func (d *Downloader) Submit(job *queue.Job) bool {
+	select {
+	case <-d.stop:
+		return false
+	default:
+	}
+	select {
+	case d.jobs <- job:
+		return true
+	case <-d.stop:
+		return false
+	}
+}
+
+// errShutdown 是宽限期结束后取消下载时使用的 cause
+var errShutdown = errors.New("bot is shutting down")
+
+// Shutdown stops starting new jobs and gives running downloads grace to finish.
+// Downloads still running after that are cancelled, which kills gallery-dl, and
+// are left in progress so the next start resumes them.
+func (d *Downloader) Shutdown(grace time.Duration) {
+	close(d.stop)
+	running := d.running.Load()
+	logger.Info("stopping download workers", "running", running, "grace", grace.String())
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(grace):
+		logger.Warn("shutdown grace period elapsed, killing running downloads", "running", d.running.Load())
+		d.cancel(errShutdown)
+		<-done
+	}
+
+	// 已取出但还没开始的任务放回队列，下次启动按原来的顺序下载
+	for len(d.jobs) > 0 {
+		job := <-d.jobs
+		d.releaseChat(job.ChatID)
+		releaseJob(job)
+	}
+	logger.Info("download workers stopped", "finished", d.finished.Load(), "killed", d.killed.Load())
+}
+
+// retryDelay 计算第 n 次重试（从 0 开始）前的等待时间：base * 2^n，不超过上限，并加入随机抖动
+func retryDelay(n int) time.Duration {
+	delay := cfg.RetryMaxDelay
+	if n < 32 && cfg.RetryBaseDelay<<n > 0 && cfg.RetryBaseDelay<<n < cfg.RetryMaxDelay {
+		delay = cfg.RetryBaseDelay << n
+	}
+	// 避免并发任务同时重试
+	return jitter(delay)
+}
+
+// jitter 在 [d/2, d) 范围内随机取值，d 太小时原样返回
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + rand.N(half)
+}
+
+// jobArchive 返回 job 使用的 gallery-dl archive 文件，为空表示不使用。
+// 订阅有独立的 archive；普通任务按 chat 区分，避免不同用户互相影响，/force 时不使用
+func jobArchive(job *queue.Job) string {
+	switch {
+	case job.Subscription != 0:
+		return subscriptionArchive(job.Subscription)
+	case job.Force || cfg.ArchiveDir == "":
+		return ""
+	default:
+		return filepath.Join(cfg.ArchiveDir, fmt.Sprintf("chat-%d.sqlite3", job.ChatID))
+	}
+}
+
+// jobFlightKey 返回 job 的下载可以与哪些任务共享：key 和 archive 都相同，并且会执行同一个命令。
+// 不同的 archive 会得到不同的结果；gallery-dl 的输出目录和 chat_proxies 中的代理按 chat 区分，
+// 所以只在同一个 chat 的任务之间共享
+func jobFlightKey(job *queue.Job, key, archive string) string {
+	flightKey := key + "\x00" + archive
+	if cfg.DownloadMode == download.ModeGalleryDL {
+		flightKey += fmt.Sprintf("\x00%d", job.ChatID)
+	}
+	return flightKey
+}
+
+// errDownloadTimeout 表示单次下载尝试超过了 DOWNLOAD_TIMEOUT
+var errDownloadTimeout = errors.New("download timed out")
+
+// downloadAttempt 执行一次下载，DOWNLOAD_TIMEOUT 大于 0 时限制这次尝试的时长。
+// 超时会取消 ctx，gallery-dl 进程随之被杀死，返回的错误包装 errDownloadTimeout
+func downloadAttempt(ctx context.Context, bot *Bot, url string) (download.Result, error) {
+	if cfg.DownloadTimeout <= 0 {
+		return bot.Download(ctx, url)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, cfg.DownloadTimeout)
+	defer cancel()
+	result, err := bot.Download(attemptCtx, url)
+	// 只有这次尝试自己的期限到了才算超时，任务被取消时保持原来的错误
+	if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+		return result, fmt.Errorf("%w after %s", errDownloadTimeout, cfg.DownloadTimeout)
+	}
+	return result, err
+}
+
+// failureStderrLines 是最终失败的消息中附带的 gallery-dl stderr 行数，完整内容只写入服务器日志
+const failureStderrLines = 5
+
+// failureLabel 返回回复用户时使用的失败描述，使用前用 lang.T 翻译
+func failureLabel(err error) string {
+	if errors.Is(err, errDownloadTimeout) {
+		return "下载超时"
+	}
+	return "下载失败"
+}
+
+// retryable 判断下载错误是否值得重试。超过大小限制、磁盘空间不足、gallery-dl 未安装、
+// 后端已熔断、后端以 4xx 拒绝请求、要求代理但没有配置，或 gallery-dl 不支持该链接、内容不存在、需要登录时，重试也不会成功
+func retryable(err error) bool {
+	if errors.Is(err, download.ErrSizeLimit) || errors.Is(err, download.ErrLowDiskSpace) ||
+		errors.Is(err, download.ErrNotInstalled) || errors.Is(err, download.ErrBackendUnavailable) ||
+		errors.Is(err, download.ErrUnsupportedURL) || errors.Is(err, download.ErrNotFound) ||
+		errors.Is(err, download.ErrLoginRequired) || errors.Is(err, download.ErrProxyRequired) {
+		return false
+	}
+	var statusErr *download.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Transient()
+	}
+	return true
+}
+
+// runDownloadWithRetry 下载单个任务，失败时按指数退避重试，并通知用户结果，返回最终的下载错误
+func runDownloadWithRetry(ctx context.Context, job *queue.Job) error {
+	logger.Info("starting download job", "url", job.URL, "chat_id", job.ChatID, "job_id", job.ID, "bot", job.Bot)
+	bot := botFor(job.Bot)
+	l := langFor(job.ChatID)
+
+	// 不同选项的下载结果不同，分别去重
+	key := dedupKey(job.URL)
+	if len(job.Options) > 0 {
+		key += "#" + strings.Join(job.Options, ",")
+	}
+	if !job.Force {
+		seen, err := jobQueue.IsDownloaded(key)
+		if err != nil {
+			logger.Warn("failed to check download history", "url", job.URL, "error", err)
+		}
+		if seen {
+			logger.Info("url already downloaded, skipping", "url", job.URL, "chat_id", job.ChatID)
+			notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("已下载过，跳过: \nURL: %s\n如需重新下载，请在消息前加上 /force", job.URL))
+			if err := jobQueue.Complete(job.ID); err != nil {
+				logger.Error("failed to mark job as done", "job_id", job.ID, "error", err)
+			}
+			return nil
+		}
+	}
+
+	// 演练模式在 worker 中生效，队列中的旧任务、重启后恢复的任务和订阅也不会真正下载
+	if cfg.DryRun {
+		logger.Info("dry run, skipping download", "url", job.URL, "chat_id", job.ChatID, "job_id", job.ID)
+		notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("[演练模式] 跳过下载: \nURL: %s\n平台: %s", job.URL, download.DetectPlatform(job.URL)))
+		if err := jobQueue.Complete(job.ID); err != nil {
+			logger.Error("failed to mark job as done", "job_id", job.ID, "error", err)
+		}
+		return nil
+	}
+
+	stopAction := keepChatAction(bot, job.ChatID, "upload_document")
+	defer stopAction()
+
+	// gallery-dl 的输出会汇总到一条定期编辑的进度消息中
+	progress := newProgressUpdater(bot, job.ChatID, job.MessageID, cfg.ProgressInterval)
+	ctx = download.WithIdempotencyKey(ctx, idempotencyKey(job))
+	ctx = download.WithChatID(ctx, job.ChatID)
+	if proxy, ok := cfg.proxyFor(job.ChatID); ok {
+		ctx = download.WithProxy(ctx, proxy)
+	}
+	if len(job.Options) > 0 {
+		ctx = download.WithOptions(ctx, job.Options)
+	}
+	// 记录输出目录，进程中途退出时下次启动可以删除下载了一半的文件
+	setDir := func(dir string) {
+		if err := jobQueue.SetDir(job.ID, dir); err != nil {
+			logger.Warn("failed to record job output directory", "job_id", job.ID, "dir", dir, "error", err)
+		}
+	}
+	archive := jobArchive(job)
+	if archive != "" {
+		ctx = download.WithArchive(ctx, archive)
+	}
+	flightKey := jobFlightKey(job, key, archive)
+
+	historyID, err := jobQueue.StartHistory(job.URL, job.ChatID)
+	if err != nil {
+		logger.Warn("failed to record download history", "url", job.URL, "error", err)
+	}
+
+	var result download.Result
+	var release func(dir string)
+	// 失败的尝试留下的目录，重试时继续使用，已下载的文件和 .part 不会丢失
+	var resumeDir string
+	attempts := 0
+	for attempt := 1; attempt <= cfg.MaxRetries; attempt++ {
+		attempts = attempt
+		downloadsAttempted.Inc()
+		if attempt > 1 {
+			downloadRetries.Inc()
+		}
+
+		attemptCtx := ctx
+		if resumeDir != "" {
+			attemptCtx = download.WithResumeDir(ctx, resumeDir)
+		}
+		start := time.Now()
+		result, release, err = sharedDownload(attemptCtx, bot, flightKey, job.URL, progress.Add, setDir)
+		elapsed := time.Since(start)
+		downloadDuration.Observe(elapsed.Seconds())
+		recordAttempt(elapsed)
+		if !errors.Is(err, context.Canceled) {
+			observeHostAttempt(job.URL, elapsed, err)
+		}
+		if err == nil {
+			break
+		}
+		release("")
+		if result.Dir != "" {
+			resumeDir = result.Dir
+		}
+		if !retryable(err) || ctx.Err() != nil {
+			break
+		}
+
+		if attempt < cfg.MaxRetries {
+			delay := retryDelay(attempt - 1)
+			if preferencesFor(job.ChatID).QuietRetries {
+				// 不通知用户时至少在默认日志级别下留下记录
+				logger.Info("download attempt failed, retrying", "url", job.URL, "chat_id", job.ChatID, "job_id", job.ID, "attempt", attempt, "delay", delay.String(), "error", err)
+			} else {
+				logger.Debug("download attempt failed, retrying", "url", job.URL, "chat_id", job.ChatID, "job_id", job.ID, "attempt", attempt, "delay", delay.String(), "error", err)
+				bot.sendReply(job.ChatID, job.MessageID, l.Sprintf("%s (第 %d 次尝试，%s 后重试...)\nURL: %s\n错误: %v", l.T(failureLabel(err)), attempt, delay.Round(time.Second), job.URL, err))
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				err = ctx.Err()
+				break
+			}
+		}
+	}
+
+	progress.Close()
+
+	// 停止时被终止的任务保持下载中状态，输出目录也保留，下次启动时由 resumeInterrupted 清理后重新开始
+	if err != nil && errors.Is(context.Cause(ctx), errShutdown) {
+		logger.Warn("download killed by shutdown", "url", job.URL, "chat_id", job.ChatID, "job_id", job.ID, "attempt", attempts)
+		finishHistory(historyID, queue.StatusFailed, result, "interrupted by shutdown")
+		return err
+	}
+	// 最终失败或取消时不会再继续，删除下载了一半的文件
+	if err != nil && resumeDir != "" {
+		cleanup(resumeDir)
+	}
+	if err != nil && ctx.Err() != nil {
+		logger.Info("download cancelled", "url", job.URL, "chat_id", job.ChatID, "job_id", job.ID, "attempt", attempts)
+		notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("下载已取消: \nURL: %s", job.URL))
+		if err := jobQueue.Fail(job.ID, "cancelled"); err != nil {
+			logger.Error("failed to mark job as failed", "job_id", job.ID, "error", err)
+		}
+		finishHistory(historyID, queue.StatusFailed, result, "cancelled")
+		return err
+	}
+	if err != nil {
+		downloadsFailed.Inc()
+		logger.Error("download failed", "url", job.URL, "chat_id", job.ChatID, "job_id", job.ID, "attempt", attempts, "error", err)
+		switch {
+		case errors.Is(err, download.ErrSizeLimit):
+			notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("下载被拒绝: 文件总大小超过限制 %.1f MB，已删除下载内容。\nURL: %s", float64(cfg.MaxDownloadBytes)/(1<<20), job.URL))
+		case errors.Is(err, download.ErrLowDiskSpace):
+			notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("下载被拒绝: 服务器磁盘可用空间不足，请稍后再试。\nURL: %s", job.URL))
+		case errors.Is(err, download.ErrBackendUnavailable):
+			notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("下载失败: 后端暂时不可用，请稍后用 /retry 重试。\nURL: %s\n错误: %v", job.URL, err))
+		case errors.Is(err, download.ErrNotInstalled):
+			notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("下载失败: gallery-dl 未安装，请先安装\nURL: %s", job.URL))
+		case errors.Is(err, download.ErrProxyRequired):
+			notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("下载被拒绝: 服务器要求通过代理下载，但没有为当前 chat 配置代理。\nURL: %s", job.URL))
+		case errors.Is(err, download.ErrUnsupportedURL):
+			notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("下载失败: gallery-dl 不支持这个链接，发送 /supported 查看支持的平台。\nURL: %s", job.URL))
+		case errors.Is(err, download.ErrNotFound):
+			notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("下载失败: 内容不存在或已被删除。\nURL: %s", job.URL))
+		case errors.Is(err, download.ErrLoginRequired):
+			hint := l.T("管理员可以设置 COOKIES_FILE 提供登录后的 cookies。")
+			if cfg.CookiesFile != "" {
+				hint = l.T("配置的 cookies 可能已过期，需要管理员更新 COOKIES_FILE。")
+			}
+			notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("下载失败: 该内容需要登录才能访问，%s\nURL: %s", hint, job.URL))
+		default:
+			text := l.Sprintf("%s (已尝试 %d 次): \nURL: %s\n错误: %v", l.T(failureLabel(err)), attempts, job.URL, err)
+			if errors.Is(err, download.ErrProxyUnreachable) {
+				text += l.T("\n原因: 无法连接到代理，请检查代理是否在运行")
+			} else if errors.Is(err, download.ErrNetwork) {
+				text += l.T("\n原因: 无法连接到网站，请检查网络或代理设置")
+			}
+			// "exit status 1" 无法说明原因，附上 gallery-dl 最后输出的几行错误
+			var cmdErr *download.CommandError
+			if errors.As(err, &cmdErr) {
+				if summary := cmdErr.Summary(failureStderrLines); summary != "" {
+					text += l.T("\ngallery-dl 输出:\n") + summary
+				}
+			}
+			notifyResult(bot, job.ChatID, job.MessageID, text)
+		}
+		notifyAdminFailure(bot, job, attempts, err)
+		if err := jobQueue.Fail(job.ID, err.Error()); err != nil {
+			logger.Error("failed to mark job as failed", "job_id", job.ID, "error", err)
+		}
+		finishHistory(historyID, queue.StatusFailed, result, err.Error())
+		return err
+	}
+
+	downloadsSucceeded.Inc()
+	// Files 和 Bytes 来自下载完成后对输出目录的扫描
+	if result.Dir != "" && len(result.Files) > 0 {
+		observeHostFiles(job.URL, len(result.Files), result.Bytes)
+	}
+	logger.Info("download succeeded", "url", job.URL, "chat_id", job.ChatID, "job_id", job.ID, "files", len(result.Files))
+	if job.Subscription != 0 {
+		if err := jobQueue.MarkSubscriptionSeen(job.Subscription, time.Now()); err != nil {
+			logger.Error("failed to update subscription", "subscription", job.Subscription, "error", err)
+		}
+	}
+	// 订阅的定期检查没有新内容时不打扰用户
+	switch {
+	case job.Subscription != 0 && len(result.Files) == 0:
+	case job.Subscription != 0:
+		notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("订阅有新内容: \nURL: %s\n文件数: %d", job.URL, len(result.Files)))
+	case len(result.Files) == 0 && result.Skipped > 0:
+		notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("没有新内容: \nURL: %s\n%d 个文件之前已下载过，如需重新下载，请在消息前加上 /force", job.URL, result.Skipped))
+	default:
+		successText := l.Sprintf("下载成功: \nURL: %s", job.URL)
+		if len(result.Files) > 0 {
+			successText += l.Sprintf("\n文件数: %d", len(result.Files))
+		}
+		notifyResult(bot, job.ChatID, job.MessageID, successText)
+	}
+	if fileStorage != nil && len(result.Files) > 0 {
+		storeFiles(ctx, bot, job, result)
+	}
+	if cfg.UploadFiles && len(result.Files) > 0 {
+		for _, err := range bot.uploadFiles(job.ChatID, result.Files, fileCaptions(l, result.Metadata)) {
+			logger.Warn("failed to upload file", "chat_id", job.ChatID, "job_id", job.ID, "error", err)
+			bot.sendReply(job.ChatID, job.MessageID, uploadFailureText(l, err))
+		}
+	}
+	// 即使部分文件上传失败也要清理，避免占满磁盘；共享的下载由最后一个任务清理
+	release(result.Dir)
+	finishHistory(historyID, queue.StatusDone, result, "")
+	if err := jobQueue.MarkDownloaded(key); err != nil {
+		logger.Error("failed to record downloaded url", "url", job.URL, "error", err)
+	}
+	if err := jobQueue.Complete(job.ID); err != nil {
+		logger.Error("failed to mark job as done", "job_id", job.ID, "error", err)
+	}
+	return nil
+}
+
+// chatActionInterval 小于 Telegram 显示 chat action 的 5 秒，保证状态不会中断
+const chatActionInterval = 4 * time.Second
+
+// keepChatAction 定期发送 action，直到调用返回的 stop 函数
+func keepChatAction(bot *Bot, chatID int64, action string) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(chatActionInterval)
+		defer ticker.Stop()
+		for {
+			bot.sendChatAction(chatID, action)
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return sync.OnceFunc(func() { close(done) })
+}
+
+// idempotencyWindow 内同一 chat 对同一 URL 的请求使用相同的幂等 key
+const idempotencyWindow = 10 * time.Minute
+
+// idempotencyKey 由 chat ID、URL 和任务创建时间所在的时间窗口计算，同一任务的所有重试共享一个 key
+func idempotencyKey(job *queue.Job) string {
+	window := job.CreatedAt.Truncate(idempotencyWindow).Unix()
+	raw := fmt.Sprintf("%d\n%s\n%d", job.ChatID, job.URL, window)
+	// 选择了不同选项的请求不是同一个下载
+	if len(job.Options) > 0 {
+		raw += "\n" + strings.Join(job.Options, ",")
+	}
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// finishHistory 记录下载结果，historyID 为 0 表示开始记录时已失败
+func finishHistory(historyID int64, status queue.Status, result download.Result, errMsg string) {
+	if historyID == 0 {
+		return
+	}
+	if err := jobQueue.FinishHistory(historyID, status, result.Bytes, len(result.Files), errMsg); err != nil {
+		logger.Warn("failed to record download history", "history_id", historyID, "error", err)
+	}
+}
+
+// dispatchJobs 持续从持久化队列中取出任务交给 Downloader，队列为空时等待新任务
+func dispatchJobs(d *Downloader) {
+	for {
+		// 达到 PER_CHAT_CONCURRENCY 的 chat 的任务留在队列中，先下载其它 chat 的任务
+		job, err := jobQueue.Dequeue(d.busyChats()...)
+		if err != nil {
+			logger.Error("failed to dequeue job", "error", err)
+			select {
+			case <-time.After(5 * time.Second):
+			case <-d.stop:
+				return
+			}
+			continue
+		}
+		if job == nil {
+			select {
+			case <-jobQueue.Ready():
+			case <-d.slotFreed:
+			case <-d.stop:
+				return
+			}
+			continue
+		}
+
+		d.acquireChat(job.ChatID)
+		if !d.Submit(job) {
+			d.releaseChat(job.ChatID)
+			releaseJob(job)
+			return
+		}
+	}
+}
+
+// releaseJob 把已取出但没有开始下载的任务放回队列
+func releaseJob(job *queue.Job) {
+	if err := jobQueue.Release(job.ID); err != nil {
+		logger.Error("failed to return job to the queue", "job_id", job.ID, "error", err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deckvig/telegram-bot/download"
+	"github.com/deckvig/telegram-bot/queue"
+)
+
+// withTestConfig 把全局的 cfg 替换为 testConfig()，测试结束时恢复
+func withTestConfig(t *testing.T) *Config {
+	t.Helper()
+	saved := cfg
+	cfg = testConfig()
+	t.Cleanup(func() { cfg = saved })
+	return cfg
+}
+
+// withJobQueue 把全局的 jobQueue 替换为临时目录中的新队列，测试结束时关闭并恢复
+func withJobQueue(t *testing.T) *queue.Queue {
+	t.Helper()
+	q, err := queue.Open(filepath.Join(t.TempDir(), "queue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := jobQueue
+	jobQueue = q
+	t.Cleanup(func() {
+		jobQueue = saved
+		q.Close()
+	})
+	return q
+}
+
+// startJob 把 url 加入 q 并取出，返回 worker 会拿到的下载中任务
+func startJob(t *testing.T, q *queue.Queue, b *Bot, url string) *queue.Job {
+	t.Helper()
+	if _, err := q.EnqueueJob(queue.Job{URL: url, ChatID: 5, MessageID: 10, Bot: b.ID}); err != nil {
+		t.Fatal(err)
+	}
+	job, err := q.Dequeue()
+	if err != nil || job == nil {
+		t.Fatalf("Dequeue() = %v, %v", job, err)
+	}
+	return job
+}
+
+func TestRetryReusesOutputDirectory(t *testing.T) {
+	// 第一次只写下 .part 就因网络错误退出，第二次在同一个目录中接着完成
+	log := filepath.Join(t.TempDir(), "dirs.log")
+	t.Setenv("FAKE_GALLERY_DL_LOG", log)
+	fakeGalleryDL(t, `while [ $# -gt 0 ]; do [ "$1" = "-D" ] && dir="$2"; shift; done
+echo "$dir" >> "$FAKE_GALLERY_DL_LOG"
+if [ ! -e "$dir/1.mp4.part" ]; then
+	echo partial > "$dir/1.mp4.part"
+	echo "[downloader.http][error] ConnectionError: Connection reset by peer" >&2
+	exit 1
+fi
+mv "$dir/1.mp4.part" "$dir/1.mp4"
+echo "$dir/1.mp4"`)
+	c := withTestConfig(t)
+	c.DownloadMode, c.RetryBaseDelay, c.RetryMaxDelay, c.RetentionMinutes = download.ModeGalleryDL, time.Millisecond, time.Millisecond, -1
+	q := withJobQueue(t)
+	f := newFakeTelegram(t)
+	b := newTestBot(t, f, c, &download.GalleryDLDownloader{BaseDir: t.TempDir(), Logger: logger})
+	withBots(t, b)
+
+	job := startJob(t, q, b, "https://www.xiaohongshu.com/explore/abc")
+	if err := runDownloadWithRetry(context.Background(), job); err != nil {
+		t.Fatalf("runDownloadWithRetry() error = %v", err)
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := strings.Fields(string(data))
+	if len(dirs) != 2 || dirs[0] != dirs[1] {
+		t.Fatalf("gallery-dl output directories = %q, want the same directory twice", dirs)
+	}
+	if _, err := os.Stat(filepath.Join(dirs[0], "1.mp4")); err != nil {
+		t.Errorf("resumed download is missing: %v", err)
+	}
+}
+
+// failingDownloader 每次都在 dir 中留下部分文件后失败，attempted 在每次尝试后收到一个值
+type failingDownloader struct {
+	dir       string
+	attempted chan struct{}
+}
+
+func (d *failingDownloader) Download(ctx context.Context, url string) (download.Result, error) {
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return download.Result{}, err
+	}
+	if err := os.WriteFile(filepath.Join(d.dir, "1.mp4.part"), []byte("partial"), 0644); err != nil {
+		return download.Result{}, err
+	}
+	select {
+	case d.attempted <- struct{}{}:
+	default:
+	}
+	return download.Result{Dir: d.dir}, errors.New("connection reset by peer")
+}
+
+func TestInterruptedDownloadDirectory(t *testing.T) {
+	tests := []struct {
+		name     string
+		shutdown bool
+		keepDir  bool
+		status   queue.Status
+	}{
+		// 停止时任务保持下载中，目录留给下次启动时的 resumeInterrupted
+		{"shutdown keeps the directory", true, true, queue.StatusInProgress},
+		{"final failure removes the directory", false, false, queue.StatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := withTestConfig(t)
+			c.MaxRetries, c.RetryBaseDelay, c.RetryMaxDelay = 2, time.Millisecond, time.Millisecond
+			if tt.shutdown {
+				// 在第一次失败后的等待中停止
+				c.RetryBaseDelay, c.RetryMaxDelay = time.Minute, time.Minute
+			}
+			q := withJobQueue(t)
+			f := newFakeTelegram(t)
+			d := &failingDownloader{dir: filepath.Join(t.TempDir(), "5", "job"), attempted: make(chan struct{}, 1)}
+			b := newTestBot(t, f, c, d)
+			withBots(t, b)
+			job := startJob(t, q, b, "https://www.xiaohongshu.com/explore/abc")
+
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+			done := make(chan error, 1)
+			go func() { done <- runDownloadWithRetry(ctx, job) }()
+			if tt.shutdown {
+				<-d.attempted
+				cancel(errShutdown)
+			}
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Fatal("runDownloadWithRetry() succeeded, want an error")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("runDownloadWithRetry() did not return")
+			}
+
+			if _, err := os.Stat(d.dir); (err == nil) != tt.keepDir {
+				t.Errorf("output directory exists = %v, want %v", err == nil, tt.keepDir)
+			}
+			interrupted, err := q.Interrupted()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inProgress := len(interrupted) == 1; inProgress != (tt.status == queue.StatusInProgress) {
+				t.Errorf("job left in progress = %v, want status %s", inProgress, tt.status)
+			}
+		})
+	}
+}
+
+// gateDownloader 把开始的 URL 发到 started，直到 finish 中对应的通道关闭才完成
+type gateDownloader struct {
+	started chan string
+	finish  map[string]chan struct{}
+}
+
+func (d *gateDownloader) Download(ctx context.Context, url string) (download.Result, error) {
+	d.started <- url
+	select {
+	case <-d.finish[url]:
+		return download.Result{}, nil
+	case <-ctx.Done():
+		return download.Result{}, ctx.Err()
+	}
+}
+
+func TestPerChatConcurrency(t *testing.T) {
+	// chat 5 先加入三个任务，chat 6 后加入一个；两个 worker 空闲时 chat 5 也只能占用一个
+	urls := []string{
+		"https://www.xiaohongshu.com/explore/5a",
+		"https://www.xiaohongshu.com/explore/5b",
+		"https://www.xiaohongshu.com/explore/5c",
+		"https://www.xiaohongshu.com/explore/6a",
+	}
+	d := &gateDownloader{started: make(chan string, len(urls)), finish: make(map[string]chan struct{})}
+	for _, url := range urls {
+		d.finish[url] = make(chan struct{})
+	}
+	_, b := startTestBot(t, d, func(c *Config) { c.PerChatConcurrency = 1 })
+	for i, url := range urls {
+		chatID := int64(5)
+		if i == 3 {
+			chatID = 6
+		}
+		if _, err := jobQueue.EnqueueJob(queue.Job{URL: url, ChatID: chatID, MessageID: int64(10 + i), Bot: b.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	next := func() string {
+		t.Helper()
+		select {
+		case url := <-d.started:
+			return url
+		case <-time.After(5 * time.Second):
+			t.Fatal("no download started")
+			return ""
+		}
+	}
+	noneStarted := func() {
+		t.Helper()
+		select {
+		case url := <-d.started:
+			t.Fatalf("%s started while its chat is at the limit", url)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// chat 6 的任务不用等 chat 5 的任务全部完成
+	first := []string{next(), next()}
+	slices.Sort(first)
+	if want := []string{urls[0], urls[3]}; !slices.Equal(first, want) {
+		t.Fatalf("first downloads = %q, want %q", first, want)
+	}
+	noneStarted()
+
+	// chat 6 结束后空出的 worker 也不会分给已达到上限的 chat 5
+	close(d.finish[urls[3]])
+	noneStarted()
+
+	// chat 5 的任务每结束一个才开始下一个，并按加入的顺序
+	for i := 1; i < 3; i++ {
+		close(d.finish[urls[i-1]])
+		if got := next(); got != urls[i] {
+			t.Fatalf("download %d = %s, want %s", i, got, urls[i])
+		}
+		noneStarted()
+	}
+	close(d.finish[urls[2]])
+}
@@ -0,0 +1,196 @@
+package main
+
+import "fmt"
+
+// languageChoices 是支持的回复语言，也是 /settings 中"语言"按钮依次切换的顺序
+var languageChoices = []string{"zh", "en"}
+
+// languageNames 是设置菜单中显示的语言名称
+var languageNames = map[string]string{
+	"zh": "中文",
+	"en": "English",
+}
+
+// lang 是回复使用的语言代码。回复在代码中直接用中文书写，其它语言按原文在 translations 中查找，
+// 没有翻译的句子保持中文
+type lang string
+
+// langFor 返回 chatID 回复使用的语言：/settings 中选择的语言，没有选择时使用 LANGUAGE
+func langFor(chatID int64) lang {
+	return lang(preferencesFor(chatID).Language)
+}
+
+// T 返回 text 的翻译
+func (l lang) T(text string) string {
+	if translated, ok := translations[l][text]; ok {
+		return translated
+	}
+	return text
+}
+
+// Sprintf 翻译 format 后再格式化，译文中的格式动词与原文顺序相同
+func (l lang) Sprintf(format string, args ...any) string {
+	return fmt.Sprintf(l.T(format), args...)
+}
+
+// translations 按语言保存回复的译文，键是代码中的中文原文
+var translations = map[lang]map[string]string{
+	"en": {
+		// 消息和命令
+		"抱歉，此机器人未对当前聊天开放。":                                                       "Sorry, this bot is not available in this chat.",
+		"消息中未找到任何可识别的 URL 地址，请确保链接以 http:// 或 https:// 开头。":                      "No URL found in the message. Make sure links start with http:// or https://.",
+		"以下 %d 个链接的域名不允许下载，已跳过：\n%s":                                             "Skipped %d links whose domains are not allowed:\n%s",
+		"发现 %d 个 URL，已加入下载队列...":                                                 "Found %d URLs, added to the download queue...",
+		"请求过于频繁，已跳过剩余的 %d 个 URL，请在 %d 秒后再试。":                                     "Too many requests, skipped the remaining %d URLs. Try again in %d seconds.",
+		"加入队列失败: \nURL: %s\n错误: %v":                                              "Failed to queue: \nURL: %s\nError: %v",
+		"以下 %d 个链接不属于支持的平台（小红书、抖音、B站），已跳过，发送 /supported 查看支持的平台：\n%s":            "Skipped %d links that are not from a supported platform (Xiaohongshu, Douyin, Bilibili). Send /supported to see the supported platforms:\n%s",
+		"[演练模式] 发现 %d 个 URL，将加入队列但不会实际下载：\n":                                     "[Dry run] Found %d URLs, they will be queued but not downloaded:\n",
+		"未知命令 /%s，发送 /help 查看使用说明。":                                              "Unknown command /%s. Send /help for usage.",
+		"直接发送包含链接的消息（例如小红书分享文本），机器人会提取其中所有以 http:// 或 https:// 开头的 URL 并下载。\n\n": "Send a message with links (for example a Xiaohongshu share text) and the bot downloads every URL in it that starts with http:// or https://.\n\n",
+		"链接较多时可以发送每行一个链接的 .txt 文件，选项前缀写在文件的说明中。\n\n":                             "For many links, send a .txt file with one link per line and put the option prefix in the file's caption.\n\n",
+		"在消息开头加上选项前缀可以调整下载方式，例如 audio: <链接>。支持的选项：%s\n\n":                        "Start the message with an option prefix to change how it is downloaded, for example audio: <link>. Supported options: %s\n\n",
+		"可用命令：\n":      "Commands:\n",
+		"%s:（%s）":      "%s: (%s)",
+		"、":            ", ",
+		"只下载音频":        "audio only",
+		"下载最高画质":       "highest quality",
+		"获取队列状态失败: %v": "Failed to get the queue status: %v",
+		"机器人状态：\n排队中: %d\n下载中: %d\n成功: %d\n失败: %d": "Bot status:\nQueued: %d\nDownloading: %d\nSucceeded: %d\nFailed: %d",
+		"\n后端: ": "\nBackend: ",
+		"不可用（连续失败 %d 次），%s 后重试": "unavailable (%d failures in a row), retrying in %s",
+		"正在测试是否恢复":              "testing whether it has recovered",
+		"正常（最近连续失败 %d 次）":       "OK (%d recent failures in a row)",
+		"正常":                    "OK",
+		"获取队列失败: %v":            "Failed to get the queue: %v",
+		"队列中没有你的下载任务。":          "You have no downloads in the queue.",
+		"你有 %d 个未完成的任务：\n":      "You have %d unfinished jobs:\n",
+		"下载中":                   "downloading",
+		"排队第 %d 位":              "#%d in queue",
+		"\n……还有 %d 个任务未显示":      "\n...and %d more jobs",
+		"连接 Telegram 失败: %v":    "Failed to reach Telegram: %v",
+		"用法：/cancel [all]":      "Usage: /cancel [all]",
+		"当前没有进行中的下载。":           "No downloads in progress.",
+		"已取消 %d 个下载任务。":         "Cancelled %d downloads.",
+		"查找失败的下载失败: %v":         "Failed to look up failed downloads: %v",
+		"没有需要重试的失败下载。":          "No failed downloads to retry.",
+		"已重新加入下载队列: \nURL: %s":  "Added to the download queue again: \nURL: %s",
+		"用法：/history [条数]":      "Usage: /history [count]",
+		"获取下载记录失败: %v":          "Failed to get the download history: %v",
+		"还没有下载记录。":              "No downloads yet.",
+		"最近 %d 条下载记录：\n":        "Last %d downloads:\n",
+		"进行中":                   "in progress",
+		"成功":                    "done",
+		"失败":                    "failed",
+		"\n用时 %s":               "\nTook %s",
+		"，%d 个文件，%.1f MB":       ", %d files, %.1f MB",
+		"\n错误: %s":              "\nError: %s",
+		"支持的平台：\n":              "Supported platforms:\n",
+		"\n%s（%s）":              "\n%s (%s)",
+		"小红书":                   "Xiaohongshu",
+		"抖音":                    "Douyin",
+		"B站":                    "Bilibili",
+		"\n  本机 gallery-dl 没有对应的 extractor，可能无法下载":     "\n  the local gallery-dl has no extractor for it, downloads may fail",
+		"\n\ngallery-dl 未安装，暂时无法下载。":                   "\n\ngallery-dl is not installed, downloads are unavailable.",
+		"\n\n无法获取 gallery-dl 支持的站点: %v":                "\n\nFailed to get the sites gallery-dl supports: %v",
+		"\n\n本机 gallery-dl 共支持 %d 个站点，但机器人只处理以上平台的链接。": "\n\nThe local gallery-dl supports %d sites, but the bot only handles links from the platforms above.",
+		"该命令仅限管理员使用。":                                  "This command is only available to the admin.",
+
+		// 命令菜单
+		"开始使用":   "Get started",
+		"查看使用说明": "Show usage",
+		"忽略已下载记录，强制重新下载：/force <链接>":               "Download again even if already downloaded: /force <link>",
+		"查看队列和下载状态":                                "Show the queue and download status",
+		"查看自己排队中和下载中的链接":                           "Show your queued and running links",
+		"取消最近开始的下载，/cancel all 取消全部":               "Cancel the latest download, /cancel all cancels every download",
+		"重新下载最近一次失败的链接":                            "Download the last failed link again",
+		"查看最近的下载记录：/history [条数]":                  "Show recent downloads: /history [count]",
+		"测试机器人到 Telegram 的延迟":                      "Measure the latency from the bot to Telegram",
+		"修改当前 chat 的设置（重试通知、下载前确认、默认画质、语言）":        "Change the settings of this chat (retry notices, confirmation, default quality, language)",
+		"查看运行时长和全局统计（仅管理员）":                        "Show uptime and global statistics (admin only)",
+		"检查 gallery-dl、代理、后端和磁盘空间（仅管理员）":           "Check gallery-dl, the proxy, the backend and disk space (admin only)",
+		"查看支持下载的平台":                                "Show the supported platforms",
+		"订阅作者主页，定期下载新作品：/subscribe <链接>，不带链接时列出订阅": "Subscribe to a profile and download new posts periodically: /subscribe <link>, without a link lists subscriptions",
+		"取消订阅：/unsubscribe <链接>":                   "Unsubscribe: /unsubscribe <link>",
+
+		// 确认、设置和 .txt 文件
+		"下载": "Download",
+		"忽略": "Ignore",
+		"发现 %d 个 URL，是否下载？\n%s": "Found %d URLs. Download them?\n%s",
+		"该请求已过期，请重新发送链接。":       "This request has expired, please send the links again.",
+		"该请求已过期。":               "This request has expired.",
+		"只有发送链接的用户可以操作。":        "Only the user who sent the links can do this.",
+		"已忽略":             "Ignored",
+		"已忽略 %d 个 URL。":   "Ignored %d URLs.",
+		"开始下载":            "Downloading",
+		"已确认下载 %d 个 URL。": "Confirmed %d URLs for download.",
+		"默认":              "default",
+		"开":               "on",
+		"关":               "off",
+		"重试通知: ":          "Retry notices: ",
+		"下载前确认: ":         "Confirm before download: ",
+		"默认画质: ":          "Default quality: ",
+		"恢复默认":            "Reset to defaults",
+		"当前 chat 的设置，点击按钮修改：": "Settings of this chat, tap a button to change it:",
+		"读取设置失败，请稍后重试。":       "Failed to read the settings, please try again later.",
+		"保存设置失败，请稍后重试。":       "Failed to save the settings, please try again later.",
+		"已保存": "Saved",
+		"只支持每行一个链接的 .txt 文件。":         "Only .txt files with one link per line are supported.",
+		"文件太大（%.1f MB），链接列表最大 %d KB。": "The file is too large (%.1f MB), link lists are limited to %d KB.",
+		"文件太大，链接列表最大 %d KB。":          "The file is too large, link lists are limited to %d KB.",
+		"读取文件失败: %v":                  "Failed to read the file: %v",
+
+		// 下载结果
+		"下载超时":          "Download timed out",
+		"下载失败":          "Download failed",
+		"下载中... 已用时 %s": "Downloading... %s elapsed",
+		"下载中... 已处理 %d 个文件，已用时 %s\n%s":                             "Downloading... %d files processed, %s elapsed\n%s",
+		"已下载过，跳过: \nURL: %s\n如需重新下载，请在消息前加上 /force":                "Already downloaded, skipped: \nURL: %s\nTo download it again, start the message with /force",
+		"[演练模式] 跳过下载: \nURL: %s\n平台: %s":                           "[Dry run] Skipped download: \nURL: %s\nPlatform: %s",
+		"%s (第 %d 次尝试，%s 后重试...)\nURL: %s\n错误: %v":                 "%s (attempt %d, retrying in %s...)\nURL: %s\nError: %v",
+		"下载已取消: \nURL: %s":                                         "Download cancelled: \nURL: %s",
+		"下载被拒绝: 文件总大小超过限制 %.1f MB，已删除下载内容。\nURL: %s":               "Download refused: the files exceed the %.1f MB limit and were deleted.\nURL: %s",
+		"下载被拒绝: 服务器磁盘可用空间不足，请稍后再试。\nURL: %s":                       "Download refused: the server is low on disk space, please try again later.\nURL: %s",
+		"下载失败: 后端暂时不可用，请稍后用 /retry 重试。\nURL: %s\n错误: %v":           "Download failed: the backend is unavailable, use /retry later.\nURL: %s\nError: %v",
+		"下载失败: gallery-dl 未安装，请先安装\nURL: %s":                       "Download failed: gallery-dl is not installed\nURL: %s",
+		"下载被拒绝: 服务器要求通过代理下载，但没有为当前 chat 配置代理。\nURL: %s":            "Download refused: the server requires a proxy, but none is configured for this chat.\nURL: %s",
+		"下载失败: gallery-dl 不支持这个链接，发送 /supported 查看支持的平台。\nURL: %s": "Download failed: gallery-dl does not support this link, send /supported to see the supported platforms.\nURL: %s",
+		"下载失败: 内容不存在或已被删除。\nURL: %s":                               "Download failed: the content does not exist or was deleted.\nURL: %s",
+		"管理员可以设置 COOKIES_FILE 提供登录后的 cookies。":                     "the admin can set COOKIES_FILE to provide logged-in cookies.",
+		"配置的 cookies 可能已过期，需要管理员更新 COOKIES_FILE。":                  "the configured cookies may have expired, the admin needs to update COOKIES_FILE.",
+		"下载失败: 该内容需要登录才能访问，%s\nURL: %s":                            "Download failed: the content requires login, %s\nURL: %s",
+		"%s (已尝试 %d 次): \nURL: %s\n错误: %v":                         "%s (%d attempts): \nURL: %s\nError: %v",
+		"\n原因: 无法连接到代理，请检查代理是否在运行":                                 "\nReason: cannot connect to the proxy, check that it is running",
+		"\n原因: 无法连接到网站，请检查网络或代理设置":                                 "\nReason: cannot connect to the site, check the network or proxy settings",
+		"\ngallery-dl 输出:\n":         "\ngallery-dl output:\n",
+		"订阅有新内容: \nURL: %s\n文件数: %d": "New posts in a subscription: \nURL: %s\nFiles: %d",
+		"没有新内容: \nURL: %s\n%d 个文件之前已下载过，如需重新下载，请在消息前加上 /force": "Nothing new: \nURL: %s\n%d files were downloaded before, to download them again start the message with /force",
+		"下载成功: \nURL: %s": "Downloaded: \nURL: %s",
+		"\n文件数: %d":       "\nFiles: %d",
+		"文件发送失败: %v":      "Failed to send a file: %v",
+		"文件发送失败: 连接 Telegram 超时或中断，请稍后重试": "Failed to send a file: the connection to Telegram timed out or was interrupted, please try again later",
+		"作者: ":                     "Author: ",
+		"文件上传到存储失败: %v":            "Failed to upload a file to storage: %v",
+		"已上传 %d 个文件：\n%s":          "Uploaded %d files:\n%s",
+		"%s\n原因: %v":               "%s\nReason: %v",
+		"全部完成：%d 成功, %d 失败, 耗时 %s": "All done: %d succeeded, %d failed, took %s",
+		"\n\n失败的链接：\n":             "\n\nFailed links:\n",
+		"机器人已重启，中断的下载将重新开始: \nURL: %s": "The bot restarted, the interrupted download starts again: \nURL: %s",
+
+		// 订阅
+		"订阅功能仅支持 gallery-dl 下载模式。":    "Subscriptions are only available in gallery-dl mode.",
+		"用法：/subscribe <作者主页链接>":      "Usage: /subscribe <profile link>",
+		"用法：/unsubscribe <作者主页链接>":    "Usage: /unsubscribe <profile link>",
+		"该链接不属于支持的平台（小红书、抖音、B站）：\n%s": "This link is not from a supported platform (Xiaohongshu, Douyin, Bilibili):\n%s",
+		"订阅失败: %v":   "Failed to subscribe: %v",
+		"已经订阅过：\n%s": "Already subscribed:\n%s",
+		"已订阅，每 %s 检查一次新作品，现在开始第一次下载：\n%s": "Subscribed, checking for new posts every %s. Starting the first download now:\n%s",
+		"取消订阅失败: %v":                   "Failed to unsubscribe: %v",
+		"没有订阅过：\n%s":                   "Not subscribed:\n%s",
+		"已取消订阅：\n%s":                   "Unsubscribed:\n%s",
+		"获取订阅列表失败: %v":                 "Failed to get the subscriptions: %v",
+		"还没有订阅。用法：/subscribe <作者主页链接>": "No subscriptions yet. Usage: /subscribe <profile link>",
+		"当前 %d 个订阅：\n":                 "%d subscriptions:\n",
+		"尚未检查成功":                       "not checked successfully yet",
+		"上次检查 ":                        "last checked ",
+	},
+}
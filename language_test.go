@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// formatVerb 匹配 fmt 的格式动词
+var formatVerb = regexp.MustCompile(`%[-+# 0]*[0-9.]*[a-zA-Z%]`)
+
+func TestTranslationsKeepFormatVerbs(t *testing.T) {
+	for code, phrases := range translations {
+		for text, translated := range phrases {
+			if got, want := formatVerb.FindAllString(translated, -1), formatVerb.FindAllString(text, -1); !slices.Equal(got, want) {
+				t.Errorf("%s translation of %q has verbs %q, want %q", code, text, got, want)
+			}
+		}
+	}
+	for _, code := range languageChoices {
+		if _, ok := languageNames[code]; !ok {
+			t.Errorf("language %q has no name", code)
+		}
+	}
+}
+
+func TestLangTranslates(t *testing.T) {
+	tests := []struct {
+		lang lang
+		want string
+	}{
+		{"zh", "发现 3 个 URL，已加入下载队列..."},
+		{"en", "Found 3 URLs, added to the download queue..."},
+		// 不认识的语言保持中文
+		{"fr", "发现 3 个 URL，已加入下载队列..."},
+	}
+	for _, tt := range tests {
+		if got := tt.lang.Sprintf("发现 %d 个 URL，已加入下载队列...", 3); got != tt.want {
+			t.Errorf("lang(%q).Sprintf() = %q, want %q", tt.lang, got, tt.want)
+		}
+	}
+	if got := lang("en").T("还没有翻译的句子"); got != "还没有翻译的句子" {
+		t.Errorf("T() of an untranslated phrase = %q, want the original", got)
+	}
+}
+
+// clickSettings 模拟在 chat 5 的设置菜单上点击 field 对应的按钮
+func clickSettings(b *Bot, updateID int64, field string) {
+	handleUpdate(b, Update{UpdateID: updateID, CallbackQuery: &CallbackQuery{
+		ID:      fmt.Sprint(updateID),
+		From:    User{ID: 5},
+		Message: testMessage(5, 20, ""),
+		Data:    "set:" + field,
+	}})
+}
+
+func TestSettingsLanguage(t *testing.T) {
+	f, b := startTestBot(t, &fakeDownloader{}, nil)
+
+	clickSettings(b, 1, "language")
+	if s, err := jobQueue.ChatSettings(5); err != nil || s.Language != "en" {
+		t.Fatalf("ChatSettings() = %+v, %v, want language en", s, err)
+	}
+	// 切换语言后菜单的说明文字和按钮都换成新的语言
+	edits := f.callsTo("editMessageText")
+	if len(edits) != 1 || edits[0].Payload["text"] != "Settings of this chat, tap a button to change it:" {
+		t.Fatalf("editMessageText calls = %+v, want the menu in English", edits)
+	}
+	if markup := fmt.Sprint(edits[0].Payload["reply_markup"]); !strings.Contains(markup, "Language: English") || !strings.Contains(markup, "Retry notices: on") {
+		t.Errorf("menu keyboard = %s, want English buttons", markup)
+	}
+	if answers := f.callsTo("answerCallbackQuery"); len(answers) != 1 || answers[0].Payload["text"] != "Saved" {
+		t.Errorf("answerCallbackQuery calls = %+v, want Saved", answers)
+	}
+
+	// 之后的回复使用选择的语言，其它 chat 不受影响
+	handleUpdate(b, Update{UpdateID: 2, Message: testMessage(5, 10, "你好")})
+	handleUpdate(b, Update{UpdateID: 3, Message: testMessage(6, 11, "你好")})
+	f.waitFor(t, 5*time.Second, func() bool { return len(f.sentTexts()) == 2 })
+	if got := f.sentTexts(); !strings.HasPrefix(got[0], "No URL found") || !strings.HasPrefix(got[1], "消息中未找到") {
+		t.Errorf("replies = %q, want English in chat 5 and Chinese in chat 6", got)
+	}
+
+	// 再点击一次切换回中文
+	clickSettings(b, 4, "language")
+	if s, _ := jobQueue.ChatSettings(5); s.Language != "zh" {
+		t.Errorf("language after the second click = %q, want zh", s.Language)
+	}
+	if edits := f.callsTo("editMessageText"); edits[len(edits)-1].Payload["text"] != "当前 chat 的设置，点击按钮修改：" {
+		t.Errorf("menu text = %v, want it back in Chinese", edits[len(edits)-1].Payload["text"])
+	}
+}
+
+func TestDefaultLanguage(t *testing.T) {
+	// LANGUAGE 是没有在 /settings 中选择语言的 chat 的默认值，恢复默认后重新使用它
+	f, b := startTestBot(t, &fakeDownloader{}, func(c *Config) { c.Language = "en" })
+	handleUpdate(b, Update{UpdateID: 1, Message: testMessage(5, 10, "/cancel")})
+	clickSettings(b, 2, "language")
+	handleUpdate(b, Update{UpdateID: 3, Message: testMessage(5, 10, "/cancel")})
+	clickSettings(b, 4, "reset")
+	handleUpdate(b, Update{UpdateID: 5, Message: testMessage(5, 10, "/cancel")})
+
+	want := []string{"No downloads in progress.", "当前没有进行中的下载。", "No downloads in progress."}
+	f.waitFor(t, 5*time.Second, func() bool { return len(f.sentTexts()) == len(want) })
+	if got := f.sentTexts(); !slices.Equal(got, want) {
+		t.Errorf("replies = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// errLocked 表示另一个进程持有 LOCK_FILE
+var errLocked = errors.New("lock file is held by another process")
+
+// lockFile 在没有 flock 的平台上不做处理
+type lockFile struct{}
+
+// acquireLock 在没有 flock 的平台上总是成功
+func acquireLock(path string) (*lockFile, error) {
+	return &lockFile{}, nil
+}
+
+func (l *lockFile) release() {}
@@ -0,0 +1,51 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// errLocked 表示另一个进程持有 LOCK_FILE
+var errLocked = errors.New("lock file is held by another process")
+
+// lockFile 是持有的 LOCK_FILE，进程退出时由系统自动释放
+type lockFile struct {
+	f *os.File
+}
+
+// acquireLock 以不阻塞的 flock 锁住 path 并写入当前 PID。
+// 文件已被锁住时返回包装 errLocked 的错误，其中带有持有者写入的 PID
+func acquireLock(path string) (*lockFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			data, _ := os.ReadFile(path)
+			if pid := string(bytes.TrimSpace(data)); pid != "" {
+				return nil, fmt.Errorf("%w (pid %s)", errLocked, pid)
+			}
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	// 锁住之后再写 PID，避免覆盖正在运行的实例写入的内容
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &lockFile{f: f}, nil
+}
+
+// release 释放锁。文件本身保留，删除它会让同时等待的进程锁住不同的文件
+func (l *lockFile) release() {
+	syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	l.f.Close()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logger 是全局唯一的结构化日志实例，输出 JSON 到 stderr
+var logger = newLogger(os.Getenv("LOG_LEVEL"))
+
+// newLogger creates a JSON logger with the given level (debug/info/warn/error, default info)
+func newLogger(level string) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: parseLogLevel(level)}))
+}
+
+// parseLogLevel 解析 LOG_LEVEL，无法识别时使用 info
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// fatal logs at error level and exits, replacing log.Fatal
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
@@ -2,81 +2,227 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"github.com/deckvig/telegram-bot/download"
+	"github.com/deckvig/telegram-bot/queue"
+	"github.com/deckvig/telegram-bot/storage"
+	"github.com/deckvig/telegram-bot/urls"
 )
 
 var (
-	telegramBotToken = os.Getenv("TELEGRAM_BOT_TOKEN")
-	backendURL       = os.Getenv("BACKEND_URL")
-	lastUpdateIDFile = "last_update_id.txt" // 用于存储最后一个处理的 update_id
-	// 匹配 http 或 https 开头，后面跟着非空格或非中文逗号的字符
-	urlRegex = regexp.MustCompile(`https?://[^\s，]+`)
+	bots []*Bot  // 每个 bot token 一个，第一个用于没有来源的任务
+	cfg  *Config // 启动时由 Load 加载
+
+	// telegramClient 用于所有 Telegram 请求，测试时可以替换
+	telegramClient = newTelegramClient()
+
+	rateLimiter  *RateLimiter
+	urlExtractor urls.Extractor // 按 URL_TRIM_CHARS 配置的 URL 提取器
+	jobQueue     *queue.Queue
+	downloader   *Downloader
 )
 
 // Update represents a Telegram update structure
 type Update struct {
-	UpdateID int64    `json:"update_id"`
-	Message  *Message `json:"message,omitempty"`
+	UpdateID      int64          `json:"update_id"`
+	Message       *Message       `json:"message,omitempty"`
+	CallbackQuery *CallbackQuery `json:"callback_query,omitempty"` // 用户点击 inline keyboard 按钮
 }
 
 // Message represents a Telegram message structure
 type Message struct {
-	Chat struct {
+	MessageID int64 `json:"message_id"`
+	From      *User `json:"from,omitempty"` // 频道消息中为空
+	Chat      struct {
 		ID int64 `json:"id"`
 	} `json:"chat"`
-	Text string `json:"text"`
+	Date     int64           `json:"date"` // 发送时间，Unix 秒
+	Text     string          `json:"text"`
+	Entities []MessageEntity `json:"entities,omitempty"`
+	Caption  string          `json:"caption,omitempty"`  // 文件等附件的说明文字
+	Document *Document       `json:"document,omitempty"` // 发送的文件，例如每行一个链接的 .txt
 }
-type Result struct {
-	Ok          bool     `json:"ok"`
-	ErrorCode   int      `json:"error_code"`
-	Description string   `json:"description"`
-	Result      []Update `json:"result"`
+
+// User represents a Telegram user or bot
+type User struct {
+	ID int64 `json:"id"`
 }
 
-// getUpdates fetches new updates from Telegram
-func getUpdates(lastUpdateID int64) ([]Update, error) {
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates?offset=%d&timeout=30", telegramBotToken, lastUpdateID+1)
+// MessageEntity represents a special entity in a message text, such as a hyperlink
+type MessageEntity struct {
+	Type   string `json:"type"`
+	Offset int    `json:"offset"` // UTF-16 code units
+	Length int    `json:"length"`
+	URL    string `json:"url,omitempty"` // 仅 text_link 类型
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
+// APIError is returned when the Bot API responds with ok=false
+type APIError struct {
+	Method      string
+	StatusCode  int // HTTP 状态码
+	ErrorCode   int // Telegram 返回的 error_code
+	Description string
+	RetryAfter  time.Duration // 429 时 Telegram 要求等待的时间
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s failed: HTTP %d, error_code %d: %s", e.Method, e.StatusCode, e.ErrorCode, e.Description)
+}
+
+// IsTooManyRequests reports whether Telegram rate limited the request; RetryAfter says how long to wait
+func (e *APIError) IsTooManyRequests() bool {
+	return e.ErrorCode == http.StatusTooManyRequests
+}
+
+// IsBadRequest reports whether Telegram rejected the request itself, for example a photo with unsupported dimensions
+func (e *APIError) IsBadRequest() bool {
+	return e.ErrorCode == http.StatusBadRequest
+}
+
+// IsConflict reports whether another getUpdates poller or a webhook holds the bot token
+func (e *APIError) IsConflict() bool {
+	return e.ErrorCode == http.StatusConflict
+}
+
+// IsUnauthorized reports whether Telegram rejected the bot token
+func (e *APIError) IsUnauthorized() bool {
+	return e.ErrorCode == http.StatusUnauthorized
+}
+
+// TransportError 表示请求没有得到 HTTP 响应，例如 DNS、连接或超时错误
+type TransportError struct {
+	Method string
+	Err    error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("%s request failed: %v", e.Method, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// newTransportError 包装 http.Client 返回的错误。*url.Error 的文本带有请求 URL，
+// 其中包含 token，所以只保留它包装的错误
+func newTransportError(method string, err error) *TransportError {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
 	}
-	defer resp.Body.Close()
+	return &TransportError{Method: method, Err: err}
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+// ResponseError 表示收到了 HTTP 响应，但内容不是 Telegram 的 JSON，
+// 通常是代理或网关返回的 HTML 错误页
+type ResponseError struct {
+	Method      string
+	StatusCode  int
+	ContentType string
+	Body        string // 响应开头的部分内容
+	Err         error  // 解析 JSON 的错误，Content-Type 不是 JSON 时为 nil
+}
+
+func (e *ResponseError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s failed: HTTP %d, unexpected %s response %q", e.Method, e.StatusCode, e.ContentType, e.Body)
+	}
+	return fmt.Sprintf("%s failed: HTTP %d, malformed response %q: %v", e.Method, e.StatusCode, e.Body, e.Err)
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// newTelegramClient returns an HTTP client whose timeout covers the 30s long poll plus some slack
+func newTelegramClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.ResponseHeaderTimeout = 45 * time.Second
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.MaxIdleConnsPerHost = 10
+
+	return &http.Client{
+		Timeout:   60 * time.Second,
+		Transport: transport,
 	}
+}
 
-	var result Result
+// apiURL builds the Bot API endpoint for method from the configured base URL
+func (b *Bot) apiURL(method string) string {
+	return fmt.Sprintf("%s/bot%s/%s", strings.TrimSuffix(b.cfg.TelegramAPIBase, "/"), b.token, method)
+}
+
+// pollRequestTimeout 是整个 getUpdates 请求的超时，略长于 POLL_TIMEOUT，连接卡住时不会一直阻塞
+func (b *Bot) pollRequestTimeout() time.Duration {
+	return b.cfg.PollTimeout + 5*time.Second
+}
+
+// GetUpdates fetches new updates from Telegram, returning early when ctx is cancelled
+// or the request takes longer than pollRequestTimeout
+func (b *Bot) GetUpdates(ctx context.Context, lastUpdateID int64) ([]Update, error) {
+	ctx, cancel := context.WithTimeout(ctx, b.pollRequestTimeout())
+	defer cancel()
+
+	query := url.Values{}
+	query.Set("offset", strconv.FormatInt(lastUpdateID+1, 10))
+	query.Set("timeout", strconv.Itoa(int(b.cfg.PollTimeout.Seconds())))
+	query.Set("limit", strconv.Itoa(b.cfg.MaxBatch))
+	// Telegram 会记住上一次的 allowed_updates，为空时不发送，沿用之前的设置
+	if types := b.cfg.allowedUpdates(); len(types) > 0 {
+		encoded, err := json.Marshal(types)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("allowed_updates", string(encoded))
+	}
 
-	err = json.Unmarshal(body, &result)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.apiURL("getUpdates")+"?"+query.Encode(), nil)
 	if err != nil {
 		return nil, err
 	}
-
-	if !result.Ok {
-		return nil, fmt.Errorf("failed to get updates: %s", body)
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return nil, newTransportError("getUpdates", err)
+	}
+	result, err := decodeAPIResponse("getUpdates", resp)
+	if err != nil {
+		return nil, err
 	}
 
-	return result.Result, nil
+	var updates []Update
+	if err := json.Unmarshal(result.Result, &updates); err != nil {
+		return nil, &ResponseError{Method: "getUpdates", StatusCode: resp.StatusCode, ContentType: resp.Header.Get("Content-Type"), Body: truncate(string(result.Result), 200), Err: err}
+	}
+	return updates, nil
 }
 
 // getLastUpdateID reads the last processed update ID from a file
-func getLastUpdateID() (int64, error) {
-	if _, err := os.Stat(lastUpdateIDFile); os.IsNotExist(err) {
+func (b *Bot) getLastUpdateID() (int64, error) {
+	if _, err := os.Stat(b.lastUpdateIDFile); os.IsNotExist(err) {
 		// If the file does not exist, start from update ID 0
 		return 0, nil
 	}
-	data, err := os.ReadFile(lastUpdateIDFile)
+	data, err := os.ReadFile(b.lastUpdateIDFile)
 	if err != nil {
 		// If the file does not exist, start from update ID 0
 		return 0, nil
@@ -85,151 +231,626 @@ func getLastUpdateID() (int64, error) {
 	var lastUpdateID int64
 	_, err = fmt.Sscanf(string(data), "%d", &lastUpdateID)
 	if err != nil {
-		return 0, err
+		// 文件为空或内容损坏时从 0 开始，而不是中止启动
+		b.logger.Warn("ignoring corrupt last update ID file", "file", b.lastUpdateIDFile, "error", err)
+		return 0, nil
 	}
 
 	return lastUpdateID, nil
 }
 
-// saveLastUpdateID saves the last processed update ID to a file
-func saveLastUpdateID(lastUpdateID int64) error {
-	return os.WriteFile(lastUpdateIDFile, []byte(fmt.Sprintf("%d", lastUpdateID)), 0644)
-}
+// saveLastUpdateID saves the last processed update ID to a file.
+// It writes to a temp file in the same directory and renames it over the target,
+// so a crash mid-write never leaves a truncated file behind.
+func (b *Bot) saveLastUpdateID(lastUpdateID int64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(b.lastUpdateIDFile), filepath.Base(b.lastUpdateIDFile)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// rename 成功后临时文件已不存在，Remove 只会清理失败时的残留
+	defer os.Remove(tmp.Name())
 
-// sendMessage sends a message to a specified chat
-func sendMessage(chatID int64, text string) error {
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", telegramBotToken)
-	payload := map[string]interface{}{
-		"chat_id": chatID,
-		"text":    text,
+	if _, err := fmt.Fprintf(tmp, "%d", lastUpdateID); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
 	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), b.lastUpdateIDFile)
+}
 
+// APIResponse represents the common envelope of a Telegram Bot API response
+type APIResponse struct {
+	Ok          bool            `json:"ok"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"` // 秒
+	} `json:"parameters"`
+}
+
+// callAPI posts a JSON payload to the given Bot API method and checks the ok flag
+func (b *Bot) callAPI(method string, payload interface{}) (*APIResponse, error) {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := b.http.Post(b.apiURL(method), "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return err
+		return nil, newTransportError(method, err)
 	}
+	return decodeAPIResponse(method, resp)
+}
+
+// decodeAPIResponse reads and closes resp, returning a *ResponseError when the body is
+// not Telegram's JSON and an *APIError when ok is false
+func decodeAPIResponse(method string, resp *http.Response) (*APIResponse, error) {
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, &TransportError{Method: method, Err: err}
+	}
+
+	// 代理或网关出错时可能返回 HTML 等非 JSON 内容，先检查 Content-Type 再解析
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "" && mediaType != "application/json" {
+		return nil, &ResponseError{Method: method, StatusCode: resp.StatusCode, ContentType: mediaType, Body: truncate(string(body), 200)}
+	}
+	var result APIResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, &ResponseError{Method: method, StatusCode: resp.StatusCode, ContentType: contentType, Body: truncate(string(body), 200), Err: err}
 	}
 
-	log.Printf("Response from sendMessage: %s\n", body)
-	return nil
+	if !result.Ok {
+		return nil, &APIError{
+			Method:      method,
+			StatusCode:  resp.StatusCode,
+			ErrorCode:   result.ErrorCode,
+			Description: result.Description,
+			RetryAfter:  time.Duration(result.Parameters.RetryAfter) * time.Second,
+		}
+	}
+
+	return &result, nil
+}
+
+// truncate shortens s to at most n bytes for logging
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
 }
 
-// extractUrls 从消息文本中提取所有匹配的 URL 地址
-func extractUrls(message string) []string {
-	return urlRegex.FindAllString(message, -1)
+// SendMessage sends a message to a specified chat and returns the ID of the sent message
+func (b *Bot) SendMessage(chatID int64, text string) (int64, error) {
+	return b.sendReply(chatID, 0, text)
 }
 
-// download 发送单个 URL 到后端进行下载
-func download(downloadURL string) error {
-	// 注意：这里使用 downloadURL，而不是整个 message
-	payload := strings.NewReader(fmt.Sprintf(`{
-		"url": "%s",
-		"download": true
-	}`, downloadURL))
+// sendReply sends a message as a reply to replyToMessageID (0 sends a standalone message)
+// and returns the ID of the sent message. Text over Telegram's length limit is sent as
+// several messages and the ID of the first one is returned.
+func (b *Bot) sendReply(chatID, replyToMessageID int64, text string) (int64, error) {
+	chunks := splitMessage(text, maxMessageLength)
+	firstID, err := b.sendReplyChunk(chatID, replyToMessageID, chunks[0])
+	if err != nil {
+		return 0, err
+	}
+	for _, chunk := range chunks[1:] {
+		if _, err := b.sendReplyChunk(chatID, replyToMessageID, chunk); err != nil {
+			return firstID, err
+		}
+	}
+	return firstID, nil
+}
 
-	client := &http.Client{Timeout: 10 * time.Minute}
-	req, err := http.NewRequest(http.MethodPost, backendURL, payload)
+// sendReplyChunk sends a single message that fits within maxMessageLength
+func (b *Bot) sendReplyChunk(chatID, replyToMessageID int64, text string) (int64, error) {
+	payload := map[string]interface{}{
+		"chat_id": chatID,
+		"text":    text,
+	}
+	if replyToMessageID != 0 {
+		payload["reply_to_message_id"] = replyToMessageID
+		// 原消息已被删除时仍然发送
+		payload["allow_sending_without_reply"] = true
+	}
 
+	resp, err := b.sendMessageWithRetry(payload)
 	if err != nil {
-		log.Printf("Error creating request for URL %s: %v", downloadURL, err)
-		return err
+		b.logger.Warn("failed to send message", "chat_id", chatID, "error", err)
+		return 0, err
 	}
-	req.Header.Add("Content-Type", "application/json")
 
-	res, err := client.Do(req)
+	var sent struct {
+		MessageID int64 `json:"message_id"`
+	}
+	if err := json.Unmarshal(resp.Result, &sent); err != nil {
+		return 0, fmt.Errorf("failed to decode sendMessage result: %w", err)
+	}
+
+	b.logger.Debug("message sent", "chat_id", chatID, "message_id", sent.MessageID)
+	return sent.MessageID, nil
+}
+
+// maxRetryAfter 限制 429 时最多等待的时间，避免一次通知阻塞 worker 太久
+const maxRetryAfter = time.Minute
+
+// sendMessageWithRetry calls sendMessage and, when Telegram answers 429,
+// waits for retry_after and tries once more
+func (b *Bot) sendMessageWithRetry(payload map[string]interface{}) (*APIResponse, error) {
+	resp, err := b.callAPI("sendMessage", payload)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !apiErr.IsTooManyRequests() {
+		return resp, err
+	}
+
+	wait := min(max(apiErr.RetryAfter, time.Second), maxRetryAfter)
+	b.logger.Warn("telegram rate limited sendMessage, retrying", "chat_id", payload["chat_id"], "retry_after", wait.String())
+	time.Sleep(wait)
+	return b.callAPI("sendMessage", payload)
+}
+
+// editMessageText replaces the text of a message previously sent by the bot
+func (b *Bot) editMessageText(chatID, messageID int64, text string) error {
+	_, err := b.callAPI("editMessageText", map[string]interface{}{
+		"chat_id":    chatID,
+		"message_id": messageID,
+		"text":       text,
+	})
 	if err != nil {
-		log.Printf("Error performing request for URL %s: %v", downloadURL, err)
-		return err
+		b.logger.Warn("failed to edit message", "chat_id", chatID, "message_id", messageID, "error", err)
 	}
-	defer res.Body.Close()
+	return err
+}
 
-	body, err := io.ReadAll(res.Body)
+// sendChatAction shows a status such as "uploading document" in the chat for about 5 seconds
+func (b *Bot) sendChatAction(chatID int64, action string) error {
+	_, err := b.callAPI("sendChatAction", map[string]interface{}{
+		"chat_id": chatID,
+		"action":  action,
+	})
 	if err != nil {
-		log.Printf("Error reading response body for URL %s: %v", downloadURL, err)
-		return err
+		b.logger.Debug("failed to send chat action", "chat_id", chatID, "action", action, "error", err)
+	}
+	return err
+}
+
+// BotUser is the bot account returned by getMe
+type BotUser struct {
+	ID        int64  `json:"id"`
+	IsBot     bool   `json:"is_bot"`
+	FirstName string `json:"first_name"`
+	Username  string `json:"username"`
+}
+
+// getMe returns the bot account the token belongs to, used to validate the token at startup
+func (b *Bot) getMe() (*BotUser, error) {
+	resp, err := b.callAPI("getMe", map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	var user BotUser
+	if err := json.Unmarshal(resp.Result, &user); err != nil {
+		return nil, fmt.Errorf("failed to decode getMe result: %w", err)
+	}
+	return &user, nil
+}
+
+// extractEntityUrls 提取 text_link 实体中隐藏的超链接，这些链接的显示文本与实际地址不同，正则无法匹配
+func extractEntityUrls(entities []MessageEntity) []string {
+	var urls []string
+	for _, entity := range entities {
+		if entity.Type == "text_link" && entity.URL != "" {
+			urls = append(urls, entity.URL)
+		}
+	}
+	return urls
+}
+
+// dedupKey 将 URL 规范化为去重用的 key：scheme/host 小写，去掉 fragment 和末尾的 /，query 参数排序
+func dedupKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
 	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawQuery = u.Query().Encode()
+	return u.String()
+}
+
+// handleUpdate 处理单个 update：提取消息中的 URL 并加入下载队列
+func handleUpdate(bot *Bot, update Update) {
+	if update.CallbackQuery != nil {
+		handleCallbackQuery(bot, update.CallbackQuery)
+		return
+	}
+
+	msg := update.Message
+	if msg == nil {
+		return
+	}
+
+	messagesReceived.Add(1)
+	chatID := msg.Chat.ID
+	logger.Info("received message", "chat_id", chatID, "message_id", msg.MessageID, "text", msg.Text)
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("backend returned status code %d, body: %s", res.StatusCode, string(body))
+	// 停机期间积压的旧消息不再处理，避免恢复后突然批量下载
+	if cfg.MaxMessageAge > 0 && msg.Date > 0 {
+		if age := time.Since(time.Unix(msg.Date, 0)); age > cfg.MaxMessageAge {
+			logger.Info("skipping stale message", "chat_id", chatID, "message_id", msg.MessageID, "age", age.Round(time.Second).String(), "max_age", cfg.MaxMessageAge.String())
+			return
+		}
 	}
-	
-	log.Printf("Backend response for URL %s: %s", downloadURL, string(body))
-	return nil
+
+	if !cfg.IsChatAllowed(chatID) {
+		logger.Warn("ignoring message from chat that is not allowed", "chat_id", chatID)
+		bot.sendReply(chatID, msg.MessageID, langFor(chatID).T("抱歉，此机器人未对当前聊天开放。"))
+		return
+	}
+
+	if dispatchCommand(bot, msg) {
+		return
+	}
+
+	if msg.Document != nil {
+		handleDocument(bot, msg)
+		return
+	}
+	enqueueURLs(bot, msg, msg.Text, false)
+}
+
+// enqueueURLs 提取文本中的 URL 并加入下载队列，后续通知都回复到 msg；force 为 true 时忽略已下载记录。
+// 文本开头的 "audio:"、"hd:" 等前缀作为这些 URL 的下载选项
+func enqueueURLs(bot *Bot, msg *Message, text string, force bool) {
+	chatID := msg.Chat.ID
+	options, text := parseOptions(text)
+	prefs := preferencesFor(chatID)
+	l := lang(prefs.Language)
+	options = defaultOptions(options, prefs)
+
+	// 1. 提取所有 URL，包括文本中的链接和超链接实体
+	urlsToDownload := urls.Merge(urlExtractor.Extract(text), extractEntityUrls(msg.Entities))
+
+	urlsExtracted.Add(int64(len(urlsToDownload)))
+	if len(urlsToDownload) == 0 {
+		logger.Info("no urls found in message", "chat_id", chatID)
+		bot.sendReply(chatID, msg.MessageID, l.T("消息中未找到任何可识别的 URL 地址，请确保链接以 http:// 或 https:// 开头。"))
+		return
+	}
+
+	// 按 ALLOWED_HOSTS/DENIED_HOSTS 过滤，被拒绝的链接告诉用户后跳过
+	urlsToDownload, blocked := filterHosts(urlsToDownload)
+	if len(blocked) > 0 {
+		logger.Info("skipping urls with blocked hosts", "chat_id", chatID, "urls", blocked)
+		bot.sendReply(chatID, msg.MessageID, l.Sprintf("以下 %d 个链接的域名不允许下载，已跳过：\n%s", len(blocked), strings.Join(blocked, "\n")))
+	}
+	if len(urlsToDownload) == 0 {
+		return
+	}
+
+	// 需要确认时先回复 inline keyboard，用户点击"下载"后才加入队列
+	if prefs.RequireConfirmation {
+		askConfirmation(bot, msg, urlsToDownload, force, options)
+		return
+	}
+	queueURLs(bot, msg, urlsToDownload, force, options)
+}
+
+// filterHosts 把 urls 按 ALLOWED_HOSTS/DENIED_HOSTS 分成允许和被拒绝的两部分
+func filterHosts(rawURLs []string) (allowed, blocked []string) {
+	filter := cfg.hostFilter()
+	for _, u := range rawURLs {
+		if filter.Allow(u) {
+			allowed = append(allowed, u)
+		} else {
+			blocked = append(blocked, u)
+		}
+	}
+	return allowed, blocked
+}
+
+// queueURLs 把已提取的 URL 规范化、去重后加入下载队列，后续通知都回复到 msg
+func queueURLs(bot *Bot, msg *Message, urlsToDownload []string, force bool, options []string) {
+	chatID := msg.Chat.ID
+	l := langFor(chatID)
+
+	// 演练模式的任务照常入队，由 worker 跳过下载，这里回显每个 URL 规范化后的结果
+	if cfg.DryRun {
+		replyDryRun(bot, msg, urlsToDownload)
+	}
+	// 短链接和它解析后的地址只下载一次
+	urlsToDownload = uniqueNormalized(urlsToDownload)
+	if !cfg.DryRun {
+		bot.sendReply(chatID, msg.MessageID, l.Sprintf("发现 %d 个 URL，已加入下载队列...", len(urlsToDownload)))
+	}
+
+	// 2. 将所有提取的 URL 加入队列，由 worker 并发下载，全部完成后发送汇总
+	startBatch(bot, msg)
+	defer sealBatch(bot, msg)
+	var unsupported []string
+	for i, url := range urlsToDownload {
+		if download.DetectPlatform(url) == download.PlatformUnknown {
+			logger.Info("skipping url from unsupported platform", "url", url, "chat_id", chatID)
+			unsupported = append(unsupported, url)
+			continue
+		}
+
+		if !rateLimiter.Allow(chatID) {
+			wait := int(math.Ceil(rateLimiter.Delay(chatID).Seconds()))
+			logger.Warn("chat exceeded rate limit", "chat_id", chatID, "skipped", len(urlsToDownload)-i)
+			bot.sendReply(chatID, msg.MessageID, l.Sprintf("请求过于频繁，已跳过剩余的 %d 个 URL，请在 %d 秒后再试。", len(urlsToDownload)-i, wait))
+			break
+		}
+
+		if _, err := jobQueue.EnqueueJob(queue.Job{URL: url, ChatID: chatID, MessageID: msg.MessageID, Force: force, Bot: bot.ID, Options: options}); err != nil {
+			logger.Error("failed to enqueue url", "url", url, "chat_id", chatID, "error", err)
+			bot.sendReply(chatID, msg.MessageID, l.Sprintf("加入队列失败: \nURL: %s\n错误: %v", url, err))
+			continue
+		}
+		addToBatch(bot, msg)
+	}
+
+	if len(unsupported) > 0 {
+		bot.sendReply(chatID, msg.MessageID, l.Sprintf("以下 %d 个链接不属于支持的平台（小红书、抖音、B站），已跳过，发送 /supported 查看支持的平台：\n%s",
+			len(unsupported), strings.Join(unsupported, "\n")))
+	}
+}
+
+// normalizeForDownload 返回规范化后的 URL，规范化失败时原样返回
+func normalizeForDownload(rawURL string) string {
+	normalized, err := normalizeURL(rawURL)
+	if err != nil {
+		logger.Warn("failed to normalize url, using it as is", "url", rawURL, "error", err)
+		return rawURL
+	}
+	if normalized != rawURL {
+		logger.Info("normalized url", "url", rawURL, "normalized", normalized)
+	}
+	return normalized
+}
+
+// uniqueNormalized 规范化每个 URL，并按 dedupKey 去掉规范化后重复的项，保持首次出现的顺序
+func uniqueNormalized(rawURLs []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, rawURL := range rawURLs {
+		normalized := normalizeForDownload(rawURL)
+		key := dedupKey(normalized)
+		if seen[key] {
+			logger.Info("skipping duplicate url in message", "url", rawURL, "normalized", normalized)
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, normalized)
+	}
+	return unique
+}
+
+// replyDryRun 回复将要下载的 URL 及其规范化结果
+func replyDryRun(bot *Bot, msg *Message, urls []string) {
+	var b strings.Builder
+	b.WriteString(langFor(msg.Chat.ID).Sprintf("[演练模式] 发现 %d 个 URL，将加入队列但不会实际下载：\n", len(urls)))
+	for i, u := range urls {
+		normalized := normalizeForDownload(u)
+		platform := download.DetectPlatform(normalized)
+		if normalized == u {
+			fmt.Fprintf(&b, "%d. [%s] %s\n", i+1, platform, u)
+		} else {
+			fmt.Fprintf(&b, "%d. [%s] %s\n   -> %s\n", i+1, platform, u, normalized)
+		}
+	}
+	logger.Info("dry run, queueing urls without downloading", "chat_id", msg.Chat.ID, "count", len(urls))
+	bot.sendReply(msg.Chat.ID, msg.MessageID, b.String())
 }
 
 func main() {
-	if telegramBotToken == "" || backendURL == "" {
-		log.Fatal("TELEGRAM_BOT_TOKEN or BACKEND_URL environment variable is not set.")
+	configPath := flag.String("config", "", "path to a YAML config file (default: config.yaml if it exists)")
+	validateOnly := flag.Bool("validate", false, "check the configuration, bot token, gallery-dl, proxy and backend, print a report and exit")
+	flag.Parse()
+
+	if *configPath == "" {
+		if _, err := os.Stat("config.yaml"); err == nil {
+			*configPath = "config.yaml"
+		}
 	}
-	
-	lastUpdateID, err := getLastUpdateID()
+
+	startTime = time.Now()
+	var err error
+	cfg, err = Load(*configPath)
 	if err != nil {
-		log.Fatalf("Failed to read last update ID: %v", err)
+		fatal("invalid configuration", "error", err)
 	}
+	logger = newLogger(cfg.LogLevel)
 
-	for {
-		fmt.Println("start get update message ...")
-		updates, err := getUpdates(lastUpdateID)
+	if *validateOnly {
+		os.Exit(runValidation(os.Stdout))
+	}
+
+	// 同一个 token 的两个实例会互相抢 getUpdates（409）并重复处理消息
+	if cfg.LockFile != "" {
+		lock, err := acquireLock(cfg.LockFile)
+		if errors.Is(err, errLocked) {
+			fatal("another instance of the bot is already running; stop it or set a different LOCK_FILE", "lock_file", cfg.LockFile, "error", err)
+		}
 		if err != nil {
-			log.Printf("Failed to get updates: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
+			fatal("failed to acquire lock file", "lock_file", cfg.LockFile, "error", err)
 		}
+		defer lock.release()
+	}
 
-		fmt.Printf("get [%d] message\n", len(updates))
-		for _, update := range updates {
-			if update.Message != nil {
-				messageText := update.Message.Text
-				chatID := update.Message.Chat.ID
-				log.Printf("Received message from chat %d: %s", chatID, messageText)
-				
-				// 1. 提取所有 URL
-				urlsToDownload := extractUrls(messageText)
-
-				if len(urlsToDownload) == 0 {
-					log.Println("No URLs found in the message, sending notification.")
-					sendMessage(chatID, "消息中未找到任何可识别的 URL 地址，请确保链接以 http:// 或 https:// 开头。")
-				} else {
-					sendMessage(chatID, fmt.Sprintf("发现 %d 个 URL，开始按顺序下载...", len(urlsToDownload)))
-				}
-
-				// 2. 循环下载所有提取的 URL
-				for _, url := range urlsToDownload {
-					log.Printf("Attempting to download URL: %s", url)
-					
-					// 调用 download 函数，传入单个 URL
-					if err := download(url); err != nil {
-						sendMessage(chatID, fmt.Sprintf("下载失败: \nURL: %s\n错误: %v", url, err))
-					} else {
-						sendMessage(chatID, fmt.Sprintf("下载成功: \nURL: %s", url))
-					}
-				}
-			}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// 收到第一个信号后恢复默认处理，在 SHUTDOWN_GRACE 期间再次发送信号可以立即退出
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if err := run(ctx); err != nil {
+		fatal("bot stopped", "error", err)
+	}
+}
 
-			// 3. 更新最后处理的 update_id
-			if update.UpdateID > lastUpdateID {
-				lastUpdateID = update.UpdateID
-			}
+// run 初始化共享的下载队列和 worker，为每个 token 创建 Bot 并运行，直到 ctx 被取消
+func run(ctx context.Context) error {
+	rateLimiter = NewRateLimiter(cfg.RateLimitPerMinute)
+	urlExtractor = urls.Extractor{Trailing: cfg.URLTrimChars, JoinWrapped: cfg.URLJoinWrapped}
+	if urlExtractor.Trailing == "" {
+		urlExtractor.Trailing = urls.DefaultTrailing
+	}
+	if cfg.URLPattern != "" {
+		urlExtractor.Pattern = regexp.MustCompile(cfg.URLPattern)
+	}
+	extraNotifiers = newExtraNotifiers(cfg.NotifyWebhooks)
+	if cfg.S3Bucket != "" {
+		s3, err := storage.NewS3(ctx, storage.S3Options{
+			Bucket:    cfg.S3Bucket,
+			Region:    cfg.S3Region,
+			Endpoint:  cfg.S3Endpoint,
+			PathStyle: cfg.S3PathStyle,
+			Prefix:    cfg.S3Prefix,
+			Presign:   cfg.S3PresignExpiry,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set up S3 storage: %w", err)
+		}
+		fileStorage = s3
+		logger.Info("uploading downloads to S3", "bucket", cfg.S3Bucket, "endpoint", cfg.S3Endpoint)
+	}
+
+	if cfg.RequireProxy {
+		if err := checkRequiredProxies(ctx); err != nil {
+			return err
 		}
+	}
 
-		// 保存最后处理的 update_id
-		err = saveLastUpdateID(lastUpdateID)
+	var postProcessors []download.PostProcessor
+	if cfg.FFmpegConvert && cfg.DownloadMode == download.ModeGalleryDL {
+		ffmpeg, err := download.NewFFmpeg(logger)
 		if err != nil {
-			log.Printf("Failed to save last update ID: %v", err)
+			logger.Warn("video conversion disabled, videos are uploaded as downloaded", "error", err)
+		} else {
+			postProcessors = append(postProcessors, ffmpeg)
 		}
+	}
 
-		// 休眠一段时间再继续轮询
-		fmt.Println("go to sleep 2s")
-		time.Sleep(2 * time.Second)
+	dl, err := download.New(cfg.DownloadMode, download.Options{
+		BackendURL:        cfg.BackendURL,
+		IdempotencyHeader: cfg.IdempotencyHeader,
+		BreakerThreshold:  cfg.BreakerThreshold,
+		BreakerCooldown:   cfg.BreakerCooldown,
+		DownloadDir:       cfg.DownloadDir,
+		CookiesFile:       cfg.CookiesFile,
+		Proxy:             cfg.Proxy,
+		RequireProxy:      cfg.RequireProxy,
+		PlatformArgs:      cfg.PlatformArgs,
+		ExtraArgs:         cfg.GalleryDLArgs,
+		MaxBytes:          cfg.MaxDownloadBytes,
+		MinFreeBytes:      cfg.MinFreeBytes,
+		FilenameTemplate:  cfg.FilenameTemplate,
+		WriteMetadata:     cfg.UploadFiles && cfg.UploadCaptions,
+		PostProcessors:    postProcessors,
+		Logger:            logger,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up downloader: %w", err)
 	}
-}
\ No newline at end of file
+	logger.Info("download mode selected", "mode", cfg.DownloadMode)
+
+	// 启动时先验证 token，避免错误的 token 表现为轮询中反复出现的 getUpdates 错误
+	bots = newBots(cfg, dl)
+	for _, bot := range bots {
+		me, err := bot.getMe()
+		if err != nil {
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && apiErr.IsUnauthorized() {
+				return fmt.Errorf("telegram rejected bot token %s, check TELEGRAM_BOT_TOKEN: %w", bot.ID, err)
+			}
+			return fmt.Errorf("failed to verify bot token %s with getMe: %w", bot.ID, err)
+		}
+		bot.logger.Info("authorized as bot", "username", me.Username, "id", me.ID)
+
+		// 命令菜单只影响 Telegram 客户端的提示，失败时不影响启动
+		if err := bot.setMyCommands(botCommands()); err != nil {
+			bot.logger.Warn("failed to register bot commands", "error", err)
+		} else {
+			bot.logger.Info("registered bot commands", "count", len(commands))
+		}
+	}
+
+	jobQueue, err = queue.Open(cfg.QueueDB)
+	if err != nil {
+		return fmt.Errorf("failed to open download queue: %w", err)
+	}
+	defer jobQueue.Close()
+
+	// 上次运行中未完成的任务重新入队，中断的下载先清理再通知用户
+	interrupted, err := jobQueue.Interrupted()
+	if err != nil {
+		return fmt.Errorf("failed to load interrupted jobs: %w", err)
+	}
+	requeued, err := jobQueue.Requeue()
+	if err != nil {
+		return fmt.Errorf("failed to requeue unfinished jobs: %w", err)
+	}
+	if requeued > 0 {
+		logger.Info("resuming unfinished download jobs", "count", requeued, "interrupted", len(interrupted))
+	}
+	resumeInterrupted(interrupted)
+
+	downloader = NewDownloader(cfg.Concurrency, cfg.PerChatConcurrency)
+	defer downloader.Shutdown(cfg.ShutdownGrace)
+	go dispatchJobs(downloader)
+	if cfg.DownloadMode == download.ModeGalleryDL {
+		go runSubscriptions(ctx)
+		// 提前缓存 gallery-dl 支持的站点，/supported 不用等待 gallery-dl 启动
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, extractorsTimeout)
+			defer cancel()
+			if _, err := download.Extractors(ctx); err != nil {
+				logger.Warn("failed to list gallery-dl extractors", "error", err)
+			}
+		}()
+	}
+
+	if cfg.MetricsPort != "" && (cfg.WebhookURL == "" || cfg.MetricsPort != cfg.Port) {
+		go serveMetrics(":" + cfg.MetricsPort)
+	}
+
+	if cfg.WebhookURL != "" {
+		return runWebhook(ctx)
+	}
+
+	// 每个 bot 独立轮询，共享同一个下载队列；任一 bot 出错时停止全部
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make([]error, len(bots))
+	var wg sync.WaitGroup
+	for i, bot := range bots {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = bot.Run(ctx); errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deckvig/telegram-bot/download"
+	"github.com/deckvig/telegram-bot/urls"
+)
+
+func TestGetUpdatesQuery(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedUpdates []string
+		wantAllowed    string
+	}{
+		{"default allowed updates", nil, ""},
+		{"allowed updates", []string{"message", " edited_message ", ""}, `["message","edited_message"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeTelegram(t)
+			c := testConfig()
+			c.PollTimeout, c.MaxBatch, c.AllowedUpdates = 25*time.Second, 50, tt.allowedUpdates
+			b := newTestBot(t, f, c, nil)
+
+			if _, err := b.GetUpdates(context.Background(), 41); err != nil {
+				t.Fatalf("GetUpdates() error = %v", err)
+			}
+			calls := f.callsTo("getUpdates")
+			if len(calls) != 1 {
+				t.Fatalf("got %d getUpdates calls, want 1", len(calls))
+			}
+			q := calls[0].Query
+			if q.Get("offset") != "42" || q.Get("timeout") != "25" || q.Get("limit") != "50" {
+				t.Errorf("query = %v, want offset 42, timeout 25, limit 50", q)
+			}
+			if got := q.Get("allowed_updates"); got != tt.wantAllowed || q.Has("allowed_updates") != (tt.wantAllowed != "") {
+				t.Errorf("allowed_updates = %q, want %q", got, tt.wantAllowed)
+			}
+		})
+	}
+}
+
+func TestGetUpdatesResponses(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		wantIDs     []int64
+		check       func(t *testing.T, err error)
+	}{
+		{
+			name:        "updates",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"ok":true,"result":[{"update_id":7,"message":{"message_id":1,"text":"hi","chat":{"id":5}}},{"update_id":8}]}`,
+			wantIDs:     []int64{7, 8},
+		},
+		{
+			name:        "empty",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"ok":true,"result":[]}`,
+		},
+		{
+			name:        "ok false",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"ok":false,"error_code":400,"description":"Bad Request: wrong offset"}`,
+			check: func(t *testing.T, err error) {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || !apiErr.IsBadRequest() || apiErr.Description != "Bad Request: wrong offset" {
+					t.Errorf("error = %v, want bad request APIError", err)
+				}
+			},
+		},
+		{
+			name:        "rate limited",
+			status:      http.StatusTooManyRequests,
+			contentType: "application/json",
+			body:        `{"ok":false,"error_code":429,"description":"Too Many Requests","parameters":{"retry_after":3}}`,
+			check: func(t *testing.T, err error) {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || !apiErr.IsTooManyRequests() || apiErr.RetryAfter != 3*time.Second || apiErr.StatusCode != http.StatusTooManyRequests {
+					t.Errorf("error = %v, want 429 APIError with retry_after 3s", err)
+				}
+			},
+		},
+		{
+			name:        "conflict",
+			status:      http.StatusConflict,
+			contentType: "application/json",
+			body:        `{"ok":false,"error_code":409,"description":"Conflict: terminated by other getUpdates request"}`,
+			check: func(t *testing.T, err error) {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || !apiErr.IsConflict() {
+					t.Errorf("error = %v, want conflict APIError", err)
+				}
+			},
+		},
+		{
+			name:        "html gateway error",
+			status:      http.StatusBadGateway,
+			contentType: "text/html; charset=utf-8",
+			body:        "<html><body>502 Bad Gateway</body></html>",
+			check: func(t *testing.T, err error) {
+				var respErr *ResponseError
+				if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusBadGateway || respErr.ContentType != "text/html" || respErr.Err != nil {
+					t.Errorf("error = %v, want ResponseError for text/html", err)
+				}
+			},
+		},
+		{
+			name:        "malformed json",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"ok":true,"result":[`,
+			check: func(t *testing.T, err error) {
+				var respErr *ResponseError
+				if !errors.As(err, &respErr) || respErr.Err == nil {
+					t.Errorf("error = %v, want ResponseError with a JSON error", err)
+				}
+			},
+		},
+		{
+			name:        "result is not a list",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"ok":true,"result":{"update_id":1}}`,
+			check: func(t *testing.T, err error) {
+				var respErr *ResponseError
+				if !errors.As(err, &respErr) || respErr.Err == nil {
+					t.Errorf("error = %v, want ResponseError with a JSON error", err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeTelegram(t)
+			f.handle("getUpdates", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			b := newTestBot(t, f, testConfig(), nil)
+
+			updates, err := b.GetUpdates(context.Background(), 0)
+			if tt.check != nil {
+				if err == nil {
+					t.Fatalf("GetUpdates() returned %d updates, want an error", len(updates))
+				}
+				tt.check(t, err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetUpdates() error = %v", err)
+			}
+			if len(updates) != len(tt.wantIDs) {
+				t.Fatalf("got %d updates, want %d", len(updates), len(tt.wantIDs))
+			}
+			for i, u := range updates {
+				if u.UpdateID != tt.wantIDs[i] {
+					t.Errorf("updates[%d].UpdateID = %d, want %d", i, u.UpdateID, tt.wantIDs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetUpdatesTransportError(t *testing.T) {
+	f := newFakeTelegram(t)
+	b := newTestBot(t, f, testConfig(), nil)
+	f.Close()
+
+	_, err := b.GetUpdates(context.Background(), 0)
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) {
+		t.Errorf("GetUpdates() error = %v, want TransportError", err)
+	}
+	if strings.Contains(err.Error(), "123:test") {
+		t.Errorf("GetUpdates() error = %v, contains the bot token", err)
+	}
+}
+
+func TestPingHidesToken(t *testing.T) {
+	withTestConfig(t)
+	withJobQueue(t)
+	f := newFakeTelegram(t)
+	b := newTestBot(t, f, cfg, nil)
+	// getMe 的连接在响应前断开，sendMessage 正常
+	f.handle("getMe", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	handleUpdate(b, Update{UpdateID: 1, Message: testMessage(5, 10, "/ping")})
+	replies := f.sentTexts()
+	if len(replies) != 1 || !strings.HasPrefix(replies[0], "连接 Telegram 失败") {
+		t.Fatalf("replies = %q, want a connection failure", replies)
+	}
+	if strings.Contains(replies[0], "123:test") {
+		t.Errorf("reply %q contains the bot token", replies[0])
+	}
+}
+
+func TestSendReply(t *testing.T) {
+	tests := []struct {
+		name      string
+		replyTo   int64
+		handler   http.HandlerFunc
+		wantID    int64
+		wantErr   bool
+		wantReply bool
+	}{
+		{name: "standalone", wantID: 101},
+		{name: "reply", replyTo: 9, wantID: 101, wantReply: true},
+		{
+			name: "ok false",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "error_code": 400, "description": "Bad Request: chat not found"})
+			},
+			wantErr: true,
+		},
+		{
+			name: "non-json error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "upstream unavailable", http.StatusServiceUnavailable)
+			},
+			wantErr: true,
+		},
+		{
+			name: "malformed result",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, map[string]any{"ok": true, "result": "sent"})
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeTelegram(t)
+			if tt.handler != nil {
+				f.handle("sendMessage", tt.handler)
+			}
+			b := newTestBot(t, f, testConfig(), nil)
+
+			id, err := b.sendReply(5, tt.replyTo, "已加入下载队列")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sendReply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if id != tt.wantID {
+				t.Errorf("sendReply() = %d, want %d", id, tt.wantID)
+			}
+			calls := f.callsTo("sendMessage")
+			if len(calls) != 1 {
+				t.Fatalf("got %d sendMessage calls, want 1", len(calls))
+			}
+			p := calls[0].Payload
+			if p["chat_id"] != float64(5) || p["text"] != "已加入下载队列" {
+				t.Errorf("payload = %v, want chat_id 5 and the message text", p)
+			}
+			if tt.wantReply {
+				if p["reply_to_message_id"] != float64(tt.replyTo) || p["allow_sending_without_reply"] != true {
+					t.Errorf("payload = %v, want reply_to_message_id %d with allow_sending_without_reply", p, tt.replyTo)
+				}
+			} else if _, ok := p["reply_to_message_id"]; ok {
+				t.Errorf("payload = %v, want no reply_to_message_id", p)
+			}
+		})
+	}
+}
+
+func TestSendReplyRetriesAfterRateLimit(t *testing.T) {
+	f := newFakeTelegram(t)
+	limited := true
+	f.handle("sendMessage", func(w http.ResponseWriter, r *http.Request) {
+		if limited {
+			limited = false
+			writeJSON(w, http.StatusTooManyRequests, map[string]any{"ok": false, "error_code": 429, "description": "Too Many Requests", "parameters": map[string]any{"retry_after": 1}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "result": map[string]any{"message_id": 55}})
+	})
+	b := newTestBot(t, f, testConfig(), nil)
+
+	id, err := b.sendReply(5, 0, "hi")
+	if err != nil || id != 55 {
+		t.Fatalf("sendReply() = %d, %v, want 55, nil", id, err)
+	}
+	if n := len(f.callsTo("sendMessage")); n != 2 {
+		t.Errorf("got %d sendMessage calls, want 2", n)
+	}
+}
+
+// fakeDownloader 记录收到的 URL，按 errs 返回每个 URL 的错误，没有列出的 URL 下载成功
+type fakeDownloader struct {
+	mu   sync.Mutex
+	urls []string
+	errs map[string]error
+}
+
+func (d *fakeDownloader) Download(ctx context.Context, url string) (download.Result, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.urls = append(d.urls, url)
+	return download.Result{}, d.errs[url]
+}
+
+func (d *fakeDownloader) called() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.urls)
+}
+
+// startTestBot 准备 handleUpdate 需要的全局状态：连接到 fake Telegram 的 bot、临时队列和下载 worker，
+// 测试结束时停止 worker 并恢复原来的值
+func startTestBot(t *testing.T, d download.Downloader, configure func(c *Config)) (*fakeTelegram, *Bot) {
+	t.Helper()
+	c := withTestConfig(t)
+	c.MaxRetries, c.RetryBaseDelay, c.RetryMaxDelay, c.RetentionMinutes = 1, time.Millisecond, time.Millisecond, -1
+	c.Concurrency = 2
+	if configure != nil {
+		configure(c)
+	}
+	withJobQueue(t)
+	f := newFakeTelegram(t)
+	b := newTestBot(t, f, c, d)
+	withBots(t, b)
+
+	savedLimiter, savedExtractor, savedDownloader := rateLimiter, urlExtractor, downloader
+	rateLimiter = NewRateLimiter(c.RateLimitPerMinute)
+	urlExtractor = urls.Extractor{Trailing: urls.DefaultTrailing}
+	downloader = NewDownloader(c.Concurrency, c.PerChatConcurrency)
+	dispatched := make(chan struct{})
+	go func() {
+		dispatchJobs(downloader)
+		close(dispatched)
+	}()
+	t.Cleanup(func() {
+		downloader.Shutdown(time.Second)
+		<-dispatched
+		rateLimiter, urlExtractor, downloader = savedLimiter, savedExtractor, savedDownloader
+	})
+	return f, b
+}
+
+// containsAll 判断 texts 中是否对每个 want 都有包含它的一条
+func containsAll(texts []string, want []string) bool {
+	for _, w := range want {
+		if !slices.ContainsFunc(texts, func(text string) bool { return strings.Contains(text, w) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHandleUpdate(t *testing.T) {
+	const (
+		note1 = "https://www.xiaohongshu.com/explore/6650a1b2000000001e03c4d5"
+		note2 = "https://www.xiaohongshu.com/explore/6650a1b2000000001e03c4d6"
+	)
+	tests := []struct {
+		name          string
+		configure     func(c *Config)
+		text          string
+		errs          map[string]error
+		wantReplies   []string
+		wantDownloads []string
+	}{
+		{
+			name:        "no urls",
+			text:        "你好",
+			wantReplies: []string{"消息中未找到任何可识别的 URL"},
+		},
+		{
+			name:          "one url",
+			text:          "😆 ZmT4pQ 😆 " + note1 + "，复制本条信息，打开【小红书】App查看精彩内容！",
+			wantReplies:   []string{"发现 1 个 URL，已加入下载队列", "下载成功: \nURL: " + note1},
+			wantDownloads: []string{note1},
+		},
+		{
+			name:          "several urls with a failure",
+			text:          note1 + "\n" + note2,
+			errs:          map[string]error{note2: download.ErrNotFound},
+			wantReplies:   []string{"发现 2 个 URL", "下载成功: \nURL: " + note1, "内容不存在或已被删除。\nURL: " + note2, "全部完成：1 成功, 1 失败"},
+			wantDownloads: []string{note1, note2},
+		},
+		{
+			name:          "download error",
+			text:          note1,
+			errs:          map[string]error{note1: errors.New("connection reset by peer")},
+			wantReplies:   []string{"下载失败 (已尝试 1 次): \nURL: " + note1 + "\n错误: connection reset by peer"},
+			wantDownloads: []string{note1},
+		},
+		{
+			name:        "unsupported platform",
+			text:        "https://example.com/a",
+			wantReplies: []string{"不属于支持的平台"},
+		},
+		{
+			name:        "command",
+			text:        "/help",
+			wantReplies: []string{"可用命令"},
+		},
+		{
+			name:        "unknown command",
+			text:        "/nosuchcommand",
+			wantReplies: []string{"未知命令 /nosuchcommand"},
+		},
+		{
+			name:        "unauthorized chat",
+			configure:   func(c *Config) { c.AllowedChats = []int64{1} },
+			text:        note1,
+			wantReplies: []string{"未对当前聊天开放"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &fakeDownloader{errs: tt.errs}
+			f, b := startTestBot(t, d, tt.configure)
+
+			handleUpdate(b, Update{UpdateID: 1, Message: testMessage(5, 10, tt.text)})
+			f.waitFor(t, 5*time.Second, func() bool { return containsAll(f.sentTexts(), tt.wantReplies) })
+			for _, call := range f.callsTo("sendMessage") {
+				if call.Payload["chat_id"] != float64(5) || call.Payload["reply_to_message_id"] != float64(10) {
+					t.Errorf("sendMessage payload = %v, want a reply to message 10 in chat 5", call.Payload)
+				}
+			}
+
+			// 回复已经发出后，确认没有多余的下载
+			if pending, _ := jobQueue.Pending(); pending > 0 {
+				t.Errorf("%d jobs still pending", pending)
+			}
+			got := d.called()
+			slices.Sort(got)
+			if !slices.Equal(got, tt.wantDownloads) {
+				t.Errorf("downloaded %q, want %q", got, tt.wantDownloads)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "xhs_bot"
+
+var (
+	downloadsAttempted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "downloads_attempted_total",
+		Help:      "Total number of download attempts.",
+	})
+	downloadsSucceeded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "downloads_succeeded_total",
+		Help:      "Total number of successful downloads.",
+	})
+	downloadsFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "downloads_failed_total",
+		Help:      "Total number of failed downloads.",
+	})
+	downloadRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "download_retries_total",
+		Help:      "Total number of download attempts that were retries of an earlier attempt.",
+	})
+	downloadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "download_duration_seconds",
+		Help:      "Duration of a single download request.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12), // 0.5s ~ 17min
+	})
+	hostDownloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "host_download_duration_seconds",
+		Help:      "Duration of a single download attempt by URL host and result (success or failure).",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"host", "result"})
+	hostDownloadBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "host_download_bytes",
+		Help:      "Total size of the files of a successful gallery-dl download by URL host.",
+		Buckets:   prometheus.ExponentialBuckets(64<<10, 4, 10), // 64KB ~ 16GB
+	}, []string{"host"})
+	hostDownloadFiles = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "host_download_files",
+		Help:      "Number of files of a successful gallery-dl download by URL host.",
+		Buckets:   []float64{1, 2, 5, 10, 20, 50, 100},
+	}, []string{"host"})
+)
+
+// maxMetricHosts 限制 host 标签的取值个数，之后出现的新 host 记为 other，避免用户发送的链接让指标无限增长
+const maxMetricHosts = 50
+
+var metricHosts = struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+// metricHost 返回 rawURL 用作标签的 host：小写、去掉端口和 www. 前缀，超过 maxMetricHosts 后为 other
+func metricHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return "other"
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+
+	metricHosts.mu.Lock()
+	defer metricHosts.mu.Unlock()
+	if !metricHosts.seen[host] {
+		if len(metricHosts.seen) >= maxMetricHosts {
+			return "other"
+		}
+		metricHosts.seen[host] = true
+	}
+	return host
+}
+
+// observeHostAttempt 按 host 记录一次下载尝试的耗时
+func observeHostAttempt(rawURL string, elapsed time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	hostDownloadDuration.WithLabelValues(metricHost(rawURL), result).Observe(elapsed.Seconds())
+}
+
+// observeHostFiles 按 host 记录成功下载的文件数和总大小，只有 gallery-dl 模式有本地文件
+func observeHostFiles(rawURL string, files int, bytes int64) {
+	host := metricHost(rawURL)
+	hostDownloadFiles.WithLabelValues(host).Observe(float64(files))
+	hostDownloadBytes.WithLabelValues(host).Observe(float64(bytes))
+}
+
+// serveMetrics 在独立端口上提供 /metrics
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	logger.Info("serving metrics", "addr", addr, "path", "/metrics")
+	if err := server.ListenAndServe(); err != nil {
+		logger.Error("metrics server stopped", "error", err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// trackingParams 是小红书分享链接中常见的跟踪参数，规范化时会被去掉。
+// xsec_token 不在此列，缺少它时笔记页面可能无法访问。
+var trackingParams = map[string]bool{
+	"xhsshare":               true,
+	"appuid":                 true,
+	"apptime":                true,
+	"app_platform":           true,
+	"app_version":            true,
+	"share_from_user_hidden": true,
+	"share_id":               true,
+	"shareRedId":             true,
+	"share_channel":          true,
+	"author_share":           true,
+	"type":                   true,
+	"exSource":               true,
+	"verifyUid":              true,
+	"wechatWid":              true,
+	"wechatOrigin":           true,
+	"ignoreEngage":           true,
+	"source":                 true,
+	"xsec_source":            true,
+	"utm_source":             true,
+	"utm_medium":             true,
+	"utm_campaign":           true,
+}
+
+// notePathRegex 匹配各种形式的笔记路径，提取笔记 ID
+var notePathRegex = regexp.MustCompile(`^/(?:explore|discovery/item)/([0-9a-zA-Z]+)/?$`)
+
+// redirectClient 仅用于解析短链接，不读取响应体
+var redirectClient = &http.Client{Timeout: 15 * time.Second}
+
+// normalizeURL 解析 xhslink.com 短链接，去掉跟踪参数并规范化小红书笔记 URL
+func normalizeURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw, err
+	}
+
+	if isShortLink(u.Host) {
+		resolved, err := resolveRedirect(raw)
+		if err != nil {
+			return raw, err
+		}
+		u = resolved
+	}
+
+	if strings.HasSuffix(strings.ToLower(u.Host), "xiaohongshu.com") {
+		query := u.Query()
+		stripped := false
+		for key := range query {
+			if trackingParams[key] {
+				query.Del(key)
+				stripped = true
+			}
+		}
+		// 没有去掉任何参数时保留原始编码
+		if stripped {
+			u.RawQuery = query.Encode()
+		}
+
+		// 统一为 https://www.xiaohongshu.com/explore/<note id>
+		if m := notePathRegex.FindStringSubmatch(u.Path); m != nil {
+			u.Scheme = "https"
+			u.Host = "www.xiaohongshu.com"
+			u.Path = "/explore/" + m[1]
+		}
+		u.Fragment = ""
+	}
+
+	return u.String(), nil
+}
+
+// isShortLink reports whether host is a Xiaohongshu short-link domain
+func isShortLink(host string) bool {
+	host = strings.ToLower(host)
+	return host == "xhslink.com" || strings.HasSuffix(host, ".xhslink.com")
+}
+
+// resolveRedirect 跟随重定向得到最终 URL，优先使用 HEAD，服务端不支持时退回 GET 且不读取响应体
+func resolveRedirect(raw string) (*url.URL, error) {
+	resp, err := redirectClient.Head(raw)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		resp.Body.Close()
+		resp, err = redirectClient.Get(raw)
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return resp.Request.URL, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Notifier delivers download result messages for a chat
+type Notifier interface {
+	Notify(chatID int64, text string) error
+}
+
+// telegramNotifier 通过 bot 把结果作为 replyTo 的回复发送到 chat
+type telegramNotifier struct {
+	bot     *Bot
+	replyTo int64
+}
+
+func (n telegramNotifier) Notify(chatID int64, text string) error {
+	_, err := n.bot.sendReply(chatID, n.replyTo, text)
+	return err
+}
+
+// WebhookNotifier POSTs every result as JSON to URL. The body carries the text as
+// both "text" and "content", which Slack and Discord webhooks accept directly.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookNotifier returns a WebhookNotifier for url with a short request timeout
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *WebhookNotifier) Notify(chatID int64, text string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"chat_id": chatID,
+		"text":    text,
+		"content": text,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.Client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("notification webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// extraNotifiers 是 NOTIFY_WEBHOOKS 配置的其他通知渠道，启动时创建
+var extraNotifiers []Notifier
+
+// newExtraNotifiers 为每个 NOTIFY_WEBHOOKS 地址创建一个 WebhookNotifier
+func newExtraNotifiers(endpoints []string) []Notifier {
+	var notifiers []Notifier
+	for _, url := range endpoints {
+		if url = strings.TrimSpace(url); url != "" {
+			notifiers = append(notifiers, NewWebhookNotifier(url))
+		}
+	}
+	return notifiers
+}
+
+// notifyResult 把下载结果发送到所有配置的渠道：NOTIFY_TELEGRAM 开启时回复 replyTo，再加上 extraNotifiers
+func notifyResult(bot *Bot, chatID, replyTo int64, text string) {
+	notifiers := extraNotifiers
+	if cfg.NotifyTelegram {
+		notifiers = append([]Notifier{telegramNotifier{bot: bot, replyTo: replyTo}}, notifiers...)
+	}
+	for _, n := range notifiers {
+		if err := n.Notify(chatID, text); err != nil {
+			logger.Warn("failed to deliver notification", "chat_id", chatID, "notifier", fmt.Sprintf("%T", n), "error", err)
+		}
+	}
+}
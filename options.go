@@ -0,0 +1,34 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/deckvig/telegram-bot/download"
+)
+
+// optionPrefixRegex 匹配消息开头的 "audio:"、"hd：" 这样的选项前缀。
+// 冒号后面紧跟 // 时是 URL 的 scheme，不是选项
+var optionPrefixRegex = regexp.MustCompile(`^\s*([A-Za-z][A-Za-z0-9_-]*)\s*[:：]`)
+
+// parseOptions 取出 text 开头的选项前缀，返回识别到的下载选项和剩余的文本。
+// 未知的前缀会被忽略，剩余文本中的链接照常下载
+func parseOptions(text string) (options []string, rest string) {
+	rest = text
+	for {
+		m := optionPrefixRegex.FindStringSubmatchIndex(rest)
+		if m == nil || strings.HasPrefix(rest[m[1]:], "//") {
+			return options, rest
+		}
+		name := strings.ToLower(rest[m[2]:m[3]])
+		rest = rest[m[1]:]
+		if !download.IsOption(name) {
+			logger.Debug("ignoring unknown option prefix", "prefix", name)
+			continue
+		}
+		if !slices.Contains(options, name) {
+			options = append(options, name)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxProgressLines 进度消息中最多保留的行数
+const maxProgressLines = 10
+
+// progressHeartbeat 是没有新输出时刷新进度消息中已用时间的间隔。gallery-dl 下载完一个文件才输出一行，
+// 大视频下载期间没有输出，用户也能看到下载仍在进行
+const progressHeartbeat = 15 * time.Second
+
+// progressUpdater 把下载过程中的输出行合并到一条消息里，并限制编辑频率以免触发 Telegram 限流
+type progressUpdater struct {
+	bot      *Bot
+	chatID   int64
+	replyTo  int64
+	interval time.Duration
+	lang     lang // 创建时读取 chat 的语言，之后每次编辑不再查询
+
+	mu        sync.Mutex
+	start     time.Time
+	lines     []string
+	total     int
+	messageID int64 // 0 表示还没有发送过进度消息
+	lastEdit  time.Time
+	dirty     bool
+	closed    bool
+	timer     *time.Timer
+	heartbeat *time.Timer
+}
+
+// newProgressUpdater 创建 updater，第一行输出到达或下载超过 progressHeartbeat 时才会发送消息
+func newProgressUpdater(bot *Bot, chatID, replyTo int64, interval time.Duration) *progressUpdater {
+	p := &progressUpdater{bot: bot, chatID: chatID, replyTo: replyTo, interval: interval, lang: langFor(chatID), start: time.Now()}
+	p.heartbeat = time.AfterFunc(progressHeartbeat, p.beat)
+	return p
+}
+
+// beat 刷新消息中的已用时间，然后安排下一次刷新
+func (p *progressUpdater) beat() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	if time.Since(p.lastEdit) >= progressHeartbeat/2 {
+		p.dirty = true
+		p.flushLocked()
+	}
+	p.heartbeat.Reset(progressHeartbeat)
+}
+
+// Add 记录一行输出，在节流间隔内的多行会合并为一次编辑
+func (p *progressUpdater) Add(line string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 只展示文件名，避免把服务器路径暴露给用户
+	if !strings.HasPrefix(line, "[") {
+		line = filepath.Base(line)
+	}
+	p.lines = append(p.lines, line)
+	if len(p.lines) > maxProgressLines {
+		p.lines = p.lines[len(p.lines)-maxProgressLines:]
+	}
+	p.total++
+	p.dirty = true
+
+	if wait := p.interval - time.Since(p.lastEdit); wait > 0 {
+		if p.timer == nil {
+			p.timer = time.AfterFunc(wait, func() {
+				p.mu.Lock()
+				defer p.mu.Unlock()
+				p.timer = nil
+				p.flushLocked()
+			})
+		}
+		return
+	}
+	p.flushLocked()
+}
+
+// Close 停止定时器并把尚未发送的内容刷新到消息中
+func (p *progressUpdater) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	p.heartbeat.Stop()
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.flushLocked()
+}
+
+// flushLocked 发送或编辑进度消息，调用方必须持有 p.mu
+func (p *progressUpdater) flushLocked() {
+	if !p.dirty {
+		return
+	}
+	p.dirty = false
+	p.lastEdit = time.Now()
+
+	elapsed := time.Since(p.start).Round(time.Second)
+	text := p.lang.Sprintf("下载中... 已用时 %s", elapsed)
+	if p.total > 0 {
+		text = p.lang.Sprintf("下载中... 已处理 %d 个文件，已用时 %s\n%s", p.total, elapsed, strings.Join(p.lines, "\n"))
+	}
+	if p.messageID == 0 {
+		id, err := p.bot.sendReply(p.chatID, p.replyTo, text)
+		if err == nil {
+			p.messageID = id
+		}
+		return
+	}
+	p.bot.editMessageText(p.chatID, p.messageID, text)
+}
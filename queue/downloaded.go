@@ -0,0 +1,23 @@
+package queue
+
+import "time"
+
+// IsDownloaded reports whether url has been downloaded successfully before
+func (q *Queue) IsDownloaded(url string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var n int
+	err := q.db.QueryRow(`SELECT COUNT(*) FROM downloaded WHERE url = ?`, url).Scan(&n)
+	return n > 0, err
+}
+
+// MarkDownloaded records url as successfully downloaded
+func (q *Queue) MarkDownloaded(url string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err := q.db.Exec(`INSERT INTO downloaded (url, downloaded_at) VALUES (?, ?)
+		ON CONFLICT (url) DO UPDATE SET downloaded_at = excluded.downloaded_at`, url, time.Now().Unix())
+	return err
+}
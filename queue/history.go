@@ -0,0 +1,67 @@
+package queue
+
+import "time"
+
+// HistoryEntry is one recorded download attempt of a job
+type HistoryEntry struct {
+	ID         int64
+	URL        string
+	ChatID     int64
+	StartedAt  time.Time
+	FinishedAt time.Time // 未完成时为零值
+	Status     Status
+	Bytes      int64
+	Files      int
+	Error      string
+}
+
+// StartHistory records that a download of url for chatID has started and returns the entry ID
+func (q *Queue) StartHistory(url string, chatID int64) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	res, err := q.db.Exec(`INSERT INTO history (url, chat_id, started_at, status) VALUES (?, ?, ?, ?)`,
+		url, chatID, time.Now().Unix(), StatusInProgress)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// FinishHistory records the outcome of the download started with StartHistory
+func (q *Queue) FinishHistory(id int64, status Status, bytes int64, files int, errMsg string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err := q.db.Exec(`UPDATE history SET finished_at = ?, status = ?, bytes = ?, files = ?, error = ? WHERE id = ?`,
+		time.Now().Unix(), status, bytes, files, errMsg, id)
+	return err
+}
+
+// History returns the most recent limit entries for chatID, newest first
+func (q *Queue) History(chatID int64, limit int) ([]HistoryEntry, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rows, err := q.db.Query(`SELECT id, url, chat_id, started_at, finished_at, status, bytes, files, error
+		FROM history WHERE chat_id = ? ORDER BY id DESC LIMIT ?`, chatID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var e HistoryEntry
+		var startedAt, finishedAt int64
+		if err := rows.Scan(&e.ID, &e.URL, &e.ChatID, &startedAt, &finishedAt, &e.Status, &e.Bytes, &e.Files, &e.Error); err != nil {
+			return nil, err
+		}
+		e.StartedAt = time.Unix(startedAt, 0)
+		if finishedAt > 0 {
+			e.FinishedAt = time.Unix(finishedAt, 0)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,400 @@
+// Package queue 提供基于 SQLite 的持久化下载任务队列，任务在进程重启后依然保留
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Status 表示任务所处的状态
+type Status string
+
+const (
+	StatusPending    Status = "pending"
+	StatusInProgress Status = "in_progress"
+	StatusDone       Status = "done"
+	StatusFailed     Status = "failed"
+)
+
+// Job represents a single download job stored in the queue
+type Job struct {
+	ID        int64
+	URL       string
+	ChatID    int64
+	MessageID int64 // 触发下载的原始消息，通知会作为它的回复发送
+	Attempts  int
+	Status    Status
+	Force     bool   // 为 true 时跳过已下载去重检查
+	Bot       string // 接收到请求的 bot，通知通过同一个 bot 发送
+	// Subscription 是产生该任务的订阅 ID，普通任务为 0
+	Subscription int64
+	Dir          string   // 最近一次尝试的本地输出目录，只有 Interrupted 会读取
+	Options      []string // 用户通过消息前缀选择的下载选项，例如 audio、hd
+	CreatedAt    time.Time
+}
+
+// migrations 按顺序执行，已执行的数量记录在 PRAGMA user_version 中
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS jobs (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		url        TEXT    NOT NULL,
+		chat_id    INTEGER NOT NULL,
+		attempts   INTEGER NOT NULL DEFAULT 0,
+		status     TEXT    NOT NULL DEFAULT 'pending',
+		error      TEXT    NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS jobs_status ON jobs (status, id)`,
+	`ALTER TABLE jobs ADD COLUMN force INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS downloaded (
+		url           TEXT    PRIMARY KEY,
+		downloaded_at INTEGER NOT NULL
+	)`,
+	`ALTER TABLE jobs ADD COLUMN message_id INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS history (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		url         TEXT    NOT NULL,
+		chat_id     INTEGER NOT NULL,
+		started_at  INTEGER NOT NULL,
+		finished_at INTEGER NOT NULL DEFAULT 0,
+		status      TEXT    NOT NULL,
+		bytes       INTEGER NOT NULL DEFAULT 0,
+		files       INTEGER NOT NULL DEFAULT 0,
+		error       TEXT    NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS history_chat ON history (chat_id, id)`,
+	`ALTER TABLE jobs ADD COLUMN bot TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS subscriptions (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id    INTEGER NOT NULL,
+		url        TEXT    NOT NULL,
+		bot        TEXT    NOT NULL DEFAULT '',
+		last_seen  INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL,
+		UNIQUE (chat_id, url)
+	)`,
+	`ALTER TABLE jobs ADD COLUMN subscription INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE jobs ADD COLUMN dir TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE jobs ADD COLUMN options TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS chat_settings (
+		chat_id              INTEGER PRIMARY KEY,
+		quiet_retries        INTEGER,
+		require_confirmation INTEGER,
+		quality              TEXT    NOT NULL DEFAULT '',
+		updated_at           INTEGER NOT NULL
+	)`,
+	`ALTER TABLE chat_settings ADD COLUMN language TEXT NOT NULL DEFAULT ''`,
+}
+
+// Queue is a persistent FIFO of download jobs
+type Queue struct {
+	db    *sql.DB
+	mu    sync.Mutex
+	ready chan struct{}
+}
+
+// Open opens (or creates) the queue database at path
+func Open(path string) (*Queue, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite 同一时间只允许一个写入者，限制为单连接避免 SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate queue database: %w", err)
+	}
+
+	return &Queue{db: db, ready: make(chan struct{}, 1)}, nil
+}
+
+// migrate 执行尚未应用的 schema 变更
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if _, err := db.Exec(migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the underlying database
+func (q *Queue) Close() error {
+	return q.db.Close()
+}
+
+// Ready returns a channel that receives a value whenever new jobs may be available
+func (q *Queue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// notify 非阻塞地唤醒等待中的 worker
+func (q *Queue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Requeue moves jobs left in progress by a previous run back to pending
+// and returns the number of unfinished jobs
+func (q *Queue) Requeue() (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err := q.db.Exec(`UPDATE jobs SET status = ?, updated_at = ? WHERE status = ?`,
+		StatusPending, time.Now().Unix(), StatusInProgress)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	if err := q.db.QueryRow(`SELECT COUNT(*) FROM jobs WHERE status = ?`, StatusPending).Scan(&n); err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		q.notify()
+	}
+	return n, nil
+}
+
+// Interrupted returns the jobs left in progress by a previous run. Call it before
+// Requeue, which moves them back to pending.
+func (q *Queue) Interrupted() ([]Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rows, err := q.db.Query(`SELECT id, url, chat_id, message_id, attempts, force, bot, subscription, dir, created_at
+		FROM jobs WHERE status = ? ORDER BY id`, StatusInProgress)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		var job Job
+		var createdAt int64
+		if err := rows.Scan(&job.ID, &job.URL, &job.ChatID, &job.MessageID, &job.Attempts, &job.Force, &job.Bot, &job.Subscription, &job.Dir, &createdAt); err != nil {
+			return nil, err
+		}
+		job.Status = StatusInProgress
+		job.CreatedAt = time.Unix(createdAt, 0)
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// QueuedJob is an unfinished job together with its place in the queue
+type QueuedJob struct {
+	Job
+	Position int // 在所有待下载任务中的位置，从 1 开始；下载中的任务为 0
+}
+
+// Unfinished returns the pending and in-progress jobs of chatID, oldest first
+func (q *Queue) Unfinished(chatID int64) ([]QueuedJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rows, err := q.db.Query(`SELECT id, url, chat_id, message_id, attempts, status, force, bot, subscription, created_at,
+		CASE WHEN status = ? THEN (SELECT COUNT(*) FROM jobs AS p WHERE p.status = ? AND p.id <= j.id) ELSE 0 END
+		FROM jobs AS j WHERE chat_id = ? AND status IN (?, ?) ORDER BY id`,
+		StatusPending, StatusPending, chatID, StatusPending, StatusInProgress)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []QueuedJob
+	for rows.Next() {
+		var job QueuedJob
+		var createdAt int64
+		if err := rows.Scan(&job.ID, &job.URL, &job.ChatID, &job.MessageID, &job.Attempts, &job.Status, &job.Force, &job.Bot, &job.Subscription, &createdAt, &job.Position); err != nil {
+			return nil, err
+		}
+		job.CreatedAt = time.Unix(createdAt, 0)
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// SetDir records the output directory of the running attempt of job id, so that
+// an interrupted download can be cleaned up after a restart
+func (q *Queue) SetDir(id int64, dir string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err := q.db.Exec(`UPDATE jobs SET dir = ?, updated_at = ? WHERE id = ?`, dir, time.Now().Unix(), id)
+	return err
+}
+
+// Pending returns the number of jobs waiting to be dequeued
+func (q *Queue) Pending() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var n int
+	err := q.db.QueryRow(`SELECT COUNT(*) FROM jobs WHERE status = ?`, StatusPending).Scan(&n)
+	return n, err
+}
+
+// Enqueue adds a new pending job for url requested by chatID
+func (q *Queue) Enqueue(url string, chatID int64) (*Job, error) {
+	return q.EnqueueJob(Job{URL: url, ChatID: chatID})
+}
+
+// EnqueueJob adds a new pending job using the URL, ChatID and options set on job
+func (q *Queue) EnqueueJob(job Job) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	res, err := q.db.Exec(`INSERT INTO jobs (url, chat_id, message_id, force, bot, subscription, options, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.URL, job.ChatID, job.MessageID, job.Force, job.Bot, job.Subscription, joinOptions(job.Options), StatusPending, now.Unix(), now.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	q.notify()
+	job.ID = id
+	job.Attempts = 0
+	job.Status = StatusPending
+	job.CreatedAt = now
+	return &job, nil
+}
+
+// Dequeue marks the oldest pending job that does not belong to one of skipChats as in
+// progress and returns it. It returns nil, nil when there is no such job.
+func (q *Queue) Dequeue(skipChats ...int64) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var job Job
+	var createdAt int64
+	var options string
+	query := `SELECT id, url, chat_id, message_id, attempts, force, bot, subscription, options, created_at FROM jobs WHERE status = ?`
+	args := []any{StatusPending}
+	if len(skipChats) > 0 {
+		query += ` AND chat_id NOT IN (?` + strings.Repeat(", ?", len(skipChats)-1) + `)`
+		for _, chatID := range skipChats {
+			args = append(args, chatID)
+		}
+	}
+	err = tx.QueryRow(query+` ORDER BY id LIMIT 1`, args...).Scan(&job.ID, &job.URL, &job.ChatID, &job.MessageID, &job.Attempts, &job.Force, &job.Bot, &job.Subscription, &options, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	job.Attempts++
+	job.Status = StatusInProgress
+	job.Options = splitOptions(options)
+	job.CreatedAt = time.Unix(createdAt, 0)
+
+	_, err = tx.Exec(`UPDATE jobs SET status = ?, attempts = ?, updated_at = ? WHERE id = ?`,
+		job.Status, job.Attempts, time.Now().Unix(), job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// LastFailed returns the most recent failed job of chatID whose URL has not been queued again since.
+// It returns nil, nil when there is no such job.
+func (q *Queue) LastFailed(chatID int64) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var job Job
+	var createdAt int64
+	var options string
+	err := q.db.QueryRow(`SELECT id, url, chat_id, message_id, attempts, status, force, bot, options, created_at FROM jobs AS j
+		WHERE chat_id = ? AND status = ?
+		AND NOT EXISTS (SELECT 1 FROM jobs WHERE chat_id = j.chat_id AND url = j.url AND id > j.id)
+		ORDER BY id DESC LIMIT 1`, chatID, StatusFailed).
+		Scan(&job.ID, &job.URL, &job.ChatID, &job.MessageID, &job.Attempts, &job.Status, &job.Force, &job.Bot, &options, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	job.Options = splitOptions(options)
+	job.CreatedAt = time.Unix(createdAt, 0)
+	return &job, nil
+}
+
+// joinOptions 把下载选项保存为逗号分隔的文本
+func joinOptions(options []string) string {
+	return strings.Join(options, ",")
+}
+
+// splitOptions 是 joinOptions 的逆操作，空文本返回 nil
+func splitOptions(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// Release moves a dequeued job that was never started back to pending, keeping its place in the queue
+func (q *Queue) Release(id int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err := q.db.Exec(`UPDATE jobs SET status = ?, attempts = attempts - 1, updated_at = ? WHERE id = ? AND status = ?`,
+		StatusPending, time.Now().Unix(), id, StatusInProgress)
+	return err
+}
+
+// Complete marks a job as successfully finished
+func (q *Queue) Complete(id int64) error {
+	return q.finish(id, StatusDone, "")
+}
+
+// Fail marks a job as failed and records the error message
+func (q *Queue) Fail(id int64, errMsg string) error {
+	return q.finish(id, StatusFailed, errMsg)
+}
+
+func (q *Queue) finish(id int64, status Status, errMsg string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err := q.db.Exec(`UPDATE jobs SET status = ?, error = ?, updated_at = ? WHERE id = ?`,
+		status, errMsg, time.Now().Unix(), id)
+	return err
+}
@@ -0,0 +1,121 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func openTestQueue(t *testing.T, path string) *Queue {
+	t.Helper()
+	q, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(%s) error = %v", path, err)
+	}
+	return q
+}
+
+func TestMigrateFromEarlierVersions(t *testing.T) {
+	// 每个版本都应能升级到最新，已有的数据保留，新增的列使用默认值
+	for version := 1; version < len(migrations); version++ {
+		t.Run(fmt.Sprintf("version %d", version), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "queue.db")
+			db, err := sql.Open("sqlite", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range version {
+				if _, err := db.Exec(migrations[i]); err != nil {
+					t.Fatalf("migration %d: %v", i+1, err)
+				}
+			}
+			if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(`INSERT INTO jobs (url, chat_id, status, created_at, updated_at) VALUES ('https://xhslink.com/a/old', 7, 'pending', 1, 1)`); err != nil {
+				t.Fatal(err)
+			}
+			db.Close()
+
+			q := openTestQueue(t, path)
+			defer q.Close()
+			var got int
+			if err := q.db.QueryRow(`PRAGMA user_version`).Scan(&got); err != nil || got != len(migrations) {
+				t.Fatalf("user_version = %d, %v, want %d", got, err, len(migrations))
+			}
+			job, err := q.Dequeue()
+			if err != nil || job == nil {
+				t.Fatalf("Dequeue() = %v, %v, want the job stored before the migration", job, err)
+			}
+			if job.URL != "https://xhslink.com/a/old" || job.ChatID != 7 || job.Force || job.Bot != "" || job.Options != nil {
+				t.Errorf("Dequeue() = %+v, want the old job with default values", job)
+			}
+		})
+	}
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	openTestQueue(t, path).Close()
+	// 再次打开时不会重复执行 ALTER TABLE
+	openTestQueue(t, path).Close()
+}
+
+func TestRestartResumesInterruptedJob(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	q := openTestQueue(t, path)
+	running, err := q.EnqueueJob(Job{URL: "https://xhslink.com/a/1", ChatID: 5, MessageID: 10, Bot: "bot1", Options: []string{"audio"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue("https://xhslink.com/a/2", 5); err != nil {
+		t.Fatal(err)
+	}
+	job, err := q.Dequeue()
+	if err != nil || job.ID != running.ID {
+		t.Fatalf("Dequeue() = %+v, %v, want job %d", job, err, running.ID)
+	}
+	if err := q.SetDir(job.ID, "/dl/5/half-finished"); err != nil {
+		t.Fatal(err)
+	}
+	// 进程在下载中途退出，没有调用 Complete 或 Fail
+	q.Close()
+
+	q = openTestQueue(t, path)
+	defer q.Close()
+	interrupted, err := q.Interrupted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(interrupted) != 1 {
+		t.Fatalf("Interrupted() returned %d jobs, want 1", len(interrupted))
+	}
+	got := interrupted[0]
+	if got.ID != running.ID || got.Dir != "/dl/5/half-finished" || got.ChatID != 5 || got.MessageID != 10 || got.Bot != "bot1" {
+		t.Errorf("Interrupted() = %+v, want job %d with its output directory", got, running.ID)
+	}
+
+	pending, err := q.Requeue()
+	if err != nil || pending != 2 {
+		t.Fatalf("Requeue() = %d, %v, want 2 unfinished jobs", pending, err)
+	}
+	select {
+	case <-q.Ready():
+	default:
+		t.Error("Requeue() did not wake up the workers")
+	}
+	if interrupted, _ := q.Interrupted(); len(interrupted) != 0 {
+		t.Errorf("Interrupted() after Requeue() = %+v, want none", interrupted)
+	}
+
+	// 中断的任务按原来的顺序重新下载，保留选项并累计尝试次数
+	resumed, err := q.Dequeue()
+	if err != nil || resumed == nil {
+		t.Fatalf("Dequeue() = %v, %v", resumed, err)
+	}
+	if resumed.ID != running.ID || resumed.Attempts != 2 || !slices.Equal(resumed.Options, []string{"audio"}) {
+		t.Errorf("Dequeue() = %+v, want job %d on its second attempt with options [audio]", resumed, running.ID)
+	}
+}
@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ChatSettings are the preferences a chat changed with /settings. A nil field
+// means the chat uses the global configuration.
+type ChatSettings struct {
+	QuietRetries        *bool
+	RequireConfirmation *bool
+	Quality             string // 消息没有选项前缀时使用的下载选项，为空表示不使用
+	Language            string // 回复使用的语言，为空表示使用全局配置
+}
+
+// ChatSettings returns the settings of chatID, or zero ChatSettings when it has none
+func (q *Queue) ChatSettings(chatID int64) (ChatSettings, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var s ChatSettings
+	var quiet, confirm sql.NullBool
+	err := q.db.QueryRow(`SELECT quiet_retries, require_confirmation, quality, language FROM chat_settings WHERE chat_id = ?`, chatID).
+		Scan(&quiet, &confirm, &s.Quality, &s.Language)
+	if err == sql.ErrNoRows {
+		return ChatSettings{}, nil
+	}
+	if err != nil {
+		return ChatSettings{}, err
+	}
+	if quiet.Valid {
+		s.QuietRetries = &quiet.Bool
+	}
+	if confirm.Valid {
+		s.RequireConfirmation = &confirm.Bool
+	}
+	return s, nil
+}
+
+// SaveChatSettings replaces the settings of chatID
+func (q *Queue) SaveChatSettings(chatID int64, s ChatSettings) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err := q.db.Exec(`INSERT INTO chat_settings (chat_id, quiet_retries, require_confirmation, quality, language, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chat_id) DO UPDATE SET quiet_retries = excluded.quiet_retries, require_confirmation = excluded.require_confirmation,
+		quality = excluded.quality, language = excluded.language, updated_at = excluded.updated_at`,
+		chatID, nullBool(s.QuietRetries), nullBool(s.RequireConfirmation), s.Quality, s.Language, time.Now().Unix())
+	return err
+}
+
+// nullBool 把 nil 写为 NULL
+func nullBool(b *bool) sql.NullBool {
+	if b == nil {
+		return sql.NullBool{}
+	}
+	return sql.NullBool{Bool: *b, Valid: true}
+}
@@ -0,0 +1,122 @@
+package queue
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Subscription is a creator page that is checked for new posts periodically
+type Subscription struct {
+	ID        int64
+	ChatID    int64
+	URL       string
+	Bot       string    // 发送通知使用的 bot
+	LastSeen  time.Time // 最近一次检查成功的时间，从未成功时为零值
+	CreatedAt time.Time
+}
+
+// Subscribe adds a subscription of chatID to url. It returns the existing
+// subscription and false when chatID is already subscribed to url.
+func (q *Queue) Subscribe(chatID int64, url, bot string) (*Subscription, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	res, err := q.db.Exec(`INSERT INTO subscriptions (chat_id, url, bot, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (chat_id, url) DO NOTHING`, chatID, url, bot, now.Unix())
+	if err != nil {
+		return nil, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+	if n == 0 {
+		sub, err := scanSubscription(q.db.QueryRow(`SELECT id, chat_id, url, bot, last_seen, created_at FROM subscriptions
+			WHERE chat_id = ? AND url = ?`, chatID, url))
+		return sub, false, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, false, err
+	}
+	return &Subscription{ID: id, ChatID: chatID, URL: url, Bot: bot, CreatedAt: now}, true, nil
+}
+
+// Unsubscribe removes the subscription of chatID to url and returns it,
+// or nil, nil when there is no such subscription
+func (q *Queue) Unsubscribe(chatID int64, url string) (*Subscription, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	sub, err := scanSubscription(q.db.QueryRow(`SELECT id, chat_id, url, bot, last_seen, created_at FROM subscriptions
+		WHERE chat_id = ? AND url = ?`, chatID, url))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := q.db.Exec(`DELETE FROM subscriptions WHERE id = ?`, sub.ID); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Subscriptions returns the subscriptions of chatID, or of every chat when chatID is 0
+func (q *Queue) Subscriptions(chatID int64) ([]Subscription, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rows, err := q.db.Query(`SELECT id, chat_id, url, bot, last_seen, created_at FROM subscriptions
+		WHERE ? = 0 OR chat_id = ? ORDER BY id`, chatID, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []Subscription
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, *sub)
+	}
+	return subs, rows.Err()
+}
+
+// SubscriptionBusy reports whether a job of subscription id is still pending or in progress
+func (q *Queue) SubscriptionBusy(id int64) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var n int
+	err := q.db.QueryRow(`SELECT COUNT(*) FROM jobs WHERE subscription = ? AND status IN (?, ?)`,
+		id, StatusPending, StatusInProgress).Scan(&n)
+	return n > 0, err
+}
+
+// MarkSubscriptionSeen records that subscription id was checked successfully at t
+func (q *Queue) MarkSubscriptionSeen(id int64, t time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err := q.db.Exec(`UPDATE subscriptions SET last_seen = ? WHERE id = ?`, t.Unix(), id)
+	return err
+}
+
+// scanSubscription 读取一行 subscriptions 记录
+func scanSubscription(row interface{ Scan(...any) error }) (*Subscription, error) {
+	var sub Subscription
+	var lastSeen, createdAt int64
+	if err := row.Scan(&sub.ID, &sub.ChatID, &sub.URL, &sub.Bot, &lastSeen, &createdAt); err != nil {
+		return nil, err
+	}
+	if lastSeen > 0 {
+		sub.LastSeen = time.Unix(lastSeen, 0)
+	}
+	sub.CreatedAt = time.Unix(createdAt, 0)
+	return &sub, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter limits how many downloads each chat may start per minute.
+// A nil *RateLimiter allows everything.
+type RateLimiter struct {
+	limit    rate.Limit
+	burst    int
+	limiters sync.Map // chatID -> *rate.Limiter
+}
+
+// NewRateLimiter returns a limiter allowing perMinute downloads per chat, or nil if perMinute <= 0
+func NewRateLimiter(perMinute int) *RateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		limit: rate.Every(time.Minute / time.Duration(perMinute)),
+		burst: perMinute,
+	}
+}
+
+// limiter 返回 chatID 对应的令牌桶，不存在时创建
+func (r *RateLimiter) limiter(chatID int64) *rate.Limiter {
+	if l, ok := r.limiters.Load(chatID); ok {
+		return l.(*rate.Limiter)
+	}
+	l, _ := r.limiters.LoadOrStore(chatID, rate.NewLimiter(r.limit, r.burst))
+	return l.(*rate.Limiter)
+}
+
+// Allow reports whether chatID may start another download now, consuming a token if so
+func (r *RateLimiter) Allow(chatID int64) bool {
+	if r == nil {
+		return true
+	}
+	return r.limiter(chatID).Allow()
+}
+
+// Delay returns how long chatID has to wait until the next download is allowed
+func (r *RateLimiter) Delay(chatID int64) time.Duration {
+	if r == nil {
+		return 0
+	}
+	res := r.limiter(chatID).Reserve()
+	defer res.Cancel()
+	return res.Delay()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"github.com/deckvig/telegram-bot/download"
+	"github.com/deckvig/telegram-bot/queue"
+)
+
+// chatPreferences 是一个 chat 实际使用的设置：/settings 中修改过的值，其余取全局配置
+type chatPreferences struct {
+	QuietRetries        bool
+	RequireConfirmation bool
+	Quality             string // 消息没有选项前缀时使用的下载选项
+	Language            string // 回复使用的语言
+}
+
+// preferencesFor 返回 chatID 的设置，读取失败时使用全局配置
+func preferencesFor(chatID int64) chatPreferences {
+	prefs := chatPreferences{QuietRetries: cfg.QuietRetries, RequireConfirmation: cfg.RequireConfirmation, Language: cfg.Language}
+	s, err := jobQueue.ChatSettings(chatID)
+	if err != nil {
+		logger.Error("failed to read chat settings", "chat_id", chatID, "error", err)
+		return prefs
+	}
+	if s.QuietRetries != nil {
+		prefs.QuietRetries = *s.QuietRetries
+	}
+	if s.RequireConfirmation != nil {
+		prefs.RequireConfirmation = *s.RequireConfirmation
+	}
+	prefs.Quality = s.Quality
+	// 忽略已经不再支持的语言
+	if _, ok := languageNames[s.Language]; ok {
+		prefs.Language = s.Language
+	}
+	return prefs
+}
+
+// qualityChoices 是"默认画质"按钮依次切换的选项，空字符串表示不使用选项
+var qualityChoices = []string{"", download.OptionHD, download.OptionAudio}
+
+// qualityLabel 返回设置菜单中显示的画质
+func qualityLabel(l lang, option string) string {
+	if option == "" {
+		return l.T("默认")
+	}
+	return l.T(optionDescriptions[option])
+}
+
+// onOff 返回开关的显示文本
+func onOff(l lang, b bool) string {
+	if b {
+		return l.T("开")
+	}
+	return l.T("关")
+}
+
+// settingsKeyboard 根据当前设置生成 /settings 菜单的按钮，点击后由 handleSettingsCallback 处理。
+// 按钮使用 prefs 中的语言显示，切换语言后整个菜单随之改变
+func settingsKeyboard(prefs chatPreferences) InlineKeyboardMarkup {
+	l := lang(prefs.Language)
+	return InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{
+		{{Text: l.T("重试通知: ") + onOff(l, !prefs.QuietRetries), CallbackData: "set:quiet"}},
+		{{Text: l.T("下载前确认: ") + onOff(l, prefs.RequireConfirmation), CallbackData: "set:confirm"}},
+		{{Text: l.T("默认画质: ") + qualityLabel(l, prefs.Quality), CallbackData: "set:quality"}},
+		{{Text: "语言 / Language: " + languageNames[prefs.Language], CallbackData: "set:language"}},
+		{{Text: l.T("恢复默认"), CallbackData: "set:reset"}},
+	}}
+}
+
+// handleSettingsCommand 回复当前 chat 的设置菜单
+func handleSettingsCommand(bot *Bot, msg *Message, args string) {
+	prefs := preferencesFor(msg.Chat.ID)
+	if _, err := bot.sendMessageWithRetry(map[string]interface{}{
+		"chat_id":                     msg.Chat.ID,
+		"text":                        lang(prefs.Language).T("当前 chat 的设置，点击按钮修改："),
+		"reply_to_message_id":         msg.MessageID,
+		"allow_sending_without_reply": true,
+		"reply_markup":                settingsKeyboard(prefs),
+	}); err != nil {
+		bot.logger.Warn("failed to send settings menu", "chat_id", msg.Chat.ID, "error", err)
+	}
+}
+
+// handleSettingsCallback 切换 field 对应的设置，保存后更新菜单的按钮
+func handleSettingsCallback(bot *Bot, query *CallbackQuery, field string) {
+	chatID := query.Message.Chat.ID
+	prefs := preferencesFor(chatID)
+	l := lang(prefs.Language)
+	s, err := jobQueue.ChatSettings(chatID)
+	if err != nil {
+		logger.Error("failed to read chat settings", "chat_id", chatID, "error", err)
+		bot.answerCallbackQuery(query.ID, l.T("读取设置失败，请稍后重试。"))
+		return
+	}
+
+	switch field {
+	case "quiet":
+		quiet := !prefs.QuietRetries
+		s.QuietRetries = &quiet
+	case "confirm":
+		confirm := !prefs.RequireConfirmation
+		s.RequireConfirmation = &confirm
+	case "quality":
+		next := 0
+		for i, option := range qualityChoices {
+			if option == prefs.Quality {
+				next = (i + 1) % len(qualityChoices)
+			}
+		}
+		s.Quality = qualityChoices[next]
+	case "language":
+		next := 0
+		for i, code := range languageChoices {
+			if code == prefs.Language {
+				next = (i + 1) % len(languageChoices)
+			}
+		}
+		s.Language = languageChoices[next]
+	case "reset":
+		s = queue.ChatSettings{}
+	default:
+		bot.answerCallbackQuery(query.ID, "")
+		return
+	}
+
+	if err := jobQueue.SaveChatSettings(chatID, s); err != nil {
+		logger.Error("failed to save chat settings", "chat_id", chatID, "error", err)
+		bot.answerCallbackQuery(query.ID, l.T("保存设置失败，请稍后重试。"))
+		return
+	}
+	logger.Info("chat settings changed", "chat_id", chatID, "field", field, "user_id", query.From.ID)
+	prefs = preferencesFor(chatID)
+	l = lang(prefs.Language)
+	bot.answerCallbackQuery(query.ID, l.T("已保存"))
+	if field == "language" || field == "reset" {
+		// 语言可能改变，菜单的说明文字和按钮一起更新
+		if _, err := bot.callAPI("editMessageText", map[string]interface{}{
+			"chat_id":      chatID,
+			"message_id":   query.Message.MessageID,
+			"text":         l.T("当前 chat 的设置，点击按钮修改："),
+			"reply_markup": settingsKeyboard(prefs),
+		}); err != nil {
+			bot.logger.Warn("failed to edit settings menu", "chat_id", chatID, "message_id", query.Message.MessageID, "error", err)
+		}
+		return
+	}
+	bot.editMessageReplyMarkup(chatID, query.Message.MessageID, settingsKeyboard(prefs))
+}
+
+// defaultOptions 在消息没有选项前缀时使用 chat 设置的默认画质
+func defaultOptions(options []string, prefs chatPreferences) []string {
+	if len(options) > 0 || prefs.Quality == "" {
+		return options
+	}
+	return []string{prefs.Quality}
+}
+
+// editMessageReplyMarkup replaces the inline keyboard of a message
+func (b *Bot) editMessageReplyMarkup(chatID, messageID int64, markup InlineKeyboardMarkup) error {
+	_, err := b.callAPI("editMessageReplyMarkup", map[string]interface{}{
+		"chat_id":      chatID,
+		"message_id":   messageID,
+		"reply_markup": markup,
+	})
+	if err != nil {
+		b.logger.Warn("failed to edit message keyboard", "chat_id", chatID, "message_id", messageID, "error", err)
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/deckvig/telegram-bot/download"
+	"golang.org/x/sync/singleflight"
+)
+
+// 同一 URL 同时只运行一次下载，并发的请求共享同一个结果
+var (
+	downloadGroup singleflight.Group
+	flightsMu     sync.Mutex
+	flights       = make(map[string]*flight)
+)
+
+// flight 是一次共享的下载。下载运行在与任务无关的 ctx 上，某个任务被取消不会影响其他任务，
+// 最后一个等待的任务离开时才取消下载；最后一个任务用完结果后才清理下载目录
+type flight struct {
+	ctx     context.Context // 不随任何一个任务取消
+	cancel  context.CancelCauseFunc
+	refs    int       // 还没有调用 release 的任务数
+	waiters []*waiter // 正在等待结果的任务
+	dir     string    // 已经创建的输出目录，之后加入的任务也要记录
+}
+
+// waiter 是等待共享下载的一个任务，进度和输出目录分别转发给它自己
+type waiter struct {
+	onProgress download.ProgressFunc
+	onDir      func(dir string)
+}
+
+// newFlight 从第一个任务的 ctx 创建共享下载，保留其中的代理、archive 等设置，
+// 但不继承它的取消和期限；进度和输出目录改为转发给所有等待的任务
+func newFlight(ctx context.Context) *flight {
+	f := &flight{}
+	f.ctx, f.cancel = context.WithCancelCause(context.WithoutCancel(ctx))
+	f.ctx = download.WithProgress(f.ctx, f.progress)
+	f.ctx = download.WithOutputDirFunc(f.ctx, f.setDir)
+	return f
+}
+
+// progress 把 gallery-dl 的输出转发给所有等待的任务
+func (f *flight) progress(line string) {
+	flightsMu.Lock()
+	waiters := slices.Clone(f.waiters)
+	flightsMu.Unlock()
+	for _, w := range waiters {
+		if w.onProgress != nil {
+			w.onProgress(line)
+		}
+	}
+}
+
+// setDir 记录输出目录，并通知所有等待的任务
+func (f *flight) setDir(dir string) {
+	flightsMu.Lock()
+	f.dir = dir
+	waiters := slices.Clone(f.waiters)
+	flightsMu.Unlock()
+	for _, w := range waiters {
+		if w.onDir != nil {
+			w.onDir(dir)
+		}
+	}
+}
+
+// sharedDownload 下载 url，同一时刻相同 key 的请求只执行一次 downloadAttempt。
+// onProgress 和 onDir 只接收当前任务需要的进度和输出目录，不会放进共享下载的 ctx。
+// 调用方用完结果后必须调用返回的 release，传入需要清理的目录（没有时传空字符串）
+func sharedDownload(ctx context.Context, bot *Bot, key, url string, onProgress download.ProgressFunc, onDir func(dir string)) (download.Result, func(dir string), error) {
+	w := &waiter{onProgress: onProgress, onDir: onDir}
+
+	flightsMu.Lock()
+	f := flights[key]
+	if f == nil {
+		f = newFlight(ctx)
+		flights[key] = f
+	}
+	// 在同一把锁内加入 flight，保证计数和 singleflight 中的调用是同一次
+	ch := downloadGroup.DoChan(key, func() (interface{}, error) {
+		result, err := downloadAttempt(f.ctx, bot, url)
+		flightsMu.Lock()
+		delete(flights, key)
+		downloadGroup.Forget(key)
+		flightsMu.Unlock()
+		f.cancel(nil)
+		return result, err
+	})
+	f.refs++
+	f.waiters = append(f.waiters, w)
+	dir := f.dir
+	flightsMu.Unlock()
+	if dir != "" && onDir != nil {
+		onDir(dir)
+	}
+
+	release := func(dir string) {
+		flightsMu.Lock()
+		f.refs--
+		last := f.refs == 0
+		flightsMu.Unlock()
+		if last {
+			scheduleCleanup(dir)
+		}
+	}
+
+	select {
+	case res := <-ch:
+		f.leave(w, nil)
+		if res.Shared {
+			logger.Info("shared concurrent download", "url", url)
+		}
+		result, _ := res.Val.(download.Result)
+		return result, release, res.Err
+	case <-ctx.Done():
+		f.leave(w, context.Cause(ctx))
+		// 不再等待，但仍要在下载结束后释放引用，否则其他任务的下载目录不会被清理
+		go func() {
+			res := <-ch
+			result, _ := res.Val.(download.Result)
+			dir := ""
+			if res.Err == nil {
+				dir = result.Dir
+			}
+			release(dir)
+		}()
+		return download.Result{}, func(string) {}, ctx.Err()
+	}
+}
+
+// leave 停止向 w 转发进度。cause 不为 nil 表示任务不再等待，是最后一个等待的任务时取消下载
+func (f *flight) leave(w *waiter, cause error) {
+	flightsMu.Lock()
+	f.waiters = slices.DeleteFunc(f.waiters, func(other *waiter) bool { return other == w })
+	last := len(f.waiters) == 0
+	flightsMu.Unlock()
+	if cause != nil && last {
+		f.cancel(cause)
+	}
+}
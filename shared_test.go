@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deckvig/telegram-bot/download"
+	"github.com/deckvig/telegram-bot/queue"
+)
+
+// sharedResult 是一次 sharedDownload 调用的返回值
+type sharedResult struct {
+	result  download.Result
+	release func(dir string)
+	err     error
+}
+
+// blockingDownloader 在 finish 关闭或 ctx 取消前一直阻塞，用来观察共享下载的 ctx
+type blockingDownloader struct {
+	started chan context.Context
+	finish  chan struct{}
+}
+
+func newBlockingDownloader() *blockingDownloader {
+	return &blockingDownloader{started: make(chan context.Context, 1), finish: make(chan struct{})}
+}
+
+func (d *blockingDownloader) Download(ctx context.Context, url string) (download.Result, error) {
+	d.started <- ctx
+	select {
+	case <-d.finish:
+		return download.Result{Dir: "/dl/shared"}, nil
+	case <-ctx.Done():
+		return download.Result{}, ctx.Err()
+	}
+}
+
+// recorder 记录一个任务收到的进度和输出目录
+type recorder struct {
+	mu    sync.Mutex
+	lines []string
+	dirs  []string
+}
+
+func (r *recorder) progress(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = append(r.lines, line)
+}
+
+func (r *recorder) dir(dir string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dirs = append(r.dirs, dir)
+}
+
+// startShared 在后台调用 sharedDownload，等到调用加入 key 的 flight 后返回
+func startShared(t *testing.T, ctx context.Context, bot *Bot, key string, rec *recorder, waiters int) <-chan sharedResult {
+	t.Helper()
+	done := make(chan sharedResult, 1)
+	go func() {
+		result, release, err := sharedDownload(ctx, bot, key, "https://xhslink.com/a/AbC", rec.progress, rec.dir)
+		done <- sharedResult{result, release, err}
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		flightsMu.Lock()
+		f := flights[key]
+		joined := f != nil && len(f.waiters) == waiters
+		flightsMu.Unlock()
+		if joined {
+			return done
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sharedDownload did not join the flight for %q", key)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitRefs 等待 f 只剩 refs 个引用，已经离开的任务在后台 release
+func waitRefs(t *testing.T, f *flight, refs int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		flightsMu.Lock()
+		got := f.refs
+		flightsMu.Unlock()
+		if got == refs {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("flight has %d references, want %d", got, refs)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func currentFlight(key string) *flight {
+	flightsMu.Lock()
+	defer flightsMu.Unlock()
+	return flights[key]
+}
+
+func TestSharedDownloadOutlivesFirstCaller(t *testing.T) {
+	withTestConfig(t).RetentionMinutes = -1
+	d := newBlockingDownloader()
+	bot := &Bot{downloader: d}
+	const key = "outlives"
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	var rec1, rec2 recorder
+	first := startShared(t, ctx1, bot, key, &rec1, 1)
+	flightCtx := <-d.started
+	f := currentFlight(key)
+	f.setDir("/dl/shared")
+	second := startShared(t, context.Background(), bot, key, &rec2, 2)
+	f.progress("xiaohongshu/1.jpg")
+
+	cancel1()
+	if res := <-first; !errors.Is(res.err, context.Canceled) {
+		t.Fatalf("first caller error = %v, want context.Canceled", res.err)
+	}
+	if err := flightCtx.Err(); err != nil {
+		t.Fatalf("shared download cancelled with the first caller: %v", err)
+	}
+	close(d.finish)
+	res := <-second
+	if res.err != nil || res.result.Dir != "/dl/shared" {
+		t.Fatalf("second caller = %+v, %v, want the shared result", res.result, res.err)
+	}
+	// 第一个任务离开后仍持有引用，直到下载结束
+	waitRefs(t, f, 1)
+	res.release(res.result.Dir)
+
+	// 每个任务都收到自己的进度和输出目录，包括在目录创建后才加入的任务
+	for i, rec := range []*recorder{&rec1, &rec2} {
+		if !slices.Equal(rec.lines, []string{"xiaohongshu/1.jpg"}) || !slices.Equal(rec.dirs, []string{"/dl/shared"}) {
+			t.Errorf("caller %d got lines %q and dirs %q", i+1, rec.lines, rec.dirs)
+		}
+	}
+}
+
+func TestSharedDownloadCancelledByLastCaller(t *testing.T) {
+	withTestConfig(t).RetentionMinutes = -1
+	d := newBlockingDownloader()
+	bot := &Bot{downloader: d}
+	const key = "last caller"
+
+	ctx1, cancel1 := context.WithCancelCause(context.Background())
+	ctx2, cancel2 := context.WithCancelCause(context.Background())
+	var rec1, rec2 recorder
+	first := startShared(t, ctx1, bot, key, &rec1, 1)
+	flightCtx := <-d.started
+	f := currentFlight(key)
+	second := startShared(t, ctx2, bot, key, &rec2, 2)
+
+	cancel1(errShutdown)
+	<-first
+	if flightCtx.Err() != nil {
+		t.Fatal("shared download cancelled while a caller is still waiting")
+	}
+	cancel2(errShutdown)
+	<-second
+	select {
+	case <-flightCtx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("shared download not cancelled after every caller left")
+	}
+	if cause := context.Cause(flightCtx); !errors.Is(cause, errShutdown) {
+		t.Errorf("shared download cause = %v, want errShutdown", cause)
+	}
+	waitRefs(t, f, 0)
+}
+
+func TestFlightsNotSharedAcrossChats(t *testing.T) {
+	const url = "https://www.xiaohongshu.com/explore/6650a1b2000000001e03c4d5"
+	tests := []struct {
+		name  string
+		mode  string
+		chats []int64
+		// want 是实际执行的下载次数
+		want int
+	}{
+		// gallery-dl 的输出目录和代理按 chat 区分
+		{"gallery-dl, two chats", download.ModeGalleryDL, []int64{5, 6}, 2},
+		{"gallery-dl, same chat", download.ModeGalleryDL, []int64{5, 5}, 1},
+		{"backend, two chats", download.ModeBackend, []int64{5, 6}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &gateDownloader{started: make(chan string, len(tt.chats)), finish: map[string]chan struct{}{url: make(chan struct{})}}
+			_, b := startTestBot(t, d, func(c *Config) {
+				c.DownloadMode = tt.mode
+				c.ChatProxies = map[int64]string{6: "socks5://127.0.0.1:1080"}
+			})
+			for i, chatID := range tt.chats {
+				if _, err := jobQueue.EnqueueJob(queue.Job{URL: url, ChatID: chatID, MessageID: int64(10 + i), Bot: b.ID}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			started := 0
+			timeout := time.After(5 * time.Second)
+			for started < tt.want {
+				select {
+				case <-d.started:
+					started++
+				case <-timeout:
+					t.Fatalf("%d downloads started, want %d", started, tt.want)
+				}
+			}
+			select {
+			case <-d.started:
+				t.Errorf("more than %d downloads started", tt.want)
+			case <-time.After(100 * time.Millisecond):
+			}
+			close(d.finish[url])
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// maxMessageLength 是 Telegram 单条消息的长度上限，按 UTF-16 编码单元计算
+const maxMessageLength = 4096
+
+// utf16Len 返回 s 在 UTF-16 编码下的长度，与 Telegram 计算消息长度的方式一致
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// splitMessage 按行把 text 拆分为不超过 limit 的多段。
+// 单行超长时在空白处断开，避免把 URL 拆成两半；没有空白时才按字符强制断开
+func splitMessage(text string, limit int) []string {
+	if utf16Len(text) <= limit {
+		return []string{text}
+	}
+
+	var chunks []string
+	var current strings.Builder
+	currentLen := 0
+	flush := func() {
+		if currentLen > 0 {
+			chunks = append(chunks, strings.TrimRight(current.String(), "\n"))
+			current.Reset()
+			currentLen = 0
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		for _, piece := range splitLine(line, limit) {
+			n := utf16Len(piece)
+			if currentLen+n > limit {
+				flush()
+			}
+			current.WriteString(piece)
+			currentLen += n
+		}
+	}
+	flush()
+	return chunks
+}
+
+// splitLine 把超过 limit 的一行拆为多段，优先在空白处断开
+func splitLine(line string, limit int) []string {
+	var pieces []string
+	for utf16Len(line) > limit {
+		// 找到不超过 limit 的最长前缀
+		cut, n := 0, 0
+		for i, r := range line {
+			if n+utf16.RuneLen(r) > limit {
+				cut = i
+				break
+			}
+			n += utf16.RuneLen(r)
+		}
+		if i := strings.LastIndexFunc(line[:cut], unicode.IsSpace); i > 0 {
+			cut = i + 1
+		}
+		pieces = append(pieces, line[:cut])
+		line = line[cut:]
+	}
+	return append(pieces, line)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/deckvig/telegram-bot/urls"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		wantLens []int // 每段的 UTF-16 长度
+	}{
+		{"short", "已加入下载队列", []int{7}},
+		{"exactly the limit", strings.Repeat("a", maxMessageLength), []int{maxMessageLength}},
+		// 100 行，每行 99 个字符加换行，共 10,000 个字符；每段最多容纳 40 行，段末的换行被去掉
+		{"10,000 characters in lines", strings.Repeat(strings.Repeat("x", 99)+"\n", 100), []int{3999, 3999, 1999}},
+		{"10,000 characters without whitespace", strings.Repeat("a", 10000), []int{4096, 4096, 1808}},
+		// emoji 在 UTF-16 中占两个编码单元
+		{"surrogate pairs", strings.Repeat("😆", 5000), []int{4096, 4096, 1808}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := splitMessage(tt.text, maxMessageLength)
+			var lens []int
+			for _, c := range chunks {
+				lens = append(lens, utf16Len(c))
+			}
+			if !slices.Equal(lens, tt.wantLens) {
+				t.Errorf("chunk lengths = %v, want %v", lens, tt.wantLens)
+			}
+			if joined := strings.Join(chunks, ""); strings.ReplaceAll(joined, "\n", "") != strings.ReplaceAll(tt.text, "\n", "") {
+				t.Error("chunks do not add up to the original text")
+			}
+		})
+	}
+}
+
+func TestSplitMessageKeepsURLs(t *testing.T) {
+	var lines, spaced strings.Builder
+	var want []string
+	for i := 0; lines.Len() < 10000; i++ {
+		u := fmt.Sprintf("https://www.xiaohongshu.com/explore/%024x?xsec_token=AB%04d", i, i)
+		want = append(want, u)
+		fmt.Fprintf(&lines, "%d. %s\n", i+1, u)
+		fmt.Fprintf(&spaced, "%s ", u)
+	}
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"one url per line", lines.String()},
+		{"urls separated by spaces", spaced.String()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := splitMessage(tt.text, maxMessageLength)
+			if len(chunks) < 3 {
+				t.Fatalf("got %d chunks for %d characters, want at least 3", len(chunks), utf16Len(tt.text))
+			}
+			var got []string
+			for i, c := range chunks {
+				if n := utf16Len(c); n > maxMessageLength {
+					t.Errorf("chunk %d has %d UTF-16 units, limit is %d", i, n, maxMessageLength)
+				}
+				got = append(got, urls.Extract(c)...)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("URLs in chunks differ from the input: got %d, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestSendReplySplitsLongText(t *testing.T) {
+	f := newFakeTelegram(t)
+	b := newTestBot(t, f, testConfig(), nil)
+
+	id, err := b.sendReply(5, 9, strings.Repeat(strings.Repeat("x", 99)+"\n", 100))
+	if err != nil {
+		t.Fatalf("sendReply() error = %v", err)
+	}
+	texts := f.sentTexts()
+	if len(texts) != 3 {
+		t.Fatalf("sent %d messages, want 3", len(texts))
+	}
+	if id != 101 {
+		t.Errorf("sendReply() = %d, want the first message ID 101", id)
+	}
+	for i, text := range texts {
+		if n := utf16Len(text); n > maxMessageLength {
+			t.Errorf("message %d has %d UTF-16 units, limit is %d", i, n, maxMessageLength)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// startTime 是进程启动的时间，在 main 中设置
+var startTime time.Time
+
+// 自启动以来的全局计数，供 /stats 使用
+var (
+	messagesReceived atomic.Int64 // 收到的消息数，包括命令
+	urlsExtracted    atomic.Int64 // 从消息中提取到的 URL 数
+	attemptNanos     atomic.Int64 // 所有下载尝试的总耗时
+	attemptCount     atomic.Int64 // 下载尝试次数
+)
+
+// recordAttempt 记录一次下载尝试的耗时
+func recordAttempt(d time.Duration) {
+	attemptNanos.Add(int64(d))
+	attemptCount.Add(1)
+}
+
+// handleStatsCommand 向 ADMIN_CHAT_ID 回复进程的运行时长和全局计数
+func handleStatsCommand(bot *Bot, msg *Message, args string) {
+	if !isAdminChat(msg.Chat.ID) {
+		bot.sendReply(msg.Chat.ID, msg.MessageID, langFor(msg.Chat.ID).T("该命令仅限管理员使用。"))
+		return
+	}
+
+	pending, err := jobQueue.Pending()
+	if err != nil {
+		logger.Error("failed to count pending jobs", "error", err)
+	}
+	s := downloader.Stats()
+	var avg time.Duration
+	if n := attemptCount.Load(); n > 0 {
+		avg = time.Duration(attemptNanos.Load() / n)
+	}
+
+	bot.sendReply(msg.Chat.ID, msg.MessageID, fmt.Sprintf(
+		"运行时长: %s\n消息: %d\nURL: %d\n下载: 成功 %d，失败 %d，下载中 %d，排队中 %d\n平均每次尝试耗时: %s（共 %d 次）\ngoroutine: %d",
+		time.Since(startTime).Round(time.Second), messagesReceived.Load(), urlsExtracted.Load(),
+		s.Succeeded, s.Failed, s.Running, pending+s.Waiting,
+		avg.Round(time.Millisecond), attemptCount.Load(), runtime.NumGoroutine()))
+}
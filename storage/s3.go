@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3Options 配置 S3 兼容的存储。凭证按 AWS SDK 的默认方式读取，
+// 例如 AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY 环境变量或 ~/.aws/credentials
+type S3Options struct {
+	Bucket    string
+	Region    string        // 为空时使用 AWS_REGION 等默认配置
+	Endpoint  string        // S3 兼容服务（MinIO、R2 等）的地址，为空时使用 AWS
+	PathStyle bool          // 使用 endpoint/bucket/key 形式的地址，MinIO 通常需要
+	Prefix    string        // 所有 key 的前缀，例如 telegram/
+	Presign   time.Duration // 返回有效期为此时长的预签名下载链接，0 时返回 s3://bucket/key
+}
+
+// S3Storage 把文件上传到 S3 兼容的 bucket
+type S3Storage struct {
+	client  *s3.Client
+	presign *s3.PresignClient
+	opts    S3Options
+}
+
+// NewS3 creates an S3Storage from opts and the default AWS configuration sources
+func NewS3(ctx context.Context, opts S3Options) (*S3Storage, error) {
+	var loadOpts []func(*config.LoadOptions) error
+	if opts.Region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(opts.Region))
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+		}
+		o.UsePathStyle = opts.PathStyle
+	})
+	return &S3Storage{client: client, presign: s3.NewPresignClient(client), opts: opts}, nil
+}
+
+// Put uploads localPath to Prefix+remoteKey in the bucket
+func (s *S3Storage) Put(ctx context.Context, localPath, remoteKey string) (string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	key := path.Join(s.opts.Prefix, remoteKey)
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    aws.String(key),
+		Body:   f,
+	}
+	if contentType := mime.TypeByExtension(filepath.Ext(localPath)); contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if _, err := s.client.PutObject(ctx, input); err != nil {
+		return "", fmt.Errorf("failed to upload %s to s3://%s/%s: %w", filepath.Base(localPath), s.opts.Bucket, key, err)
+	}
+
+	if s.opts.Presign <= 0 {
+		return fmt.Sprintf("s3://%s/%s", s.opts.Bucket, key), nil
+	}
+	req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(s.opts.Presign))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign s3://%s/%s: %w", s.opts.Bucket, key, err)
+	}
+	return req.URL, nil
+}
@@ -0,0 +1,11 @@
+// Package storage 把下载完成的文件上传到远程存储（例如 S3 兼容的对象存储）
+package storage
+
+import "context"
+
+// Storage stores local files under a remote key
+type Storage interface {
+	// Put uploads the file at localPath as remoteKey and returns a link to it
+	// that can be shown to the user
+	Put(ctx context.Context, localPath, remoteKey string) (string, error)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/deckvig/telegram-bot/download"
+	"github.com/deckvig/telegram-bot/queue"
+	"github.com/deckvig/telegram-bot/storage"
+)
+
+// fileStorage 是 S3_BUCKET 配置的远程存储，为空时下载的文件只保存在本地
+var fileStorage storage.Storage
+
+// storeFiles 把下载结果中的文件上传到 fileStorage，并回复得到的链接。
+// key 为 <下载目录名>/<相对路径>，同一次下载的文件放在一起，不同下载之间不会冲突
+func storeFiles(ctx context.Context, bot *Bot, job *queue.Job, result download.Result) {
+	l := langFor(job.ChatID)
+	var links []string
+	for _, file := range result.Files {
+		rel, err := filepath.Rel(result.Dir, file)
+		if err != nil {
+			rel = filepath.Base(file)
+		}
+		key := path.Join(filepath.Base(result.Dir), filepath.ToSlash(rel))
+		link, err := fileStorage.Put(ctx, file, key)
+		if err != nil {
+			logger.Error("failed to upload file to storage", "file", file, "key", key, "job_id", job.ID, "error", err)
+			notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("文件上传到存储失败: %v", err))
+			continue
+		}
+		logger.Info("uploaded file to storage", "file", file, "key", key, "job_id", job.ID)
+		links = append(links, link)
+	}
+	if len(links) > 0 {
+		notifyResult(bot, job.ChatID, job.MessageID, l.Sprintf("已上传 %d 个文件：\n%s", len(links), strings.Join(links, "\n")))
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/deckvig/telegram-bot/download"
+	"github.com/deckvig/telegram-bot/queue"
+)
+
+// subscriptionArchive 返回订阅使用的 gallery-dl archive 文件，记录已经下载过的作品
+func subscriptionArchive(id int64) string {
+	return filepath.Join(cfg.DownloadDir, "archives", fmt.Sprintf("subscription-%d.sqlite3", id))
+}
+
+// subscriptionURL 从命令参数中取出唯一的链接并规范化
+func subscriptionURL(bot *Bot, msg *Message, args, usage string) (string, bool) {
+	found := urlExtractor.Extract(args)
+	if len(found) != 1 {
+		bot.sendReply(msg.Chat.ID, msg.MessageID, usage)
+		return "", false
+	}
+	return normalizeForDownload(found[0]), true
+}
+
+// handleSubscribeCommand 订阅作者主页，定期下载新作品；不带参数时列出当前 chat 的订阅
+func handleSubscribeCommand(bot *Bot, msg *Message, args string) {
+	l := langFor(msg.Chat.ID)
+	if args == "" {
+		listSubscriptions(bot, msg)
+		return
+	}
+	// 只有 gallery-dl 支持 archive，后端模式无法判断哪些作品已经下载过
+	if cfg.DownloadMode != download.ModeGalleryDL {
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.T("订阅功能仅支持 gallery-dl 下载模式。"))
+		return
+	}
+	url, ok := subscriptionURL(bot, msg, args, l.T("用法：/subscribe <作者主页链接>"))
+	if !ok {
+		return
+	}
+	if download.DetectPlatform(url) == download.PlatformUnknown {
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("该链接不属于支持的平台（小红书、抖音、B站）：\n%s", url))
+		return
+	}
+
+	sub, added, err := jobQueue.Subscribe(msg.Chat.ID, url, bot.ID)
+	if err != nil {
+		logger.Error("failed to add subscription", "url", url, "chat_id", msg.Chat.ID, "error", err)
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("订阅失败: %v", err))
+		return
+	}
+	if !added {
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("已经订阅过：\n%s", url))
+		return
+	}
+
+	logger.Info("subscription added", "subscription", sub.ID, "url", url, "chat_id", msg.Chat.ID)
+	bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("已订阅，每 %s 检查一次新作品，现在开始第一次下载：\n%s", cfg.SubscribeInterval, url))
+	checkSubscription(*sub)
+}
+
+// handleUnsubscribeCommand 取消当前 chat 对某个链接的订阅
+func handleUnsubscribeCommand(bot *Bot, msg *Message, args string) {
+	l := langFor(msg.Chat.ID)
+	url, ok := subscriptionURL(bot, msg, args, l.T("用法：/unsubscribe <作者主页链接>"))
+	if !ok {
+		return
+	}
+
+	sub, err := jobQueue.Unsubscribe(msg.Chat.ID, url)
+	if err != nil {
+		logger.Error("failed to remove subscription", "url", url, "chat_id", msg.Chat.ID, "error", err)
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("取消订阅失败: %v", err))
+		return
+	}
+	if sub == nil {
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("没有订阅过：\n%s", url))
+		return
+	}
+
+	if err := os.Remove(subscriptionArchive(sub.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn("failed to remove subscription archive", "subscription", sub.ID, "error", err)
+	}
+	logger.Info("subscription removed", "subscription", sub.ID, "url", url, "chat_id", msg.Chat.ID)
+	bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("已取消订阅：\n%s", url))
+}
+
+// listSubscriptions 回复当前 chat 的订阅列表
+func listSubscriptions(bot *Bot, msg *Message) {
+	l := langFor(msg.Chat.ID)
+	subs, err := jobQueue.Subscriptions(msg.Chat.ID)
+	if err != nil {
+		logger.Error("failed to load subscriptions", "chat_id", msg.Chat.ID, "error", err)
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.Sprintf("获取订阅列表失败: %v", err))
+		return
+	}
+	if len(subs) == 0 {
+		bot.sendReply(msg.Chat.ID, msg.MessageID, l.T("还没有订阅。用法：/subscribe <作者主页链接>"))
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(l.Sprintf("当前 %d 个订阅：\n", len(subs)))
+	for i, sub := range subs {
+		lastSeen := l.T("尚未检查成功")
+		if !sub.LastSeen.IsZero() {
+			lastSeen = l.T("上次检查 ") + sub.LastSeen.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(&b, "\n%d. %s\n%s\n", i+1, sub.URL, lastSeen)
+	}
+	bot.sendReply(msg.Chat.ID, msg.MessageID, b.String())
+}
+
+// runSubscriptions 每隔 SUBSCRIPTION_INTERVAL 为所有订阅排队一次检查，直到 ctx 被取消
+func runSubscriptions(ctx context.Context) {
+	ticker := time.NewTicker(cfg.SubscribeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		subs, err := jobQueue.Subscriptions(0)
+		if err != nil {
+			logger.Error("failed to load subscriptions", "error", err)
+			continue
+		}
+		for _, sub := range subs {
+			checkSubscription(sub)
+		}
+	}
+}
+
+// checkSubscription 把订阅的作者主页加入下载队列，archive 保证只下载新作品。
+// 上一次检查还没结束时跳过，避免下载慢的订阅在队列中堆积
+func checkSubscription(sub queue.Subscription) {
+	busy, err := jobQueue.SubscriptionBusy(sub.ID)
+	if err != nil {
+		logger.Error("failed to check subscription jobs", "subscription", sub.ID, "error", err)
+		return
+	}
+	if busy {
+		logger.Info("previous subscription check still running, skipping", "subscription", sub.ID, "url", sub.URL)
+		return
+	}
+
+	// 作者主页的 URL 不变，跳过已下载去重，由 archive 负责去重
+	job := queue.Job{URL: sub.URL, ChatID: sub.ChatID, Force: true, Bot: sub.Bot, Subscription: sub.ID}
+	if _, err := jobQueue.EnqueueJob(job); err != nil {
+		logger.Error("failed to enqueue subscription check", "subscription", sub.ID, "url", sub.URL, "error", err)
+		return
+	}
+	logger.Debug("subscription check queued", "subscription", sub.ID, "url", sub.URL, "chat_id", sub.ChatID)
+}
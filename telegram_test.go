@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deckvig/telegram-bot/download"
+)
+
+// apiCall 是 fakeTelegram 收到的一次 Bot API 请求
+type apiCall struct {
+	Method  string
+	Query   url.Values
+	Payload map[string]any // JSON 请求体，multipart 请求为 nil
+}
+
+// fakeTelegram 是测试用的 Bot API 服务：记录收到的请求，按 method 返回预设的响应
+type fakeTelegram struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	calls    []apiCall
+	handlers map[string]http.HandlerFunc
+	nextID   int64
+	notify   chan struct{}
+}
+
+// newFakeTelegram 启动服务，测试结束时关闭。没有设置 handler 的 method 返回 ok:true，
+// sendMessage 返回递增的 message_id
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	t.Helper()
+	f := &fakeTelegram{handlers: make(map[string]http.HandlerFunc), nextID: 100, notify: make(chan struct{}, 1)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeTelegram) serve(w http.ResponseWriter, r *http.Request) {
+	method := path.Base(r.URL.Path)
+	call := apiCall{Method: method, Query: r.URL.Query()}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &call.Payload)
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	handler := f.handlers[method]
+	f.nextID++
+	id := f.nextID
+	f.mu.Unlock()
+	select {
+	case f.notify <- struct{}{}:
+	default:
+	}
+
+	if handler != nil {
+		handler(w, r)
+		return
+	}
+	var result any = true
+	switch method {
+	case "getMe":
+		result = map[string]any{"id": 1, "is_bot": true, "first_name": "test", "username": "test_bot"}
+	case "getUpdates":
+		result = []any{}
+	case "sendMessage":
+		result = map[string]any{"message_id": id}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "result": result})
+}
+
+// handle 替换 method 的响应
+func (f *fakeTelegram) handle(method string, handler http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[method] = handler
+}
+
+// callsTo 返回目前收到的 method 请求
+func (f *fakeTelegram) callsTo(method string) []apiCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []apiCall
+	for _, c := range f.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// sentTexts 返回所有 sendMessage 的 text
+func (f *fakeTelegram) sentTexts() []string {
+	var texts []string
+	for _, c := range f.callsTo("sendMessage") {
+		text, _ := c.Payload["text"].(string)
+		texts = append(texts, text)
+	}
+	return texts
+}
+
+// waitFor 等待直到 done 返回 true，超过 timeout 时让测试失败
+func (f *fakeTelegram) waitFor(t *testing.T, timeout time.Duration, done func() bool) {
+	t.Helper()
+	deadline := time.After(timeout)
+	for !done() {
+		select {
+		case <-f.notify:
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("timed out after %s, calls: %+v", timeout, f.allCalls())
+		}
+	}
+}
+
+func (f *fakeTelegram) allCalls() []apiCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]apiCall(nil), f.calls...)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newTestBot 返回连接到 f 的 Bot，downloader 为 nil 时下载直接失败
+func newTestBot(t *testing.T, f *fakeTelegram, c *Config, downloader download.Downloader) *Bot {
+	t.Helper()
+	c.TelegramAPIBase = f.URL
+	b := NewBot(c, "123:test", path.Join(t.TempDir(), "last_update_id.txt"), downloader)
+	b.http = f.Client()
+	return b
+}
+
+// testMessage 返回 chatID 中的一条文本消息
+func testMessage(chatID, messageID int64, text string) *Message {
+	msg := &Message{MessageID: messageID, Text: text, Date: time.Now().Unix(), From: &User{ID: chatID}}
+	msg.Chat.ID = chatID
+	return msg
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxMediaGroupSize 是 sendMediaGroup 单次允许的最大文件数
+	maxMediaGroupSize = 10
+	// maxPhotoBytes 超过该大小的图片无法用 sendPhoto 发送，改用 sendDocument
+	maxPhotoBytes = 10 << 20
+)
+
+// uploadClient 上传大文件耗时较长，单独使用更宽松的超时
+var uploadClient = &http.Client{Timeout: 10 * time.Minute}
+
+// mediaKind 根据扩展名判断文件应使用的发送方式
+func mediaKind(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".webp":
+		return "photo"
+	case ".mp4", ".mov", ".m4v":
+		return "video"
+	default:
+		return "document"
+	}
+}
+
+// callAPIMultipart uploads files (form field -> local path) together with params as multipart/form-data
+func (b *Bot) callAPIMultipart(method string, params map[string]string, files map[string]string) (*APIResponse, error) {
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+
+	// 边读文件边上传，避免把整个文件读入内存
+	go func() {
+		writer.CloseWithError(writeMultipart(form, params, files))
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, b.apiURL(method), body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := uploadClient.Do(req)
+	if err != nil {
+		return nil, newTransportError(method, err)
+	}
+	return decodeAPIResponse(method, resp)
+}
+
+// writeMultipart 写入所有字段和文件并关闭 form
+func writeMultipart(form *multipart.Writer, params map[string]string, files map[string]string) error {
+	for key, value := range params {
+		if err := form.WriteField(key, value); err != nil {
+			return err
+		}
+	}
+	for field, path := range files {
+		if err := writeFilePart(form, field, path); err != nil {
+			return err
+		}
+	}
+	return form.Close()
+}
+
+func writeFilePart(form *multipart.Writer, field, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	part, err := form.CreateFormFile(field, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, f)
+	return err
+}
+
+// sendFile uploads a single file, choosing sendPhoto/sendVideo/sendDocument by extension.
+// Photos and videos Telegram refuses are sent again with sendDocument; files over the
+// upload limit are skipped with a warning.
+func (b *Bot) sendFile(chatID int64, path, caption string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() > b.cfg.MaxUploadBytes {
+		b.logger.Warn("file exceeds upload limit, skipping", "chat_id", chatID, "path", path, "size", info.Size(), "limit", b.cfg.MaxUploadBytes)
+		return fmt.Errorf("%s 大小为 %.1f MB，超过上传限制 %.1f MB", filepath.Base(path), float64(info.Size())/(1<<20), float64(b.cfg.MaxUploadBytes)/(1<<20))
+	}
+
+	kind := mediaKind(path)
+	if kind == "photo" && info.Size() > maxPhotoBytes {
+		kind = "document"
+	}
+
+	method := map[string]string{"photo": "sendPhoto", "video": "sendVideo", "document": "sendDocument"}[kind]
+	params := map[string]string{"chat_id": strconv.FormatInt(chatID, 10)}
+	if kind == "video" {
+		params["supports_streaming"] = "true"
+	}
+	if caption != "" {
+		params["caption"] = caption
+	}
+
+	_, err = b.callAPIMultipart(method, params, map[string]string{kind: path})
+	// 尺寸或编码不被 sendPhoto/sendVideo 接受的文件仍然可以作为普通文件发送
+	var apiErr *APIError
+	if kind != "document" && errors.As(err, &apiErr) && apiErr.IsBadRequest() {
+		b.logger.Warn("telegram rejected media, sending as document", "chat_id", chatID, "path", path, "method", method, "error", err)
+		delete(params, "supports_streaming")
+		method = "sendDocument"
+		_, err = b.callAPIMultipart(method, params, map[string]string{"document": path})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", filepath.Base(path), err)
+	}
+	b.logger.Info("file uploaded", "chat_id", chatID, "path", path, "method", method)
+	return nil
+}
+
+// inputMedia is one element of the sendMediaGroup media array
+type inputMedia struct {
+	Type    string `json:"type"`
+	Media   string `json:"media"`
+	Caption string `json:"caption,omitempty"`
+}
+
+// sendMediaGroup uploads 2-10 photos/videos as a single album. Telegram shows the
+// caption of the first item as the caption of the whole album.
+func (b *Bot) sendMediaGroup(chatID int64, paths []string, caption string) error {
+	if len(paths) < 2 || len(paths) > maxMediaGroupSize {
+		return fmt.Errorf("sendMediaGroup needs 2-%d files, got %d", maxMediaGroupSize, len(paths))
+	}
+
+	media := make([]inputMedia, len(paths))
+	files := make(map[string]string, len(paths))
+	for i, path := range paths {
+		field := fmt.Sprintf("file%d", i)
+		media[i] = inputMedia{Type: mediaKind(path), Media: "attach://" + field}
+		files[field] = path
+	}
+	media[0].Caption = caption
+
+	mediaJSON, err := json.Marshal(media)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.callAPIMultipart("sendMediaGroup", map[string]string{
+		"chat_id": strconv.FormatInt(chatID, 10),
+		"media":   string(mediaJSON),
+	}, files)
+	if err != nil {
+		return fmt.Errorf("failed to upload album: %w", err)
+	}
+	b.logger.Info("album uploaded", "chat_id", chatID, "count", len(paths))
+	return nil
+}
+
+// uploadFiles 把下载得到的文件发回 chat：图片和视频每 10 个组成一个相册，其余文件逐个发送。
+// captions 是每个文件的 caption，相册使用其中第一个非空的。返回每个失败文件对应的错误
+func (b *Bot) uploadFiles(chatID int64, paths []string, captions map[string]string) []error {
+	var errs []error
+	var album, single []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// 超出大小限制或只能作为文件发送的，单独处理
+		kind := mediaKind(path)
+		if kind == "document" || info.Size() > b.cfg.MaxUploadBytes || (kind == "photo" && info.Size() > maxPhotoBytes) {
+			single = append(single, path)
+		} else {
+			album = append(album, path)
+		}
+	}
+
+	for start := 0; start < len(album); start += maxMediaGroupSize {
+		chunk := album[start:min(start+maxMediaGroupSize, len(album))]
+		if len(chunk) == 1 {
+			single = append(single, chunk[0])
+			continue
+		}
+		if err := b.sendMediaGroup(chatID, chunk, albumCaption(chunk, captions)); err != nil {
+			// 相册失败时退回逐个发送
+			b.logger.Warn("album upload failed, sending files one by one", "chat_id", chatID, "error", err)
+			single = append(single, chunk...)
+		}
+	}
+
+	for _, path := range single {
+		if err := b.sendFile(chatID, path, captions[path]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// uploadFailureText 返回上传失败时回复给用户的文字。超时、连接中断等网络错误只说明原因，
+// 详细的错误只写入日志
+func uploadFailureText(l lang, err error) string {
+	var transportErr *TransportError
+	if errors.As(err, &transportErr) {
+		return l.T("文件发送失败: 连接 Telegram 超时或中断，请稍后重试")
+	}
+	return l.Sprintf("文件发送失败: %v", err)
+}
+
+// albumCaption 返回 paths 中第一个有 caption 的文件的 caption
+func albumCaption(paths []string, captions map[string]string) string {
+	for _, path := range paths {
+		if caption := captions[path]; caption != "" {
+			return caption
+		}
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadTransportErrorHidesToken(t *testing.T) {
+	withTestConfig(t)
+	f := newFakeTelegram(t)
+	b := newTestBot(t, f, cfg, nil)
+	// 上传的连接在响应前断开
+	f.handle("sendDocument", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(path, []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := b.sendFile(5, path, "")
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) {
+		t.Fatalf("sendFile() error = %v, want TransportError", err)
+	}
+	if strings.Contains(err.Error(), "123:test") {
+		t.Errorf("sendFile() error = %v, contains the bot token", err)
+	}
+	if got, want := uploadFailureText("zh", err), "文件发送失败: 连接 Telegram 超时或中断，请稍后重试"; got != want {
+		t.Errorf("uploadFailureText() = %q, want %q", got, want)
+	}
+}
+
+func TestUploadFailureText(t *testing.T) {
+	err := errors.New("notes.txt 大小为 60.0 MB，超过上传限制 50.0 MB")
+	if got, want := uploadFailureText("zh", err), "文件发送失败: "+err.Error(); got != want {
+		t.Errorf("uploadFailureText() = %q, want %q", got, want)
+	}
+}
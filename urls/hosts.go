@@ -0,0 +1,39 @@
+package urls
+
+import (
+	"net/url"
+	"strings"
+)
+
+// HostFilter decides which URLs may be downloaded by their host. Entries match the
+// host itself and all of its subdomains, e.g. "xiaohongshu.com" also matches
+// "www.xiaohongshu.com". Denied takes precedence; an empty Allowed allows every host.
+type HostFilter struct {
+	Allowed []string
+	Denied  []string
+}
+
+// Allow reports whether rawURL passes the filter. URLs that cannot be parsed or
+// have no host are rejected.
+func (f HostFilter) Allow(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if matchHost(host, f.Denied) {
+		return false
+	}
+	return len(f.Allowed) == 0 || matchHost(host, f.Allowed)
+}
+
+// matchHost 判断 host 是否等于 domains 中的某一项或是它的子域名
+func matchHost(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.Trim(strings.TrimSpace(domain), "."))
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return true
+		}
+	}
+	return false
+}
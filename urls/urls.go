@@ -0,0 +1,90 @@
+// Package urls 从聊天消息文本（例如小红书分享文本）中提取 URL
+package urls
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultPattern 匹配 http 或 https 开头的 URL，遇到空白、全角标点或尖括号、引号时结束
+const DefaultPattern = `https?://[^\s，。、；：！？“”‘’（）【】《》「」<>"]+`
+
+var urlRegex = regexp.MustCompile(DefaultPattern)
+
+// DefaultTrailing 是 URL 末尾常见、但不属于 URL 的字符，包括 Markdown 的强调标记和中文标点
+const DefaultTrailing = `.,;:!?'"*_~` + "`" + "。，、；：！？…"
+
+// closingBrackets 映射右括号到对应的左括号，只有在 URL 中不成对时才去掉
+var closingBrackets = map[rune]rune{
+	')': '(',
+	']': '[',
+	'}': '{',
+}
+
+// Extractor 从文本中提取 URL，Trailing 是需要从 URL 末尾去掉的字符，
+// Pattern 为空时使用 DefaultPattern，JoinWrapped 为 true 时先用 JoinWrapped 拼接被折行的 URL
+type Extractor struct {
+	Trailing    string
+	Pattern     *regexp.Regexp
+	JoinWrapped bool
+}
+
+// Extract 使用 DefaultTrailing 提取 text 中所有的 URL
+func Extract(text string) []string {
+	return Extractor{Trailing: DefaultTrailing}.Extract(text)
+}
+
+// Extract 提取 text 中所有的 URL，去掉末尾的标点和 Markdown 标记，保持出现顺序并去重
+func (e Extractor) Extract(text string) []string {
+	pattern := e.Pattern
+	if pattern == nil {
+		pattern = urlRegex
+	}
+	if e.JoinWrapped {
+		text = JoinWrapped(text)
+	}
+	var found []string
+	for _, match := range pattern.FindAllString(text, -1) {
+		if u := e.trim(match); u != "" {
+			found = append(found, u)
+		}
+	}
+	return Merge(found)
+}
+
+// Merge 合并多个 URL 列表，保持首次出现的顺序并去掉重复项
+func Merge(lists ...[]string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, list := range lists {
+		for _, u := range list {
+			if !seen[u] {
+				seen[u] = true
+				merged = append(merged, u)
+			}
+		}
+	}
+	return merged
+}
+
+// trim 反复去掉 u 末尾的标点和不成对的右括号，例如 Markdown 链接 [标题](https://...) 末尾的 )
+func (e Extractor) trim(u string) string {
+	for len(u) > 0 {
+		last, size := utf8.DecodeLastRuneInString(u)
+		if strings.ContainsRune(e.Trailing, last) {
+			u = u[:len(u)-size]
+			continue
+		}
+		if open, ok := closingBrackets[last]; ok && strings.Count(u, string(open)) < strings.Count(u, string(last)) {
+			u = u[:len(u)-size]
+			continue
+		}
+		break
+	}
+	// 只剩下 scheme 时不是有效的 URL
+	if strings.HasSuffix(u, "://") {
+		return ""
+	}
+	return u
+}
@@ -0,0 +1,121 @@
+package urls
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "share text",
+			text: "64 小红书用户发布了一篇小红书笔记，快来看吧！ 😆 AbCdEf12 😆 http://xhslink.com/a/Xy9kQ2，复制本条信息，打开【小红书】App查看精彩内容！",
+			want: []string{"http://xhslink.com/a/Xy9kQ2"},
+		},
+		{
+			name: "share text with title",
+			text: "【周末探店🍰 | 这家甜品店也太好拍了吧！ - 小甜甜 | 小红书 - 你的生活指南】 😆 ZmT4pQ 😆 https://www.xiaohongshu.com/discovery/item/6650a1b2000000001e03c4d5?source=webshare&xhsshare=pc_web&xsec_token=ABcd-EF_gh=&xsec_source=pc_share",
+			want: []string{"https://www.xiaohongshu.com/discovery/item/6650a1b2000000001e03c4d5?source=webshare&xhsshare=pc_web&xsec_token=ABcd-EF_gh=&xsec_source=pc_share"},
+		},
+		{
+			name: "no url",
+			text: "复制本条信息，打开【小红书】App查看精彩内容！",
+		},
+		{
+			name: "scheme only",
+			text: "看看 https:// 这个",
+		},
+		{
+			name: "several urls keep order and drop duplicates",
+			text: "https://xhslink.com/a/2 https://xhslink.com/a/1\nhttps://xhslink.com/a/2。",
+			want: []string{"https://xhslink.com/a/2", "https://xhslink.com/a/1"},
+		},
+		{
+			name: "chinese period",
+			text: "链接：http://xhslink.com/a/AbC。",
+			want: []string{"http://xhslink.com/a/AbC"},
+		},
+		{
+			name: "chinese brackets",
+			text: "（http://xhslink.com/a/AbC）【https://xhslink.com/a/Def】",
+			want: []string{"http://xhslink.com/a/AbC", "https://xhslink.com/a/Def"},
+		},
+		{
+			name: "markdown link",
+			text: "[好看的笔记](https://www.xiaohongshu.com/explore/abc)",
+			want: []string{"https://www.xiaohongshu.com/explore/abc"},
+		},
+		{
+			name: "markdown emphasis",
+			text: "**https://xhslink.com/a/bold** _https://xhslink.com/a/em_ `https://xhslink.com/a/code`",
+			want: []string{"https://xhslink.com/a/bold", "https://xhslink.com/a/em", "https://xhslink.com/a/code"},
+		},
+		{
+			name: "angle brackets and quotes",
+			text: `<https://xhslink.com/a/1> "https://xhslink.com/a/2"`,
+			want: []string{"https://xhslink.com/a/1", "https://xhslink.com/a/2"},
+		},
+		{
+			name: "emoji right after url",
+			text: "http://xhslink.com/a/AbC 😆😆",
+			want: []string{"http://xhslink.com/a/AbC"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Extract(tt.text); !slices.Equal(got, tt.want) {
+				t.Errorf("Extract(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	got := Merge([]string{"a", "b"}, nil, []string{"b", "c", "a"})
+	if want := []string{"a", "b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("Merge() = %q, want %q", got, want)
+	}
+}
+
+func TestExtractorTrim(t *testing.T) {
+	tests := []struct {
+		name     string
+		trailing string
+		text     string
+		want     []string
+	}{
+		{"parentheses", DefaultTrailing, "(see https://xhslink.com/a/AbC)", []string{"https://xhslink.com/a/AbC"}},
+		{"parentheses and period", DefaultTrailing, "(https://xhslink.com/a/AbC).", []string{"https://xhslink.com/a/AbC"}},
+		{"balanced parentheses kept", DefaultTrailing, "https://en.wikipedia.org/wiki/Go_(programming_language)", []string{"https://en.wikipedia.org/wiki/Go_(programming_language)"}},
+		{"square brackets", DefaultTrailing, "[https://xhslink.com/a/AbC]", []string{"https://xhslink.com/a/AbC"}},
+		{"braces", DefaultTrailing, "{https://xhslink.com/a/AbC}", []string{"https://xhslink.com/a/AbC"}},
+		{"chinese enumeration comma", DefaultTrailing, "https://xhslink.com/a/1、https://xhslink.com/a/2", []string{"https://xhslink.com/a/1", "https://xhslink.com/a/2"}},
+		{"chinese exclamation and ellipsis", DefaultTrailing, "https://xhslink.com/a/AbC！！…", []string{"https://xhslink.com/a/AbC"}},
+		{"ascii punctuation", DefaultTrailing, "https://xhslink.com/a/AbC?!.", []string{"https://xhslink.com/a/AbC"}},
+		{"query string kept", DefaultTrailing, "https://www.xiaohongshu.com/explore/abc?xsec_token=AB=&source=web.", []string{"https://www.xiaohongshu.com/explore/abc?xsec_token=AB=&source=web"}},
+		{"custom trailing", "=", "https://example.com/a?b=", []string{"https://example.com/a?b"}},
+		{"empty trailing keeps punctuation", "", "https://example.com/a.", []string{"https://example.com/a."}},
+		{"empty trailing still drops unbalanced bracket", "", "(https://example.com/a)", []string{"https://example.com/a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := Extractor{Trailing: tt.trailing}
+			if got := e.Extract(tt.text); !slices.Equal(got, tt.want) {
+				t.Errorf("Extractor{Trailing: %q}.Extract(%q) = %q, want %q", tt.trailing, tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractorPattern(t *testing.T) {
+	e := Extractor{Trailing: DefaultTrailing, Pattern: regexp.MustCompile(`https?://xhslink\.com/\S+`)}
+	got := e.Extract("https://example.com/a https://xhslink.com/a/AbC。")
+	if want := []string{"https://xhslink.com/a/AbC"}; !slices.Equal(got, want) {
+		t.Errorf("Extract() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,55 @@
+package urls
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// wrappedTailRegex 匹配行末尚未结束的 URL：只包含可见 ASCII 字符，遇到中文标点说明 URL 已经结束
+	wrappedTailRegex = regexp.MustCompile(`https?://[!-~]*$`)
+	// continuationRegex 匹配下一行开头可以作为 URL 后半部分的内容
+	continuationRegex = regexp.MustCompile(`^[A-Za-z0-9\-._~%/?#&=+:@!$*,;]+`)
+)
+
+// midURLChars 出现在行末时说明 URL 明显还没有结束
+const midURLChars = `/?&=#%-_+:@`
+
+// JoinWrapped 把被客户端折行拆开的 URL 重新拼接起来。只有同时满足以下条件时才删除换行：
+//   - 换行前的一行以 http:// 或 https:// 开头的 URL 结束，URL 中只有 ASCII 字符；
+//   - 下一行以 URL 字符开头，前面没有空白，也不是另一个 http(s):// 链接；
+//   - 换行前的 URL 以 / ? & = # % - _ + : @ 之一结束，或者下一行开头的部分包含 / ? & = # %。
+//
+// 例如 "https://www.xiaohongshu.com/explore/\nabc123" 和 "https://xhslink.com/a?x=1&\ny=2" 会被拼接，
+// "https://xhslink.com/abc\n好看" 和 "https://xhslink.com/abc\nhello" 不会。空行和 \r\n 前后的内容不会被拼接
+func JoinWrapped(text string) string {
+	lines := strings.Split(text, "\n")
+	var b strings.Builder
+	b.WriteString(lines[0])
+	current := lines[0]
+	for _, next := range lines[1:] {
+		if joinsWith(current, next) {
+			b.WriteString(next)
+			current += next
+			continue
+		}
+		b.WriteString("\n")
+		b.WriteString(next)
+		current = next
+	}
+	return b.String()
+}
+
+// joinsWith 判断 line 末尾的 URL 是否应该与 next 拼接
+func joinsWith(line, next string) bool {
+	tail := wrappedTailRegex.FindString(line)
+	if tail == "" || strings.HasSuffix(tail, "://") {
+		return false
+	}
+	cont := continuationRegex.FindString(next)
+	if cont == "" || strings.HasPrefix(cont, "http://") || strings.HasPrefix(cont, "https://") {
+		return false
+	}
+	last := tail[len(tail)-1:]
+	return strings.Contains(midURLChars, last) || strings.ContainsAny(cont, "/?&=#%")
+}
@@ -0,0 +1,127 @@
+package urls
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestJoinWrapped(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		// 会拼接的情况
+		{
+			name: "line ends with slash",
+			text: "https://www.xiaohongshu.com/explore/\n6650a1b2000000001e03c4d5",
+			want: "https://www.xiaohongshu.com/explore/6650a1b2000000001e03c4d5",
+		},
+		{
+			name: "line ends with ampersand",
+			text: "https://xhslink.com/a?x=1&\ny=2",
+			want: "https://xhslink.com/a?x=1&y=2",
+		},
+		{
+			name: "line ends with equals sign",
+			text: "https://www.xiaohongshu.com/explore/abc?xsec_token=\nABcd",
+			want: "https://www.xiaohongshu.com/explore/abc?xsec_token=ABcd",
+		},
+		{
+			name: "next line continues the path",
+			text: "https://www.xiaohongshu.com/disc\novery/item/abc",
+			want: "https://www.xiaohongshu.com/discovery/item/abc",
+		},
+		{
+			name: "next line continues the query",
+			text: "https://www.xiaohongshu.com/explore/abc?source=web\nshare&xhsshare=pc_web",
+			want: "https://www.xiaohongshu.com/explore/abc?source=webshare&xhsshare=pc_web",
+		},
+		{
+			name: "wrapped more than once",
+			text: "https://www.xiaohongshu.com/\nexplore/\nabc",
+			want: "https://www.xiaohongshu.com/explore/abc",
+		},
+		{
+			name: "text after the continuation stays",
+			text: "看看 https://www.xiaohongshu.com/explore/\nabc，复制本条信息",
+			want: "看看 https://www.xiaohongshu.com/explore/abc，复制本条信息",
+		},
+
+		// 不会拼接的情况
+		{
+			name: "next line is a word",
+			text: "https://xhslink.com/abc\nhello",
+			want: "https://xhslink.com/abc\nhello",
+		},
+		{
+			name: "next line is chinese",
+			text: "https://xhslink.com/abc\n好看",
+			want: "https://xhslink.com/abc\n好看",
+		},
+		{
+			name: "next line is another url",
+			text: "https://xhslink.com/a/\nhttps://xhslink.com/b",
+			want: "https://xhslink.com/a/\nhttps://xhslink.com/b",
+		},
+		{
+			name: "next line starts with a space",
+			text: "https://www.xiaohongshu.com/explore/\n abc",
+			want: "https://www.xiaohongshu.com/explore/\n abc",
+		},
+		{
+			name: "empty line",
+			text: "https://www.xiaohongshu.com/explore/\n\nabc",
+			want: "https://www.xiaohongshu.com/explore/\n\nabc",
+		},
+		{
+			name: "crlf",
+			text: "https://www.xiaohongshu.com/explore/\r\nabc",
+			want: "https://www.xiaohongshu.com/explore/\r\nabc",
+		},
+		{
+			name: "url ended with chinese punctuation",
+			text: "https://www.xiaohongshu.com/explore/，\nabc",
+			want: "https://www.xiaohongshu.com/explore/，\nabc",
+		},
+		{
+			name: "url followed by text on the same line",
+			text: "https://www.xiaohongshu.com/explore/ 看\nabc/def",
+			want: "https://www.xiaohongshu.com/explore/ 看\nabc/def",
+		},
+		{
+			name: "scheme only",
+			text: "https://\nxhslink.com/a",
+			want: "https://\nxhslink.com/a",
+		},
+		{
+			name: "no url",
+			text: "第一行/\n第二行",
+			want: "第一行/\n第二行",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := JoinWrapped(tt.text); got != tt.want {
+				t.Errorf("JoinWrapped(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractorJoinWrapped(t *testing.T) {
+	text := "😆 ZmT4pQ 😆 https://www.xiaohongshu.com/explore/\n6650a1b2000000001e03c4d5?xsec_token=AB\n复制本条信息"
+	tests := []struct {
+		join bool
+		want []string
+	}{
+		{false, []string{"https://www.xiaohongshu.com/explore/"}},
+		{true, []string{"https://www.xiaohongshu.com/explore/6650a1b2000000001e03c4d5?xsec_token=AB"}},
+	}
+	for _, tt := range tests {
+		e := Extractor{JoinWrapped: tt.join}
+		if got := e.Extract(text); !slices.Equal(got, tt.want) {
+			t.Errorf("Extract() with JoinWrapped %v = %q, want %q", tt.join, got, tt.want)
+		}
+	}
+}
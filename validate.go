@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/deckvig/telegram-bot/download"
+)
+
+// validation 是 -validate 的一项检查，返回结果说明；返回错误表示检查未通过
+type validation struct {
+	name  string
+	check func(ctx context.Context) (string, error)
+}
+
+// runValidation 执行 -validate：配置已经加载成功，再检查 token、gallery-dl、代理和后端，
+// 把报告写入 w。全部通过时返回 0，否则返回 1，可以用作容器的就绪检查或部署前的 CI 检查
+func runValidation(w io.Writer) int {
+	fmt.Fprintln(w, "OK    配置: 已加载并通过校验")
+
+	var checks []validation
+	for _, bot := range newBots(cfg, nil) {
+		checks = append(checks, validation{"Telegram token " + bot.ID, func(context.Context) (string, error) {
+			me, err := bot.getMe()
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("@%s（%d）", me.Username, me.ID), nil
+		}})
+	}
+	checks = append(checks,
+		validation{"gallery-dl", validateGalleryDL},
+		validation{"代理", validateProxy},
+		validation{"后端", validateBackend},
+	)
+
+	failed := 0
+	for _, v := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), diagTimeout)
+		result, err := v.check(ctx)
+		cancel()
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL  %s: %v\n", v.name, err)
+			continue
+		}
+		fmt.Fprintf(w, "OK    %s: %s\n", v.name, result)
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(w, "\n%d 项检查未通过\n", failed)
+		return 1
+	}
+	fmt.Fprintln(w, "\n全部检查通过")
+	return 0
+}
+
+// validateGalleryDL 在 gallery-dl 模式下要求 gallery-dl 已安装
+func validateGalleryDL(ctx context.Context) (string, error) {
+	path, version, err := download.GalleryDLVersion(ctx)
+	switch {
+	case errors.Is(err, download.ErrNotInstalled) && cfg.DownloadMode != download.ModeGalleryDL:
+		return "未安装（backend 模式不需要）", nil
+	case err != nil:
+		return "", err
+	}
+	return fmt.Sprintf("%s，版本 %s", path, version), nil
+}
+
+// validateProxy 检查配置的代理能否建立 TCP 连接
+func validateProxy(ctx context.Context) (string, error) {
+	if cfg.Proxy == "" {
+		return "未配置", nil
+	}
+	if err := download.CheckProxy(ctx, cfg.Proxy); err != nil {
+		return "", fmt.Errorf("无法连接: %w", err)
+	}
+	return "可以连接", nil
+}
+
+// checkRequiredProxies 在 REQUIRE_PROXY 时检查全局和每个 chat 的代理都可以连接，任何一个不可用都拒绝启动
+func checkRequiredProxies(ctx context.Context) error {
+	proxies := []string{cfg.Proxy}
+	for _, proxy := range cfg.ChatProxies {
+		if !slices.Contains(proxies, proxy) {
+			proxies = append(proxies, proxy)
+		}
+	}
+	for _, proxy := range proxies {
+		checkCtx, cancel := context.WithTimeout(ctx, diagTimeout)
+		err := download.CheckProxy(checkCtx, proxy)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("require_proxy is set but proxy %s is unreachable: %w", download.Redact(proxy), err)
+		}
+	}
+	return nil
+}
+
+// validateBackend 在 backend 模式下检查 BACKEND_URL 能否访问
+func validateBackend(ctx context.Context) (string, error) {
+	if cfg.DownloadMode != download.ModeBackend {
+		return "gallery-dl 模式不使用", nil
+	}
+	status, err := download.CheckBackend(ctx, cfg.BackendURL, cfg.Proxy)
+	if err != nil {
+		return "", fmt.Errorf("无法访问: %w", err)
+	}
+	return "可以访问，HTTP " + status, nil
+}
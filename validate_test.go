@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/deckvig/telegram-bot/download"
+)
+
+func TestValidationHidesToken(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	c := withTestConfig(t)
+	c.DownloadMode = download.ModeGalleryDL
+	// Telegram 无法连接，getMe 返回网络错误
+	f := newFakeTelegram(t)
+	c.TelegramAPIBase = f.URL
+	f.Close()
+
+	var out bytes.Buffer
+	if code := runValidation(&out); code != 1 {
+		t.Errorf("runValidation() = %d, want 1", code)
+	}
+	report := out.String()
+	if !strings.Contains(report, "FAIL  Telegram token") {
+		t.Errorf("report does not fail the token check:\n%s", report)
+	}
+	if strings.Contains(report, c.BotToken) {
+		t.Errorf("report contains the bot token:\n%s", report)
+	}
+}
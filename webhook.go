@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// secretTokenHeader 是 Telegram 回传 secret_token 的请求头
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// setWebhook registers the webhook URL with Telegram. A non-empty secret is
+// sent back by Telegram in the secretTokenHeader of every update
+func (b *Bot) setWebhook(webhookURL, secret string) error {
+	params := map[string]interface{}{
+		"url": webhookURL,
+	}
+	if secret != "" {
+		params["secret_token"] = secret
+	}
+	if types := b.cfg.allowedUpdates(); len(types) > 0 {
+		params["allowed_updates"] = types
+	}
+	_, err := b.callAPI("setWebhook", params)
+	return err
+}
+
+// deleteWebhook removes a previously registered webhook so getUpdates can be used
+func (b *Bot) deleteWebhook() error {
+	_, err := b.callAPI("deleteWebhook", map[string]interface{}{})
+	return err
+}
+
+// webhookHandler 解析 Telegram 推送给 bot 的 update 并异步处理。
+// secret 不为空时拒绝请求头不匹配的请求，防止猜到 URL 的人伪造 update
+func webhookHandler(bot *Bot, secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		remoteAddr := clientAddr(r)
+		if secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(secretTokenHeader)), []byte(secret)) != 1 {
+			bot.logger.Warn("rejected webhook request with invalid secret token", "remote_addr", remoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var update Update
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			bot.logger.Warn("failed to decode webhook update", "remote_addr", remoteAddr, "error", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		bot.logger.Info("received webhook update", "update_id", update.UpdateID, "remote_addr", remoteAddr)
+		// 下载可能耗时很久，先给 Telegram 返回 200，避免重复推送
+		w.WriteHeader(http.StatusOK)
+		go bot.safeHandleUpdate(update)
+	}
+}
+
+// clientAddr 返回请求的客户端地址。只有设置了 TRUST_PROXY 时才相信代理添加的请求头，
+// 否则任何人都可以通过伪造 X-Forwarded-For 隐藏自己的地址
+func clientAddr(r *http.Request) string {
+	if cfg.TrustProxy {
+		// 代理把对端地址追加在 X-Forwarded-For 末尾，前面的部分可能是客户端伪造的
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			if addr := strings.TrimSpace(hops[len(hops)-1]); addr != "" {
+				return addr
+			}
+		}
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+			return realIP
+		}
+	}
+	return r.RemoteAddr
+}
+
+// runWebhook 注册 webhook 并启动 HTTP 服务接收 update，直到 ctx 被取消
+func runWebhook(ctx context.Context) error {
+	base, err := url.Parse(cfg.WebhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid WEBHOOK_URL %q: %w", cfg.WebhookURL, err)
+	}
+
+	mux := http.NewServeMux()
+	for _, bot := range bots {
+		// 多个 bot 时在 WEBHOOK_URL 后追加各自的 ID 区分推送来源
+		u := base
+		if len(bots) > 1 {
+			u = base.JoinPath(bot.ID)
+		}
+		path := u.Path
+		if path == "" {
+			path = "/"
+		}
+
+		if err := bot.setWebhook(u.String(), cfg.WebhookSecret); err != nil {
+			return fmt.Errorf("failed to set webhook for bot %s: %w", bot.ID, err)
+		}
+		bot.logger.Info("webhook registered", "path", path)
+		mux.HandleFunc(path, webhookHandler(bot, cfg.WebhookSecret))
+	}
+	if cfg.MetricsPort == cfg.Port {
+		mux.Handle("/metrics", promhttp.Handler())
+	}
+
+	server := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	logger.Info("listening for webhook updates", "port", cfg.Port)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return fmt.Errorf("webhook server stopped: %w", err)
+	}
+	return nil
+}